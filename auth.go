@@ -0,0 +1,334 @@
+package rest
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Errors of the authentication.
+var (
+	// ErrNoCredentials is returned by an Authenticator if the request carries no credentials of its scheme.
+	ErrNoCredentials = errors.New("no credentials")
+	// ErrInvalidCredentials is reported with status 401 if the credentials of a request are not valid.
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+// principalKey defines the context key of the authenticated principal
+type principalKey struct{}
+
+// Identity is an authenticated principal.
+type Identity struct {
+	// ID identifies the principal, e.g. a user name or the subject of a token.
+	ID string
+	// Scheme is the scheme the principal was authenticated with, e.g. Basic, Bearer or APIKey.
+	Scheme string
+	Roles  []string
+	Scopes []string
+	// Attributes are further properties of the principal, e.g. the claims of a token.
+	Attributes map[string]interface{}
+}
+
+// Principal returns the principal the request was authenticated as, nil if there is none.
+func Principal(ctx context.Context) *Identity {
+	id, _ := ctx.Value(principalKey{}).(*Identity)
+	return id
+}
+
+func withPrincipal(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, principalKey{}, id)
+}
+
+// Authenticator authenticates requests by a scheme.
+type Authenticator interface {
+	// Authenticate returns the principal of the request. It returns
+	// ErrNoCredentials if the request carries no credentials of the scheme and
+	// an error with status 401 if they are invalid.
+	Authenticate(r *http.Request) (*Identity, error)
+	// Challenge returns the WWW-Authenticate challenge of the scheme, empty if there is none.
+	Challenge() string
+}
+
+// AnyOf combines authenticators so requests can use any of their schemes.
+// The first authenticator finding credentials in the request decides.
+func AnyOf(authenticators ...Authenticator) Authenticator {
+	return anyAuthenticator(authenticators)
+}
+
+type anyAuthenticator []Authenticator
+
+func (as anyAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	for _, a := range as {
+		id, err := a.Authenticate(r)
+		if errors.Is(err, ErrNoCredentials) {
+			continue
+		}
+		return id, err
+	}
+	return nil, ErrNoCredentials
+}
+
+func (as anyAuthenticator) Challenge() string {
+	var challenges []string
+	for _, a := range as {
+		if c := a.Challenge(); c != "" {
+			challenges = append(challenges, c)
+		}
+	}
+	return strings.Join(challenges, ", ")
+}
+
+// NewAuthentication creates a middleware authenticating requests. Routes can
+// override the authenticator by Register.Authenticator. The principal is
+// available by Principal. Requests without valid credentials are answered
+// with status 401 and the WWW-Authenticate challenges through the ErrorHandler.
+func NewAuthentication(a Authenticator) Middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			a := a
+			if rt, ok := r.Context().Value(routeKey{}).(*route); ok && rt.authenticator != nil {
+				a = rt.authenticator
+			}
+			if a == nil {
+				next(w, r)
+				return
+			}
+
+			id, err := a.Authenticate(r)
+			if errors.Is(err, ErrNoCredentials) {
+				err = NewStatusError(http.StatusUnauthorized, err)
+			}
+			if err != nil {
+				if ErrorStatus(err) == http.StatusUnauthorized {
+					if c := a.Challenge(); c != "" {
+						w.Header().Set(headerWWWAuthenticate, c)
+					}
+				}
+				HandleError(w, r, err)
+				return
+			}
+
+			next(w, r.WithContext(withPrincipal(r.Context(), id)))
+		}
+	}
+}
+
+// HashAPIKey returns the hash API keys are stored with in an APIKeyStore.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// APIKeyStore looks up principals by the hash of their API key, see HashAPIKey.
+// It returns nil if the key is unknown. Implementations must be safe for concurrent use.
+type APIKeyStore interface {
+	LookupAPIKey(ctx context.Context, hash string) (*Identity, error)
+}
+
+// MemoryAPIKeyStore is an in-memory APIKeyStore. Only the hashes of the keys are kept.
+type MemoryAPIKeyStore struct {
+	mu   sync.RWMutex
+	keys []apiKeyEntry
+}
+
+type apiKeyEntry struct {
+	hash []byte
+	id   *Identity
+}
+
+// NewMemoryAPIKeyStore creates a new MemoryAPIKeyStore.
+func NewMemoryAPIKeyStore() *MemoryAPIKeyStore {
+	return &MemoryAPIKeyStore{}
+}
+
+// Add adds the principal with the hash of its API key.
+func (s *MemoryAPIKeyStore) Add(hash string, id *Identity) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = append(s.keys, apiKeyEntry{hash: []byte(hash), id: id})
+}
+
+// Remove removes the API key with the hash.
+func (s *MemoryAPIKeyStore) Remove(hash string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, e := range s.keys {
+		if string(e.hash) == hash {
+			s.keys = append(s.keys[:i], s.keys[i+1:]...)
+			return
+		}
+	}
+}
+
+// LookupAPIKey compares the hash with all keys in constant time.
+func (s *MemoryAPIKeyStore) LookupAPIKey(ctx context.Context, hash string) (*Identity, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var found *Identity
+	for _, e := range s.keys {
+		if subtle.ConstantTimeCompare(e.hash, []byte(hash)) == 1 {
+			found = e.id
+		}
+	}
+	return found, nil
+}
+
+// APIKeyOptions represents the available API key options. The key is taken
+// from the first of Header, Query and Cookie present in the request.
+type APIKeyOptions struct {
+	// Header is the name of the header carrying the key, e.g. X-API-Key.
+	Header string
+	// Query is the name of the query parameter carrying the key.
+	Query string
+	// Cookie is the name of the cookie carrying the key.
+	Cookie string
+	// Store looks up the principals by the hash of the key.
+	Store APIKeyStore
+}
+
+// NewAPIKeyAuthenticator creates an Authenticator for API keys. If no location
+// is configured the X-API-Key header is used. It panics if the Store is nil.
+func NewAPIKeyAuthenticator(opts *APIKeyOptions) Authenticator {
+	a := &apiKeyAuthenticator{}
+	if opts != nil {
+		a.opts = *opts
+	}
+	if a.opts.Store == nil {
+		panic("rest: APIKeyOptions.Store is required")
+	}
+	if a.opts.Header == "" && a.opts.Query == "" && a.opts.Cookie == "" {
+		a.opts.Header = "X-API-Key"
+	}
+	return a
+}
+
+type apiKeyAuthenticator struct {
+	opts APIKeyOptions
+}
+
+func (a *apiKeyAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	key := a.key(r)
+	if key == "" {
+		return nil, ErrNoCredentials
+	}
+
+	id, err := a.opts.Store.LookupAPIKey(r.Context(), HashAPIKey(key))
+	if err != nil {
+		return nil, err
+	}
+	if id == nil {
+		return nil, NewStatusError(http.StatusUnauthorized, ErrInvalidCredentials)
+	}
+	if id.Scheme == "" {
+		authenticated := *id
+		authenticated.Scheme = "APIKey"
+		id = &authenticated
+	}
+	return id, nil
+}
+
+func (a *apiKeyAuthenticator) key(r *http.Request) string {
+	if a.opts.Header != "" {
+		if key := r.Header.Get(a.opts.Header); key != "" {
+			return key
+		}
+	}
+	if a.opts.Query != "" {
+		if key := r.URL.Query().Get(a.opts.Query); key != "" {
+			return key
+		}
+	}
+	if a.opts.Cookie != "" {
+		if c, err := r.Cookie(a.opts.Cookie); err == nil {
+			return c.Value
+		}
+	}
+	return ""
+}
+
+// API keys have no standardized challenge.
+func (a *apiKeyAuthenticator) Challenge() string { return "" }
+
+// PasswordVerifier checks a password against its stored hash and returns an
+// error if they do not match. The password package provides one for bcrypt.
+// Implementations must compare in constant time.
+type PasswordVerifier func(hash, password []byte) error
+
+// CredentialStore looks up the password hash and the principal of a user name.
+// It returns a nil Identity if the user is unknown. Implementations must be safe for concurrent use.
+type CredentialStore interface {
+	LookupCredentials(ctx context.Context, username string) (hash []byte, id *Identity, err error)
+}
+
+// BasicOptions represents the available HTTP Basic options
+type BasicOptions struct {
+	// Realm is sent in the WWW-Authenticate challenge.
+	Realm string
+	// Store looks up the credentials of a user.
+	Store CredentialStore
+	// Verify checks the password against the hash of the store.
+	Verify PasswordVerifier
+	// DummyHash is verified for unknown users, so their responses take as long
+	// as those of known ones and don't reveal user names. It must be a hash in
+	// the format and with the cost of the store, e.g. of a random password.
+	DummyHash []byte
+}
+
+// NewBasicAuthenticator creates an Authenticator for HTTP Basic credentials. It panics if the Store, Verify or DummyHash is nil.
+func NewBasicAuthenticator(opts *BasicOptions) Authenticator {
+	a := &basicAuthenticator{}
+	if opts != nil {
+		a.opts = *opts
+	}
+	if a.opts.Store == nil || a.opts.Verify == nil || len(a.opts.DummyHash) == 0 {
+		panic("rest: BasicOptions.Store, Verify and DummyHash are required")
+	}
+	return a
+}
+
+type basicAuthenticator struct {
+	opts BasicOptions
+}
+
+func (a *basicAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+
+	hash, id, err := a.opts.Store.LookupCredentials(r.Context(), username)
+	if err != nil {
+		return nil, err
+	}
+	if id == nil {
+		// verify anyway, so unknown users can't be told apart by the response time
+		a.opts.Verify(a.opts.DummyHash, []byte(password))
+		return nil, NewStatusError(http.StatusUnauthorized, ErrInvalidCredentials)
+	}
+	if a.opts.Verify(hash, []byte(password)) != nil {
+		return nil, NewStatusError(http.StatusUnauthorized, ErrInvalidCredentials)
+	}
+
+	authenticated := *id
+	if authenticated.ID == "" {
+		authenticated.ID = username
+	}
+	if authenticated.Scheme == "" {
+		authenticated.Scheme = "Basic"
+	}
+	return &authenticated, nil
+}
+
+func (a *basicAuthenticator) Challenge() string {
+	if a.opts.Realm == "" {
+		return "Basic"
+	}
+	return fmt.Sprintf("Basic realm=%q, charset=\"UTF-8\"", a.opts.Realm)
+}
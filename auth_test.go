@@ -0,0 +1,198 @@
+package rest
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type testCredentialStore map[string][]byte
+
+func (s testCredentialStore) LookupCredentials(ctx context.Context, username string) ([]byte, *Identity, error) {
+	hash, ok := s[username]
+	if !ok {
+		return nil, nil, nil
+	}
+	return hash, &Identity{Roles: []string{"user"}}, nil
+}
+
+// testDummyHash is verified for unknown users.
+var testDummyHash = sha256.Sum256([]byte("dummy"))
+
+// verifySHA256 stands in for bcrypt or argon2 in the tests.
+func verifySHA256(hash, password []byte) error {
+	sum := sha256.Sum256(password)
+	if subtle.ConstantTimeCompare(hash, sum[:]) != 1 {
+		return errors.New("mismatch")
+	}
+	return nil
+}
+
+func TestAuthentication(t *testing.T) {
+	keys := NewMemoryAPIKeyStore()
+	keys.Add(HashAPIKey("key-1"), &Identity{ID: "machine-1", Scopes: []string{"read"}})
+	keys.Add(HashAPIKey("key-2"), &Identity{ID: "machine-2"})
+	keys.Remove(HashAPIKey("key-2"))
+
+	password := sha256.Sum256([]byte("secret"))
+	basic := NewBasicAuthenticator(&BasicOptions{
+		Realm:     "api",
+		Store:     testCredentialStore{"alice": password[:]},
+		Verify:    verifySHA256,
+		DummyHash: testDummyHash[:],
+	})
+	apiKey := NewAPIKeyAuthenticator(&APIKeyOptions{Header: "X-API-Key", Query: "api_key", Cookie: "api_key", Store: keys})
+
+	testcases := []struct {
+		test      string
+		path      string
+		prepare   func(r *http.Request)
+		code      int
+		id        string
+		scheme    string
+		challenge string
+	}{
+		{"api key header", "/any", func(r *http.Request) { r.Header.Set("X-API-Key", "key-1") }, 200, "machine-1", "APIKey", ""},
+		{"api key query", "/any?api_key=key-1", func(r *http.Request) {}, 200, "machine-1", "APIKey", ""},
+		{"api key cookie", "/any", func(r *http.Request) { r.AddCookie(&http.Cookie{Name: "api_key", Value: "key-1"}) }, 200, "machine-1", "APIKey", ""},
+		{"removed api key", "/any", func(r *http.Request) { r.Header.Set("X-API-Key", "key-2") }, 401, "", "", `Basic realm="api", charset="UTF-8"`},
+		{"basic", "/any", func(r *http.Request) { r.SetBasicAuth("alice", "secret") }, 200, "alice", "Basic", ""},
+		{"basic wrong password", "/any", func(r *http.Request) { r.SetBasicAuth("alice", "wrong") }, 401, "", "", `Basic realm="api", charset="UTF-8"`},
+		{"basic unknown user", "/any", func(r *http.Request) { r.SetBasicAuth("bob", "secret") }, 401, "", "", `Basic realm="api", charset="UTF-8"`},
+		{"no credentials", "/any", func(r *http.Request) {}, 401, "", "", `Basic realm="api", charset="UTF-8"`},
+		{"route override rejects basic", "/keys", func(r *http.Request) { r.SetBasicAuth("alice", "secret") }, 401, "", "", ""},
+		{"route override accepts api key", "/keys", func(r *http.Request) { r.Header.Set("X-API-Key", "key-1") }, 200, "machine-1", "APIKey", ""},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.test, func(t *testing.T) {
+			var (
+				principal *Identity
+				reported  error
+			)
+			s := New(Configuration{
+				Chain: []Middleware{NewAuthentication(AnyOf(apiKey, basic))},
+				ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+					reported = err
+					w.WriteHeader(ErrorStatus(err))
+				},
+			}, nil)
+			handler := func(w http.ResponseWriter, r *http.Request) {
+				principal = Principal(r.Context())
+			}
+			s.Register([]Register{
+				{Method: http.MethodGet, Path: "/any", Handler: handler},
+				{Method: http.MethodGet, Path: "/keys", Handler: handler, Authenticator: apiKey},
+			}, "")
+
+			w := httptest.NewRecorder()
+			r, _ := http.NewRequest(http.MethodGet, tc.path, nil)
+			tc.prepare(r)
+			s.ServeHTTP(w, r)
+
+			if w.Code != tc.code {
+				t.Errorf("expected status %d, got %d (%v)", tc.code, w.Code, reported)
+			}
+			if tc.code == 200 && (principal == nil || principal.ID != tc.id || principal.Scheme != tc.scheme) {
+				t.Errorf("expected principal %s/%s, got %+v", tc.id, tc.scheme, principal)
+			}
+			if tc.code == 401 && !errors.Is(reported, ErrInvalidCredentials) && !errors.Is(reported, ErrNoCredentials) {
+				t.Errorf("expected credentials error, got %v", reported)
+			}
+			if got := w.Header().Get(headerWWWAuthenticate); got != tc.challenge {
+				t.Errorf("expected challenge %q, got %q", tc.challenge, got)
+			}
+		})
+	}
+}
+
+func TestAuthenticationJWT(t *testing.T) {
+	signers, keys := newTestSigners(t)
+	basic := NewBasicAuthenticator(&BasicOptions{Store: testCredentialStore{}, Verify: verifySHA256, DummyHash: testDummyHash[:]})
+
+	var principal *Identity
+	h := NewAuthentication(AnyOf(NewJWTAuthenticator(&JWTOptions{Keys: keys, Realm: "api"}), basic))(func(w http.ResponseWriter, r *http.Request) {
+		principal = Principal(r.Context())
+	})
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(headerAuthz, "Bearer "+signers[0].sign(t, map[string]interface{}{"sub": "alice", "scope": "read write", "roles": []string{"admin"}}))
+	h(w, r)
+
+	if w.Code != http.StatusOK || principal == nil {
+		t.Fatalf("expected authenticated request, got %d", w.Code)
+	}
+	if principal.ID != "alice" || principal.Scheme != "Bearer" || len(principal.Scopes) != 2 || principal.Roles[0] != "admin" {
+		t.Errorf("unexpected principal %+v", principal)
+	}
+
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest(http.MethodGet, "/", nil)
+	h(w, r)
+	if expected := `Bearer realm="api", Basic`; w.Header().Get(headerWWWAuthenticate) != expected {
+		t.Errorf("expected challenge %q, got %q", expected, w.Header().Get(headerWWWAuthenticate))
+	}
+}
+
+func TestBasicAuthenticatorVerifiesUnknownUsers(t *testing.T) {
+	password := sha256.Sum256([]byte("secret"))
+	var verified [][]byte
+	basic := NewBasicAuthenticator(&BasicOptions{
+		Store: testCredentialStore{"alice": password[:]},
+		Verify: func(hash, pw []byte) error {
+			verified = append(verified, hash)
+			return verifySHA256(hash, pw)
+		},
+		DummyHash: testDummyHash[:],
+	})
+
+	testcases := []struct {
+		user, password string
+		hash           []byte
+	}{
+		{"bob", "secret", testDummyHash[:]},
+		{"alice", "wrong", password[:]},
+		{"bob", "secret", testDummyHash[:]},
+	}
+	for _, tc := range testcases {
+		verified = nil
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.SetBasicAuth(tc.user, tc.password)
+		if _, err := basic.Authenticate(r); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("%s: expected ErrInvalidCredentials, got %v", tc.user, err)
+		}
+		if len(verified) != 1 || string(verified[0]) != string(tc.hash) {
+			t.Errorf("%s: expected one verification against %x, got %x", tc.user, tc.hash, verified)
+		}
+	}
+}
+
+func TestAuthenticatorOptionsRequired(t *testing.T) {
+	testcases := []struct {
+		test string
+		new  func()
+	}{
+		{"basic nil options", func() { NewBasicAuthenticator(nil) }},
+		{"basic without verify", func() { NewBasicAuthenticator(&BasicOptions{Store: testCredentialStore{}}) }},
+		{"basic without dummy hash", func() { NewBasicAuthenticator(&BasicOptions{Store: testCredentialStore{}, Verify: verifySHA256}) }},
+		{"api key nil options", func() { NewAPIKeyAuthenticator(nil) }},
+		{"jwt nil options", func() { NewJWT(nil) }},
+		{"jwt authenticator without keys", func() { NewJWTAuthenticator(&JWTOptions{Realm: "api"}) }},
+		{"signature without secrets", func() { NewSignatureVerification(&SignatureOptions{}) }},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.test, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("expected panic")
+				}
+			}()
+			tc.new()
+		})
+	}
+}
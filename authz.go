@@ -0,0 +1,122 @@
+package rest
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+// ErrForbidden is reported with status 403 if the principal lacks the permissions of a route.
+var ErrForbidden = errors.New("forbidden")
+
+// Permissions are the permissions required to access a route.
+type Permissions struct {
+	// Scopes must all be granted to the principal.
+	Scopes []string
+	// Roles must contain at least one role of the principal, if not empty.
+	Roles []string
+	// Policies are the names of policies which must all be satisfied.
+	Policies []string
+}
+
+// empty reports whether no permissions are required.
+func (p Permissions) empty() bool {
+	return len(p.Scopes) == 0 && len(p.Roles) == 0 && len(p.Policies) == 0
+}
+
+// Authorizer decides whether a principal may access a route requiring the
+// permissions. It returns an error if access is denied, which is reported
+// with status 403 unless it satisfies the Statuser interface.
+type Authorizer interface {
+	Authorize(r *http.Request, principal *Identity, required Permissions) error
+}
+
+// Policy decides whether the principal may access the route of the request.
+type Policy func(r *http.Request, principal *Identity) bool
+
+// DefaultAuthorizer checks the scopes and roles of the principal and evaluates
+// the policies by name. Routes requiring unknown policies are denied.
+type DefaultAuthorizer struct {
+	Policies map[string]Policy
+}
+
+// Authorize checks the permissions.
+func (a *DefaultAuthorizer) Authorize(r *http.Request, principal *Identity, required Permissions) error {
+	for _, scope := range required.Scopes {
+		if !containsString(principal.Scopes, scope) {
+			return fmt.Errorf("%w: missing scope %q", ErrForbidden, scope)
+		}
+	}
+
+	if len(required.Roles) > 0 {
+		granted := false
+		for _, role := range principal.Roles {
+			if containsString(required.Roles, role) {
+				granted = true
+				break
+			}
+		}
+		if !granted {
+			return fmt.Errorf("%w: missing role", ErrForbidden)
+		}
+	}
+
+	for _, name := range required.Policies {
+		if p, ok := a.Policies[name]; !ok || !p(r, principal) {
+			return fmt.Errorf("%w: policy %q not satisfied", ErrForbidden, name)
+		}
+	}
+	return nil
+}
+
+// withAuthorization checks the permissions of the principal before calling the handler.
+// Requests without principal are answered with status 401.
+func withAuthorization(next http.HandlerFunc, a Authorizer, required Permissions) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal := Principal(r.Context())
+		if principal == nil {
+			HandleError(w, r, NewStatusError(http.StatusUnauthorized, ErrNoCredentials))
+			return
+		}
+
+		if err := a.Authorize(r, principal, required); err != nil {
+			var s Statuser
+			if !errors.As(err, &s) {
+				err = NewStatusError(http.StatusForbidden, err)
+			}
+			HandleError(w, r, err)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// RouteInfo describes a registered route.
+type RouteInfo struct {
+	Method      string
+	Pattern     string
+	Name        string
+	Permissions Permissions
+}
+
+// Routes returns the registered routes sorted by pattern and method, e.g. to audit their permissions.
+func (s *Service) Routes() []RouteInfo {
+	infos := make([]RouteInfo, 0, len(s.routeList))
+	for _, rt := range s.routeList {
+		infos = append(infos, RouteInfo{
+			Method:      rt.method,
+			Pattern:     rt.pattern,
+			Name:        rt.name,
+			Permissions: rt.permissions,
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].Pattern != infos[j].Pattern {
+			return infos[i].Pattern < infos[j].Pattern
+		}
+		return infos[i].Method < infos[j].Method
+	})
+	return infos
+}
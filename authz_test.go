@@ -0,0 +1,125 @@
+package rest
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// testAuthenticator authenticates requests by the X-User header as principal with the roles and scopes of the user.
+type testAuthenticator map[string]*Identity
+
+func (a testAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	user := r.Header.Get("X-User")
+	if user == "" {
+		return nil, ErrNoCredentials
+	}
+	if id, ok := a[user]; ok {
+		return id, nil
+	}
+	return nil, NewStatusError(http.StatusUnauthorized, ErrInvalidCredentials)
+}
+
+func (a testAuthenticator) Challenge() string { return "" }
+
+func TestAuthorization(t *testing.T) {
+	users := testAuthenticator{
+		"reader": {ID: "reader", Scopes: []string{"items:read"}, Roles: []string{"user"}},
+		"writer": {ID: "writer", Scopes: []string{"items:read", "items:write"}, Roles: []string{"user"}},
+		"admin":  {ID: "admin", Roles: []string{"admin"}},
+	}
+	authorizer := &DefaultAuthorizer{Policies: map[string]Policy{
+		"owner": func(r *http.Request, p *Identity) bool {
+			ps := GetParams(r.Context())
+			return ps.Get("user") == p.ID
+		},
+	}}
+
+	s := New(Configuration{
+		Chain:      []Middleware{NewAuthentication(users)},
+		Authorizer: authorizer,
+	}, nil)
+	handler := func(w http.ResponseWriter, r *http.Request) {}
+	s.Register([]Register{
+		{Method: http.MethodGet, Path: "/items", Handler: handler, Permissions: Permissions{Scopes: []string{"items:read"}}},
+		{Method: http.MethodPost, Path: "/items", Handler: handler, Permissions: Permissions{Scopes: []string{"items:read", "items:write"}}},
+		{Method: http.MethodDelete, Path: "/items", Handler: handler, Name: "purge", Permissions: Permissions{Roles: []string{"admin", "operator"}}},
+		{Method: http.MethodGet, Path: "/users/:user", Handler: handler, Permissions: Permissions{Policies: []string{"owner"}}},
+		{Method: http.MethodGet, Path: "/audit", Handler: handler, Permissions: Permissions{Policies: []string{"unknown"}}},
+		{Method: http.MethodGet, Path: "/public", Handler: handler},
+	}, "")
+
+	testcases := []struct {
+		method string
+		path   string
+		user   string
+		code   int
+	}{
+		{http.MethodGet, "/items", "reader", 200},
+		{http.MethodGet, "/items", "admin", 403},
+		{http.MethodGet, "/items", "", 401},
+		{http.MethodPost, "/items", "reader", 403},
+		{http.MethodPost, "/items", "writer", 200},
+		{http.MethodDelete, "/items", "writer", 403},
+		{http.MethodDelete, "/items", "admin", 200},
+		{http.MethodGet, "/users/reader", "reader", 200},
+		{http.MethodGet, "/users/writer", "reader", 403},
+		{http.MethodGet, "/audit", "admin", 403},
+		{http.MethodGet, "/public", "reader", 200},
+	}
+
+	for _, tc := range testcases {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest(tc.method, tc.path, nil)
+		if tc.user != "" {
+			r.Header.Set("X-User", tc.user)
+		}
+		s.ServeHTTP(w, r)
+
+		if w.Code != tc.code {
+			t.Errorf("%s %s as %q: expected status %d, got %d: %s", tc.method, tc.path, tc.user, tc.code, w.Code, w.Body.String())
+		}
+	}
+
+	expected := []RouteInfo{
+		{Method: http.MethodGet, Pattern: "/audit", Permissions: Permissions{Policies: []string{"unknown"}}},
+		{Method: http.MethodDelete, Pattern: "/items", Name: "purge", Permissions: Permissions{Roles: []string{"admin", "operator"}}},
+		{Method: http.MethodGet, Pattern: "/items", Permissions: Permissions{Scopes: []string{"items:read"}}},
+		{Method: http.MethodPost, Pattern: "/items", Permissions: Permissions{Scopes: []string{"items:read", "items:write"}}},
+		{Method: http.MethodGet, Pattern: "/public"},
+		{Method: http.MethodGet, Pattern: "/users/:user", Permissions: Permissions{Policies: []string{"owner"}}},
+	}
+	if routes := s.Routes(); !reflect.DeepEqual(routes, expected) {
+		t.Errorf("expected routes %+v, got %+v", expected, routes)
+	}
+}
+
+type denyAuthorizer struct{}
+
+func (denyAuthorizer) Authorize(r *http.Request, p *Identity, required Permissions) error {
+	return NewStatusError(http.StatusNotFound, errors.New("hidden"))
+}
+
+func TestAuthorizerStatus(t *testing.T) {
+	s := New(Configuration{
+		Chain:      []Middleware{NewAuthentication(testAuthenticator{"reader": {ID: "reader"}})},
+		Authorizer: denyAuthorizer{},
+	}, nil)
+	s.Register([]Register{{
+		Method:      http.MethodGet,
+		Path:        "/secret",
+		Handler:     func(w http.ResponseWriter, r *http.Request) {},
+		Permissions: Permissions{Roles: []string{"admin"}},
+	}}, "")
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest(http.MethodGet, "/secret", nil)
+	r.Header.Set("X-User", "reader")
+	s.ServeHTTP(w, r)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected the status of the authorizer error, got %d", w.Code)
+	}
+}
@@ -0,0 +1,247 @@
+package rest
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is reported with status 503 if a request is rejected by an open circuit.
+var ErrCircuitOpen = errors.New("circuit open")
+
+// CircuitState is the state of a circuit breaker.
+type CircuitState int
+
+// States of a circuit breaker.
+const (
+	CircuitClosed CircuitState = iota
+	CircuitOpen
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// CircuitBreakerOptions represents the available circuit breaker options
+type CircuitBreakerOptions struct {
+	// FailureThreshold is the number of consecutive failures opening the circuit. If 0 5 is used.
+	FailureThreshold int
+	// OpenTimeout is the time the circuit stays open before trial requests are let through. If 0 30 seconds are used.
+	OpenTimeout time.Duration
+	// HalfOpenRequests is the number of successful trial requests closing the circuit. If 0 1 is used.
+	HalfOpenRequests int
+	// IsFailureStatus decides whether a response status is a failure. If nil statuses >= 500 are.
+	IsFailureStatus func(status int) bool
+	// IsFailureError decides whether an error reported to the ErrorHandler is a failure.
+	// If nil errors with a status >= 500 are.
+	IsFailureError func(err error) bool
+	// SlowCallThreshold, if set, counts requests taking longer as failures.
+	SlowCallThreshold time.Duration
+	// OnStateChange is called when the circuit of a route changes its state. It
+	// is called after the state is released, so it may call State, but on the
+	// path of the request causing the change, so it must not block.
+	OnStateChange func(route string, from, to CircuitState)
+}
+
+// CircuitBreaker keeps a circuit per route pattern. While a circuit is open
+// requests fail fast with ErrCircuitOpen, status 503 and Retry-After through
+// the ErrorHandler. After OpenTimeout the circuit is half-open and lets
+// single trial requests through, which close it again if they succeed.
+type CircuitBreaker struct {
+	opts CircuitBreakerOptions
+	now  func() time.Time
+
+	mu       sync.Mutex
+	circuits map[string]*circuit
+	changes  []stateChange // transitions made while mu is held, reported by unlock
+}
+
+// stateChange is a transition to report to the OnStateChange hook.
+type stateChange struct {
+	route    string
+	from, to CircuitState
+}
+
+type circuit struct {
+	state      CircuitState
+	generation uint64 // incremented by every transition
+	failures   int
+	successes  int
+	trial      bool
+	openedAt   time.Time
+}
+
+// NewCircuitBreaker creates a new CircuitBreaker. Its Middleware is added to the Chain of the Configuration.
+func NewCircuitBreaker(opts *CircuitBreakerOptions) *CircuitBreaker {
+	cb := &CircuitBreaker{circuits: map[string]*circuit{}, now: time.Now}
+	if opts != nil {
+		cb.opts = *opts
+	}
+
+	if cb.opts.FailureThreshold <= 0 {
+		cb.opts.FailureThreshold = 5
+	}
+	if cb.opts.OpenTimeout <= 0 {
+		cb.opts.OpenTimeout = 30 * time.Second
+	}
+	if cb.opts.HalfOpenRequests <= 0 {
+		cb.opts.HalfOpenRequests = 1
+	}
+	if cb.opts.IsFailureStatus == nil {
+		cb.opts.IsFailureStatus = func(status int) bool { return status >= 500 }
+	}
+	if cb.opts.IsFailureError == nil {
+		cb.opts.IsFailureError = func(err error) bool { return ErrorStatus(err) >= 500 }
+	}
+	return cb
+}
+
+// State returns the state of the circuit of the given route pattern.
+func (cb *CircuitBreaker) State(route string) CircuitState {
+	cb.mu.Lock()
+	defer cb.unlock()
+
+	if c, ok := cb.circuits[route]; ok {
+		cb.refresh(route, c)
+		return c.state
+	}
+	return CircuitClosed
+}
+
+// Middleware rejects requests to routes with an open circuit and records the outcome of the others.
+func (cb *CircuitBreaker) Middleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		route := RoutePattern(r.Context())
+
+		allowed, generation, retryAfter := cb.allow(route)
+		if !allowed {
+			w.Header().Set(headerRetryAfter, strconv.Itoa(ceilSeconds(retryAfter)))
+			HandleError(w, r, NewStatusError(http.StatusServiceUnavailable, ErrCircuitOpen))
+			return
+		}
+
+		// observe the errors reported to the ErrorHandler of the service
+		var reported error
+		handler := errorHandlerOf(r.Context())
+		ctx := withErrorHandler(r.Context(), func(w http.ResponseWriter, r *http.Request, err error) {
+			if reported == nil {
+				reported = err
+			}
+			handler(w, r, err)
+		})
+
+		sw := &statusResponseWriter{ResponseWriter: w}
+		start := cb.now()
+
+		// a panicking handler counts as failure
+		success := false
+		defer func() { cb.record(route, generation, success) }()
+
+		next(sw, r.WithContext(ctx))
+
+		success = !(sw.status != 0 && cb.opts.IsFailureStatus(sw.status)) &&
+			!(reported != nil && cb.opts.IsFailureError(reported)) &&
+			!(cb.opts.SlowCallThreshold > 0 && cb.now().Sub(start) > cb.opts.SlowCallThreshold)
+	}
+}
+
+// allow reports whether a request to route may pass with the generation of
+// the circuit and otherwise the time until the circuit is half-open.
+func (cb *CircuitBreaker) allow(route string) (bool, uint64, time.Duration) {
+	cb.mu.Lock()
+	defer cb.unlock()
+
+	c, ok := cb.circuits[route]
+	if !ok {
+		c = &circuit{}
+		cb.circuits[route] = c
+	}
+	cb.refresh(route, c)
+
+	switch c.state {
+	case CircuitOpen:
+		return false, 0, c.openedAt.Add(cb.opts.OpenTimeout).Sub(cb.now())
+	case CircuitHalfOpen:
+		if c.trial {
+			return false, 0, time.Second
+		}
+		c.trial = true
+	}
+	return true, c.generation, 0
+}
+
+// record records the outcome of a request admitted in the generation.
+// Outcomes of requests admitted before the last transition are ignored, so
+// requests started while the circuit was closed don't count as trials.
+func (cb *CircuitBreaker) record(route string, generation uint64, success bool) {
+	cb.mu.Lock()
+	defer cb.unlock()
+
+	c := cb.circuits[route]
+	if c.generation != generation {
+		return
+	}
+	switch c.state {
+	case CircuitClosed:
+		if success {
+			c.failures = 0
+			return
+		}
+		c.failures++
+		if c.failures >= cb.opts.FailureThreshold {
+			cb.transition(route, c, CircuitOpen)
+		}
+	case CircuitHalfOpen:
+		c.trial = false
+		if !success {
+			cb.transition(route, c, CircuitOpen)
+			return
+		}
+		c.successes++
+		if c.successes >= cb.opts.HalfOpenRequests {
+			cb.transition(route, c, CircuitClosed)
+		}
+	}
+}
+
+// refresh moves an open circuit to half-open after the OpenTimeout.
+func (cb *CircuitBreaker) refresh(route string, c *circuit) {
+	if c.state == CircuitOpen && !cb.now().Before(c.openedAt.Add(cb.opts.OpenTimeout)) {
+		cb.transition(route, c, CircuitHalfOpen)
+	}
+}
+
+func (cb *CircuitBreaker) transition(route string, c *circuit, to CircuitState) {
+	from := c.state
+	c.state = to
+	c.generation++
+	c.failures, c.successes, c.trial = 0, 0, false
+	if to == CircuitOpen {
+		c.openedAt = cb.now()
+	}
+	if cb.opts.OnStateChange != nil {
+		cb.changes = append(cb.changes, stateChange{route: route, from: from, to: to})
+	}
+}
+
+// unlock releases mu and calls the OnStateChange hook for the transitions made while it was held.
+func (cb *CircuitBreaker) unlock() {
+	changes := cb.changes
+	cb.changes = nil
+	cb.mu.Unlock()
+
+	for _, c := range changes {
+		cb.opts.OnStateChange(c.route, c.from, c.to)
+	}
+}
@@ -0,0 +1,273 @@
+package rest
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type breakerClock struct {
+	now time.Time
+}
+
+func (c *breakerClock) Now() time.Time { return c.now }
+
+func TestCircuitBreaker(t *testing.T) {
+	var (
+		clock       = &breakerClock{now: time.Unix(0, 0)}
+		status      = http.StatusOK
+		handleErr   error
+		latency     time.Duration
+		calls       int
+		transitions []string
+	)
+
+	cb := NewCircuitBreaker(&CircuitBreakerOptions{
+		FailureThreshold:  2,
+		OpenTimeout:       10 * time.Second,
+		SlowCallThreshold: time.Second,
+		OnStateChange: func(route string, from, to CircuitState) {
+			transitions = append(transitions, route+" "+from.String()+"->"+to.String())
+		},
+	})
+	cb.now = clock.Now
+
+	s := New(Configuration{Chain: []Middleware{cb.Middleware}}, nil)
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		clock.now = clock.now.Add(latency)
+		if handleErr != nil {
+			HandleError(w, r, handleErr)
+			return
+		}
+		w.WriteHeader(status)
+	}
+	s.Register([]Register{
+		{Method: http.MethodGet, Path: "/a", Handler: handler},
+		{Method: http.MethodGet, Path: "/b", Handler: handler},
+	}, "")
+
+	type step struct {
+		path       string
+		status     int
+		err        error
+		latency    time.Duration
+		advance    time.Duration
+		code       int
+		called     bool
+		state      CircuitState
+		retryAfter string
+	}
+
+	steps := []step{
+		{path: "/a", status: 200, code: 200, called: true, state: CircuitClosed},
+		{path: "/a", status: 502, code: 502, called: true, state: CircuitClosed},
+		{path: "/a", status: 200, code: 200, called: true, state: CircuitClosed},
+		{path: "/a", status: 500, code: 500, called: true, state: CircuitClosed},
+		{path: "/a", err: NewStatusError(504, errors.New("timeout")), code: 504, called: true, state: CircuitOpen},
+		// open: fast fail without calling the handler, other routes are unaffected
+		{path: "/a", status: 200, advance: 4 * time.Second, code: 503, state: CircuitOpen, retryAfter: "6"},
+		{path: "/b", status: 200, code: 200, called: true, state: CircuitClosed},
+		// half-open: a failing trial opens the circuit again
+		{path: "/a", status: 200, latency: 2 * time.Second, advance: 6 * time.Second, code: 200, called: true, state: CircuitOpen},
+		{path: "/a", status: 200, code: 503, state: CircuitOpen, retryAfter: "10"},
+		// half-open: a successful trial closes the circuit
+		{path: "/a", status: 200, advance: 10 * time.Second, code: 200, called: true, state: CircuitClosed},
+		// client errors are no failures by default
+		{path: "/a", err: NewStatusError(404, errors.New("not found")), code: 404, called: true, state: CircuitClosed},
+		{path: "/a", status: 400, code: 400, called: true, state: CircuitClosed},
+	}
+
+	for i, st := range steps {
+		clock.now = clock.now.Add(st.advance)
+		status, handleErr, latency, calls = st.status, st.err, st.latency, 0
+
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest(http.MethodGet, st.path, nil)
+		s.ServeHTTP(w, r)
+
+		if w.Code != st.code {
+			t.Errorf("step %d: expected status %d, got %d", i, st.code, w.Code)
+		}
+		if (calls > 0) != st.called {
+			t.Errorf("step %d: expected handler called %t, got %t", i, st.called, calls > 0)
+		}
+		if state := cb.State(st.path); state != st.state {
+			t.Errorf("step %d: expected state %s, got %s", i, st.state, state)
+		}
+		if got := w.Header().Get(headerRetryAfter); got != st.retryAfter {
+			t.Errorf("step %d: expected Retry-After %q, got %q", i, st.retryAfter, got)
+		}
+	}
+
+	expected := []string{
+		"/a closed->open",
+		"/a open->half-open",
+		"/a half-open->open",
+		"/a open->half-open",
+		"/a half-open->closed",
+	}
+	if len(transitions) != len(expected) {
+		t.Fatalf("expected transitions %v, got %v", expected, transitions)
+	}
+	for i := range expected {
+		if transitions[i] != expected[i] {
+			t.Errorf("expected transition %q, got %q", expected[i], transitions[i])
+		}
+	}
+}
+
+func TestCircuitBreakerHalfOpenSingleTrial(t *testing.T) {
+	clock := &breakerClock{now: time.Unix(0, 0)}
+	cb := NewCircuitBreaker(&CircuitBreakerOptions{FailureThreshold: 1, OpenTimeout: time.Second, HalfOpenRequests: 2})
+	cb.now = clock.Now
+
+	var reported error
+	s := New(Configuration{
+		Chain: []Middleware{cb.Middleware},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			reported = err
+			w.WriteHeader(ErrorStatus(err))
+		},
+	}, nil)
+
+	trial := make(chan bool)
+	s.Register([]Register{{
+		Method: http.MethodGet,
+		Path:   "/x",
+		Handler: func(w http.ResponseWriter, r *http.Request) {
+			if <-trial {
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+		},
+	}}, "")
+
+	serve := func() int {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest(http.MethodGet, "/x", nil)
+		s.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	go func() { trial <- true }()
+	serve()
+	if cb.State("/x") != CircuitOpen {
+		t.Fatalf("expected open circuit, got %s", cb.State("/x"))
+	}
+
+	clock.now = clock.now.Add(time.Second)
+	done := make(chan int)
+	go func() { done <- serve() }()
+
+	// wait until the trial request is in flight
+	for i := 0; i < 100; i++ {
+		cb.mu.Lock()
+		inTrial := cb.circuits["/x"].trial
+		cb.mu.Unlock()
+		if inTrial {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if code := serve(); code != http.StatusServiceUnavailable || !errors.Is(reported, ErrCircuitOpen) {
+		t.Errorf("expected concurrent request to fail fast, got %d, %v", code, reported)
+	}
+
+	trial <- false
+	if code := <-done; code != http.StatusOK {
+		t.Errorf("expected trial to succeed, got %d", code)
+	}
+	if cb.State("/x") != CircuitHalfOpen {
+		t.Errorf("expected half-open circuit after first of two trials, got %s", cb.State("/x"))
+	}
+
+	go func() { trial <- false }()
+	serve()
+	if cb.State("/x") != CircuitClosed {
+		t.Errorf("expected closed circuit, got %s", cb.State("/x"))
+	}
+}
+
+func TestCircuitBreakerIgnoresStaleResults(t *testing.T) {
+	clock := &breakerClock{now: time.Unix(0, 0)}
+	cb := NewCircuitBreaker(&CircuitBreakerOptions{FailureThreshold: 1, OpenTimeout: time.Second})
+	cb.now = clock.Now
+
+	s := New(Configuration{Chain: []Middleware{cb.Middleware}}, nil)
+	started, release := make(chan struct{}), make(chan struct{})
+	s.Register([]Register{
+		{Method: http.MethodGet, Path: "/x", Handler: func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Query().Get("do") {
+			case "slow":
+				close(started)
+				<-release
+			case "fail":
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+		}},
+	}, "")
+
+	serve := func(do string) {
+		r, _ := http.NewRequest(http.MethodGet, "/x?do="+do, nil)
+		s.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	// a request admitted while closed finishes after the circuit is half-open
+	done := make(chan struct{})
+	go func() {
+		serve("slow")
+		close(done)
+	}()
+	<-started
+	serve("fail")
+	clock.now = clock.now.Add(time.Second)
+	if cb.State("/x") != CircuitHalfOpen {
+		t.Fatalf("expected half-open circuit, got %s", cb.State("/x"))
+	}
+	close(release)
+	<-done
+
+	if cb.State("/x") != CircuitHalfOpen {
+		t.Errorf("expected the stale success not to close the circuit, got %s", cb.State("/x"))
+	}
+	serve("fail")
+	if cb.State("/x") != CircuitOpen {
+		t.Errorf("expected the failed trial to open the circuit, got %s", cb.State("/x"))
+	}
+}
+
+func TestCircuitBreakerStateChangeCallsState(t *testing.T) {
+	var (
+		cb     *CircuitBreaker
+		states []CircuitState
+	)
+	cb = NewCircuitBreaker(&CircuitBreakerOptions{
+		FailureThreshold: 1,
+		OnStateChange: func(route string, from, to CircuitState) {
+			states = append(states, cb.State(route))
+		},
+	})
+
+	s := New(Configuration{Chain: []Middleware{cb.Middleware}}, nil)
+	s.Register([]Register{{Method: http.MethodGet, Path: "/a", Handler: func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}}}, "")
+
+	done := make(chan struct{})
+	go func() {
+		s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/a", nil))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected the hook to be called without holding the lock")
+	}
+
+	if len(states) != 1 || states[0] != CircuitOpen {
+		t.Errorf("expected the open state in the hook, got %v", states)
+	}
+}
@@ -0,0 +1,626 @@
+package rest
+
+import (
+	"container/list"
+	"context"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	headerAge          = "Age"
+	headerCacheControl = "Cache-Control"
+	headerSetCookie    = "Set-Cookie"
+	headerAuthz        = "Authorization"
+
+	// DefaultCacheMaxBytes is the size of the LRU store if no other is configured.
+	DefaultCacheMaxBytes = 64 << 20
+)
+
+// cacheableStatus lists the status codes cacheable by default (RFC 7231 section 6.1).
+var cacheableStatus = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusNoContent:            true,
+	http.StatusMultipleChoices:      true,
+	http.StatusMovedPermanently:     true,
+	http.StatusNotFound:             true,
+	http.StatusMethodNotAllowed:     true,
+	http.StatusGone:                 true,
+}
+
+// CachedResponse is a response stored in a CacheStore.
+type CachedResponse struct {
+	Status int
+	Header http.Header
+	Body   []byte
+	// Tags are used to invalidate the response. The name of the route is always contained.
+	Tags []string
+	// Created is the time the response was generated.
+	Created time.Time
+	// Expires is the time the response becomes stale.
+	Expires time.Time
+	// StaleUntil is the time until the stale response may be served while it is revalidated.
+	StaleUntil time.Time
+}
+
+// size approximates the memory used by the response.
+func (cr *CachedResponse) size() int {
+	n := len(cr.Body)
+	for k, vs := range cr.Header {
+		n += len(k)
+		for _, v := range vs {
+			n += len(v)
+		}
+	}
+	for _, t := range cr.Tags {
+		n += len(t)
+	}
+	return n
+}
+
+// CacheStore stores cached responses. Implementations must be safe for concurrent use.
+type CacheStore interface {
+	Get(key string) (*CachedResponse, bool)
+	Set(key string, resp *CachedResponse)
+	// Invalidate removes all responses with the given tag.
+	Invalidate(tag string)
+}
+
+// CacheOptions represents the available cache options
+type CacheOptions struct {
+	// Store keeps the responses. If nil a LRUStore with DefaultCacheMaxBytes is used.
+	Store CacheStore
+	// QueryKeys are the query parameters which are part of the cache key. If nil all are used.
+	QueryKeys []string
+	// DefaultTTL is used for responses without max-age or s-maxage. If 0 those are not cached.
+	DefaultTTL time.Duration
+	// StaleWhileRevalidate is used for responses without the stale-while-revalidate directive.
+	StaleWhileRevalidate time.Duration
+}
+
+// Cache is a server side cache for GET and HEAD responses. Responses are keyed
+// by the route pattern, the path parameters, the query parameters and the
+// request headers named in the Vary header of the response. The Cache-Control
+// directives of requests and responses are honored; concurrent misses of the
+// same key are coalesced into a single call of the handler.
+type Cache struct {
+	store      CacheStore
+	queryKeys  []string
+	defaultTTL time.Duration
+	staleTTL   time.Duration
+	now        func() time.Time
+
+	mu       sync.Mutex
+	varies   map[string][]string // Vary header names by route pattern, so it is bounded by the routes
+	inflight map[string]*cacheCall
+}
+
+// cacheCall is a handler call in progress whose result is shared.
+type cacheCall struct {
+	done chan struct{}
+	resp *CachedResponse
+	key  string // key the response was stored for, including the Vary headers
+}
+
+// cacheTagsKey defines the context key of the cache tags of a request
+type cacheTagsKey struct{}
+
+// cacheTags collects the tags added by a handler.
+type cacheTags struct {
+	mu   sync.Mutex
+	tags []string
+}
+
+// AddCacheTags tags the response of the request in the Cache, so it can be invalidated by InvalidateTags.
+func AddCacheTags(ctx context.Context, tags ...string) {
+	if ct, ok := ctx.Value(cacheTagsKey{}).(*cacheTags); ok {
+		ct.mu.Lock()
+		ct.tags = append(ct.tags, tags...)
+		ct.mu.Unlock()
+	}
+}
+
+// NewCache creates a new Cache. Its Middleware is added to the Chain of the Configuration.
+func NewCache(opts *CacheOptions) *Cache {
+	if opts == nil {
+		opts = &CacheOptions{}
+	}
+
+	c := &Cache{
+		store:      opts.Store,
+		queryKeys:  opts.QueryKeys,
+		defaultTTL: opts.DefaultTTL,
+		staleTTL:   opts.StaleWhileRevalidate,
+		now:        time.Now,
+		varies:     map[string][]string{},
+		inflight:   map[string]*cacheCall{},
+	}
+	if c.store == nil {
+		c.store = NewLRUStore(DefaultCacheMaxBytes)
+	}
+	return c
+}
+
+// InvalidateRoute removes all responses of the route with the given name, or pattern if it has no name.
+func (c *Cache) InvalidateRoute(name string) {
+	c.store.Invalidate(routeTag(name))
+}
+
+// InvalidateTags removes all responses with one of the given tags.
+func (c *Cache) InvalidateTags(tags ...string) {
+	for _, t := range tags {
+		c.store.Invalidate(t)
+	}
+}
+
+func routeTag(name string) string {
+	return "route:" + name
+}
+
+// Middleware serves responses from the cache and stores cacheable responses.
+func (c *Cache) Middleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			next(w, r)
+			return
+		}
+
+		reqCC := parseCacheControl(r.Header.Get(headerCacheControl))
+		if _, ok := reqCC["no-store"]; ok {
+			next(w, r)
+			return
+		}
+
+		primary := c.primaryKey(r)
+		key := c.key(primary, r)
+		now := c.now()
+
+		_, noCache := reqCC["no-cache"]
+		if maxAge, ok := reqCC["max-age"]; ok && maxAge == "0" {
+			noCache = true
+		}
+
+		if !noCache {
+			if resp, ok := c.store.Get(key); ok {
+				switch {
+				case now.Before(resp.Expires):
+					c.write(w, r, resp, now)
+					return
+				case now.Before(resp.StaleUntil):
+					c.write(w, r, resp, now)
+					go c.revalidate(next, r, primary, key)
+					return
+				}
+			}
+		}
+
+		// HEAD responses have no body to fill the cache with
+		if r.Method == http.MethodHead {
+			next(w, r)
+			return
+		}
+
+		resp, shared := c.do(next, w, r, primary, key)
+		if shared {
+			c.write(w, r, resp, c.now())
+		}
+	}
+}
+
+// do calls the handler for the key unless a call is in flight. If so its
+// response is returned if it may be shared, otherwise the handler is called.
+// The response was written to w if shared is false.
+func (c *Cache) do(next http.HandlerFunc, w http.ResponseWriter, r *http.Request, primary, key string) (resp *CachedResponse, shared bool) {
+	c.mu.Lock()
+	if call, ok := c.inflight[key]; ok {
+		c.mu.Unlock()
+		<-call.done
+		if call.resp != nil && call.key == c.key(primary, r) {
+			return call.resp, true
+		}
+		c.fill(next, w, r, primary)
+		return nil, false
+	}
+
+	call := &cacheCall{done: make(chan struct{})}
+	c.inflight[key] = call
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.inflight, key)
+		c.mu.Unlock()
+		close(call.done)
+	}()
+
+	call.resp, call.key = c.fill(next, w, r, primary)
+	return nil, false
+}
+
+// revalidate refreshes a stale response in the background.
+func (c *Cache) revalidate(next http.HandlerFunc, r *http.Request, primary, key string) {
+	c.mu.Lock()
+	if _, ok := c.inflight[key]; ok {
+		c.mu.Unlock()
+		return
+	}
+	call := &cacheCall{done: make(chan struct{})}
+	c.inflight[key] = call
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.inflight, key)
+		c.mu.Unlock()
+		close(call.done)
+	}()
+
+	r = r.WithContext(detachedContext{r.Context()})
+	call.resp, call.key = c.fill(next, discardResponseWriter{header: http.Header{}}, r, primary)
+}
+
+// fill calls the handler, writes its response to w and stores it if
+// cacheable. The stored response and its key are returned.
+func (c *Cache) fill(next http.HandlerFunc, w http.ResponseWriter, r *http.Request, primary string) (*CachedResponse, string) {
+	tags := &cacheTags{}
+	rec := &cacheRecorder{header: http.Header{}}
+	ctx := context.WithValue(r.Context(), cacheTagsKey{}, tags)
+
+	next(rec, r.WithContext(ctx))
+	rec.copyTo(w)
+
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+
+	now := c.now()
+	resp := c.cacheable(r, rec, now)
+	if resp == nil {
+		return nil, ""
+	}
+
+	resp.Tags = append(tags.tags, routeTag(RouteName(r.Context())))
+
+	vary := varyNames(rec.header)
+	c.mu.Lock()
+	c.varies[RoutePattern(r.Context())] = vary
+	c.mu.Unlock()
+
+	key := c.key(primary, r)
+	c.store.Set(key, resp)
+	return resp, key
+}
+
+// cacheable creates the response to store or returns nil if it must not be stored.
+func (c *Cache) cacheable(r *http.Request, rec *cacheRecorder, now time.Time) *CachedResponse {
+	if !cacheableStatus[rec.status] {
+		return nil
+	}
+	if len(rec.header[headerSetCookie]) > 0 {
+		return nil
+	}
+	for _, v := range varyNames(rec.header) {
+		if v == "*" {
+			return nil
+		}
+	}
+
+	cc := parseCacheControl(rec.header.Get(headerCacheControl))
+	for _, d := range []string{"no-store", "private", "no-cache"} {
+		if _, ok := cc[d]; ok {
+			return nil
+		}
+	}
+
+	_, public := cc["public"]
+	sMaxAge, shared := cc["s-maxage"]
+	if r.Header.Get(headerAuthz) != "" && !public && !shared {
+		return nil
+	}
+
+	ttl := c.defaultTTL
+	if v, ok := cc["max-age"]; ok {
+		ttl = parseSeconds(v)
+	}
+	if shared {
+		ttl = parseSeconds(sMaxAge)
+	}
+	if ttl <= 0 {
+		return nil
+	}
+
+	stale := c.staleTTL
+	if v, ok := cc["stale-while-revalidate"]; ok {
+		stale = parseSeconds(v)
+	}
+
+	return &CachedResponse{
+		Status:     rec.status,
+		Header:     rec.header,
+		Body:       rec.body,
+		Created:    now,
+		Expires:    now.Add(ttl),
+		StaleUntil: now.Add(ttl + stale),
+	}
+}
+
+// write writes a cached response to w.
+func (c *Cache) write(w http.ResponseWriter, r *http.Request, resp *CachedResponse, now time.Time) {
+	h := w.Header()
+	for k, vs := range resp.Header {
+		if k == headerVary {
+			addVary(h, vs...)
+			continue
+		}
+		h[k] = append([]string(nil), vs...)
+	}
+	h.Set(headerAge, strconv.Itoa(int(now.Sub(resp.Created)/time.Second)))
+
+	w.WriteHeader(resp.Status)
+	if r.Method != http.MethodHead {
+		w.Write(resp.Body)
+	}
+}
+
+// primaryKey returns the key of the route, its params and the selected query parameters.
+func (c *Cache) primaryKey(r *http.Request) string {
+	var b strings.Builder
+
+	pattern := RoutePattern(r.Context())
+	if pattern == "" {
+		pattern = r.URL.Path
+	}
+	b.WriteString(pattern)
+
+	if ps, ok := r.Context().Value(paramsKey{}).(Params); ok {
+		for _, p := range ps {
+			b.WriteString("|" + url.QueryEscape(p.Key) + "=" + url.QueryEscape(p.Value))
+		}
+	}
+
+	query := r.URL.Query()
+	keys := c.queryKeys
+	if keys == nil {
+		for k := range query {
+			keys = append(keys, k)
+		}
+	}
+	keys = append([]string(nil), keys...)
+	sort.Strings(keys)
+
+	b.WriteString("?")
+	for _, k := range keys {
+		for _, v := range query[k] {
+			b.WriteString(url.QueryEscape(k) + "=" + url.QueryEscape(v) + "&")
+		}
+	}
+	return b.String()
+}
+
+// key adds the values of the request headers the route varies on to the
+// primary key. The header names are part of the key, so a response is not
+// found with the names of another response of the route.
+func (c *Cache) key(primary string, r *http.Request) string {
+	c.mu.Lock()
+	vary := c.varies[RoutePattern(r.Context())]
+	c.mu.Unlock()
+
+	if len(vary) == 0 {
+		return primary
+	}
+
+	var b strings.Builder
+	b.WriteString(primary)
+	for _, name := range vary {
+		b.WriteString("|" + name + ":" + strings.Join(r.Header.Values(name), ","))
+	}
+	return b.String()
+}
+
+// varyNames returns the sorted, canonical header names of the Vary header.
+func varyNames(h http.Header) []string {
+	var names []string
+	for _, v := range h[headerVary] {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// parseCacheControl parses the directives of a Cache-Control header.
+func parseCacheControl(cc string) map[string]string {
+	directives := map[string]string{}
+	for _, part := range strings.Split(cc, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, value := part, ""
+		if i := strings.IndexByte(part, '='); i >= 0 {
+			name, value = part[:i], strings.Trim(part[i+1:], `"`)
+		}
+		directives[strings.ToLower(name)] = value
+	}
+	return directives
+}
+
+func parseSeconds(v string) time.Duration {
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return 0
+	}
+	return time.Duration(n) * time.Second
+}
+
+// cacheRecorder records the response of a handler with its own header map,
+// so headers set outside of the cached handler are not stored.
+type cacheRecorder struct {
+	header http.Header
+	status int
+	body   []byte
+}
+
+func (cr *cacheRecorder) Header() http.Header { return cr.header }
+
+func (cr *cacheRecorder) WriteHeader(status int) {
+	if cr.status == 0 {
+		cr.status = status
+	}
+}
+
+func (cr *cacheRecorder) Write(p []byte) (int, error) {
+	cr.body = append(cr.body, p...)
+	return len(p), nil
+}
+
+// copyTo writes the recorded response to w.
+func (cr *cacheRecorder) copyTo(w http.ResponseWriter) {
+	h := w.Header()
+	for k, vs := range cr.header {
+		if k == headerVary {
+			addVary(h, vs...)
+			continue
+		}
+		h[k] = append([]string(nil), vs...)
+	}
+
+	status := cr.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	w.WriteHeader(status)
+	w.Write(cr.body)
+}
+
+// discardResponseWriter is used to call handlers in the background.
+type discardResponseWriter struct {
+	header http.Header
+}
+
+func (d discardResponseWriter) Header() http.Header         { return d.header }
+func (d discardResponseWriter) WriteHeader(int)             {}
+func (d discardResponseWriter) Write(p []byte) (int, error) { return len(p), nil }
+
+// detachedContext keeps the values of its parent but is never canceled.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool)         { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}               { return nil }
+func (detachedContext) Err() error                          { return nil }
+func (d detachedContext) Value(key interface{}) interface{} { return d.parent.Value(key) }
+
+// LRUStore is an in-memory CacheStore bounded by the size of the stored
+// responses. The least recently used responses are evicted first.
+type LRUStore struct {
+	mu       sync.Mutex
+	maxBytes int
+	bytes    int
+	ll       *list.List
+	entries  map[string]*list.Element
+	tags     map[string]map[string]struct{}
+}
+
+type lruEntry struct {
+	key  string
+	resp *CachedResponse
+	size int
+}
+
+// NewLRUStore creates a new LRUStore holding at most maxBytes.
+func NewLRUStore(maxBytes int) *LRUStore {
+	return &LRUStore{
+		maxBytes: maxBytes,
+		ll:       list.New(),
+		entries:  map[string]*list.Element{},
+		tags:     map[string]map[string]struct{}{},
+	}
+}
+
+// Get returns the response stored for key.
+func (s *LRUStore) Get(key string) (*CachedResponse, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[key]
+	if !ok {
+		return nil, false
+	}
+	s.ll.MoveToFront(e)
+	return e.Value.(*lruEntry).resp, true
+}
+
+// Set stores the response for key and evicts the least recently used ones if the store is full.
+// Responses larger than the store are not stored.
+func (s *LRUStore) Set(key string, resp *CachedResponse) {
+	size := resp.size()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e, ok := s.entries[key]; ok {
+		s.remove(e)
+	}
+	if size > s.maxBytes {
+		return
+	}
+
+	s.entries[key] = s.ll.PushFront(&lruEntry{key: key, resp: resp, size: size})
+	s.bytes += size
+	for _, t := range resp.Tags {
+		if s.tags[t] == nil {
+			s.tags[t] = map[string]struct{}{}
+		}
+		s.tags[t][key] = struct{}{}
+	}
+
+	for s.bytes > s.maxBytes {
+		s.remove(s.ll.Back())
+	}
+}
+
+// Invalidate removes all responses with the given tag.
+func (s *LRUStore) Invalidate(tag string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key := range s.tags[tag] {
+		if e, ok := s.entries[key]; ok {
+			s.remove(e)
+		}
+	}
+	delete(s.tags, tag)
+}
+
+// Len returns the number of stored responses.
+func (s *LRUStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ll.Len()
+}
+
+func (s *LRUStore) remove(e *list.Element) {
+	entry := e.Value.(*lruEntry)
+	s.ll.Remove(e)
+	delete(s.entries, entry.key)
+	s.bytes -= entry.size
+	for _, t := range entry.resp.Tags {
+		if keys, ok := s.tags[t]; ok {
+			delete(keys, entry.key)
+			if len(keys) == 0 {
+				delete(s.tags, t)
+			}
+		}
+	}
+}
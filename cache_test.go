@@ -0,0 +1,271 @@
+package rest
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type cacheValue struct {
+	Call int32 `json:"call" xml:"call"`
+}
+
+type cacheClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *cacheClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *cacheClock) Add(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+func newCacheService(c *Cache, cacheControl string, calls *int32) *Service {
+	s := New(Configuration{Chain: []Middleware{c.Middleware}}, nil)
+	s.Register([]Register{
+		{
+			Method: http.MethodGet,
+			Path:   "/items/:id",
+			Name:   "item",
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				n := atomic.AddInt32(calls, 1)
+				ps := GetParams(r.Context())
+				AddCacheTags(r.Context(), "item:"+ps.Get("id"))
+				w.Header().Set(headerCacheControl, cacheControl)
+				Render(w, r, http.StatusOK, cacheValue{Call: n})
+			},
+		},
+	}, "")
+	return s
+}
+
+func cacheRequest(s *Service, path string, header ...string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	r, _ := http.NewRequest(http.MethodGet, path, nil)
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Set(header[i], header[i+1])
+	}
+	s.ServeHTTP(recorder, r)
+	return recorder
+}
+
+func TestCache(t *testing.T) {
+	testcases := []struct {
+		test         string
+		cacheControl string
+		header       []string
+		paths        []string
+		calls        int32
+	}{
+		{"max-age", "max-age=60", nil, []string{"/items/1", "/items/1"}, 1},
+		{"s-maxage", "s-maxage=60", nil, []string{"/items/1", "/items/1"}, 1},
+		{"other params", "max-age=60", nil, []string{"/items/1", "/items/2"}, 2},
+		{"other query", "max-age=60", nil, []string{"/items/1?a=1", "/items/1?a=2"}, 2},
+		{"query order", "max-age=60", nil, []string{"/items/1?a=1&b=2", "/items/1?b=2&a=1"}, 1},
+		{"no-store", "no-store", nil, []string{"/items/1", "/items/1"}, 2},
+		{"private", "private, max-age=60", nil, []string{"/items/1", "/items/1"}, 2},
+		{"no directive", "", nil, []string{"/items/1", "/items/1"}, 2},
+		{"request no-cache", "max-age=60", []string{headerCacheControl, "no-cache"}, []string{"/items/1", "/items/1"}, 2},
+		{"authorization", "max-age=60", []string{headerAuthz, "Bearer x"}, []string{"/items/1", "/items/1"}, 2},
+		{"authorization public", "public, max-age=60", []string{headerAuthz, "Bearer x"}, []string{"/items/1", "/items/1"}, 1},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.test, func(t *testing.T) {
+			var calls int32
+			s := newCacheService(NewCache(nil), tc.cacheControl, &calls)
+
+			for _, p := range tc.paths {
+				if recorder := cacheRequest(s, p, tc.header...); recorder.Code != http.StatusOK {
+					t.Fatalf("Status code is expected to be 200, found %d", recorder.Code)
+				}
+			}
+
+			if calls != tc.calls {
+				t.Errorf("Handler is expected to be called %d times, found %d", tc.calls, calls)
+			}
+		})
+	}
+}
+
+func TestCacheVary(t *testing.T) {
+	var calls int32
+	s := newCacheService(NewCache(nil), "max-age=60", &calls)
+
+	json := cacheRequest(s, "/items/1", headerAccept, "application/json")
+	xml := cacheRequest(s, "/items/1", headerAccept, "application/xml")
+	cached := cacheRequest(s, "/items/1", headerAccept, "application/xml")
+
+	if calls != 2 {
+		t.Errorf("Handler is expected to be called 2 times, found %d", calls)
+	}
+
+	if ct := json.Header().Get(headerContentType); ct != "application/json" {
+		t.Errorf("Content-Type is expected to be application/json, found %v", ct)
+	}
+
+	if ct := cached.Header().Get(headerContentType); ct != "application/xml" || cached.Body.String() != xml.Body.String() {
+		t.Errorf("Cached response is expected to be the XML one, found %v", ct)
+	}
+
+	if age := cached.Header().Get(headerAge); age != "0" {
+		t.Errorf("Age is expected to be 0, found %v", age)
+	}
+}
+
+func TestCacheVaryBounded(t *testing.T) {
+	var calls int32
+	c := NewCache(nil)
+	s := newCacheService(c, "max-age=60", &calls)
+
+	for i := 0; i < 100; i++ {
+		cacheRequest(s, fmt.Sprintf("/items/%d?page=%d", i, i), headerAccept, "application/json")
+	}
+	if cached := cacheRequest(s, "/items/1?page=1", headerAccept, "application/json"); calls != 100 || cached.Code != http.StatusOK {
+		t.Errorf("Handler is expected to be called 100 times, found %d", calls)
+	}
+
+	c.mu.Lock()
+	n := len(c.varies)
+	c.mu.Unlock()
+	if n != 1 {
+		t.Errorf("Vary headers are expected to be kept once per route, found %d entries", n)
+	}
+}
+
+func TestCacheInvalidation(t *testing.T) {
+	var calls int32
+	c := NewCache(nil)
+	s := newCacheService(c, "max-age=60", &calls)
+
+	cacheRequest(s, "/items/1")
+	cacheRequest(s, "/items/2")
+
+	c.InvalidateTags("item:1")
+	cacheRequest(s, "/items/1")
+	cacheRequest(s, "/items/2")
+	if calls != 3 {
+		t.Errorf("Handler is expected to be called 3 times after invalidating a tag, found %d", calls)
+	}
+
+	c.InvalidateRoute("item")
+	cacheRequest(s, "/items/1")
+	cacheRequest(s, "/items/2")
+	if calls != 5 {
+		t.Errorf("Handler is expected to be called 5 times after invalidating the route, found %d", calls)
+	}
+}
+
+func TestCacheStaleWhileRevalidate(t *testing.T) {
+	var calls int32
+	clock := &cacheClock{now: time.Date(2017, 1, 15, 0, 0, 0, 0, time.UTC)}
+	c := NewCache(nil)
+	c.now = clock.Now
+	s := newCacheService(c, "max-age=10, stale-while-revalidate=30", &calls)
+
+	first := cacheRequest(s, "/items/1").Body.String()
+
+	clock.Add(20 * time.Second)
+	stale := cacheRequest(s, "/items/1")
+	if stale.Body.String() != first {
+		t.Errorf("Stale response is expected to be served")
+	}
+	if age := stale.Header().Get(headerAge); age != "20" {
+		t.Errorf("Age is expected to be 20, found %v", age)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(&calls) != 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	for time.Now().Before(deadline) {
+		if fresh := cacheRequest(s, "/items/1").Body.String(); fresh != first {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if fresh := cacheRequest(s, "/items/1").Body.String(); fresh == first {
+		t.Errorf("Response is expected to be revalidated")
+	}
+
+	clock.Add(time.Minute)
+	cacheRequest(s, "/items/1")
+	if n := atomic.LoadInt32(&calls); n != 3 {
+		t.Errorf("Handler is expected to be called 3 times, found %d", n)
+	}
+}
+
+func TestCacheCoalescing(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+
+	c := NewCache(nil)
+	s := New(Configuration{Chain: []Middleware{c.Middleware}}, nil)
+	s.Register([]Register{{
+		Method: http.MethodGet,
+		Path:   "/slow",
+		Handler: func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&calls, 1)
+			<-release
+			w.Header().Set(headerCacheControl, "max-age=60")
+			fmt.Fprint(w, "done")
+		},
+	}}, "")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if body := cacheRequest(s, "/slow").Body.String(); body != "done" {
+				t.Errorf("Body is expected to be done, found %v", body)
+			}
+		}()
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if calls != 1 {
+		t.Errorf("Handler is expected to be called once, found %d", calls)
+	}
+}
+
+func TestLRUStore(t *testing.T) {
+	s := NewLRUStore(10)
+
+	s.Set("a", &CachedResponse{Body: []byte("aaaa")})
+	s.Set("b", &CachedResponse{Body: []byte("bbbb")})
+	s.Get("a")
+	s.Set("c", &CachedResponse{Body: []byte("cccc")})
+
+	if _, ok := s.Get("b"); ok {
+		t.Errorf("Least recently used entry b is expected to be evicted")
+	}
+
+	if _, ok := s.Get("a"); !ok {
+		t.Errorf("Entry a is expected to be kept")
+	}
+
+	s.Set("d", &CachedResponse{Body: []byte("too large for the store")})
+	if _, ok := s.Get("d"); ok {
+		t.Errorf("Entry d is expected not to be stored")
+	}
+
+	if s.Len() != 2 {
+		t.Errorf("Store is expected to contain 2 entries, found %d", s.Len())
+	}
+}
@@ -0,0 +1,231 @@
+package rest
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"mime"
+	"strings"
+	"sync"
+)
+
+// Codec marshals and unmarshals values for a media type.
+//
+// JSON, XML and protobuf binary codecs are registered by default. Codecs for
+// YAML, MessagePack, CBOR, messages of google.golang.org/protobuf and the
+// proto3 JSON mapping are provided by the codecs package, further formats are
+// added with RegisterCodec.
+type Codec interface {
+	// ContentType returns the media type of the codec, e.g. application/json.
+	ContentType() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// Supporter can be implemented by a Codec that is able to marshal only some
+// types. Content negotiation skips codecs which do not support a value.
+type Supporter interface {
+	Supports(v interface{}) bool
+}
+
+// StrictUnmarshaler can be implemented by a Codec that is able to reject
+// fields unknown to the target value.
+type StrictUnmarshaler interface {
+	UnmarshalStrict(data []byte, v interface{}) error
+}
+
+// ErrUnsupportedType is returned by a Codec which cannot handle the given value.
+var ErrUnsupportedType = errors.New("unsupported type")
+
+type codecEntry struct {
+	mediaType string
+	codec     Codec
+}
+
+// CodecRegistry maps media types to codecs. The order of registration is the
+// server preference used in content negotiation, the first codec is the default.
+type CodecRegistry struct {
+	mu      sync.RWMutex
+	entries []codecEntry
+}
+
+// DefaultCodecs is the registry used by Render and Decode.
+var DefaultCodecs = &CodecRegistry{}
+
+func init() {
+	DefaultCodecs.Register(JSONCodec{})
+	DefaultCodecs.Register(XMLCodec{}, "text/xml")
+	DefaultCodecs.Register(ProtoCodec{}, "application/protobuf")
+}
+
+// RegisterCodec registers c in the DefaultCodecs.
+func RegisterCodec(c Codec, aliases ...string) {
+	DefaultCodecs.Register(c, aliases...)
+}
+
+// Register registers c for its content type and the given aliases. A codec
+// registered before for one of the media types is replaced.
+func (cr *CodecRegistry) Register(c Codec, aliases ...string) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+
+	for _, mt := range append([]string{c.ContentType()}, aliases...) {
+		mt = normalizeMediaType(mt)
+		replaced := false
+		for i := range cr.entries {
+			if cr.entries[i].mediaType == mt {
+				cr.entries[i].codec = c
+				replaced = true
+			}
+		}
+		if !replaced {
+			cr.entries = append(cr.entries, codecEntry{mediaType: mt, codec: c})
+		}
+	}
+}
+
+// Lookup returns the codec registered for the given content type. Parameters
+// like charset are ignored.
+func (cr *CodecRegistry) Lookup(contentType string) (Codec, bool) {
+	mt := normalizeMediaType(contentType)
+
+	cr.mu.RLock()
+	defer cr.mu.RUnlock()
+
+	for _, e := range cr.entries {
+		if e.mediaType == mt {
+			return e.codec, true
+		}
+	}
+	return nil, false
+}
+
+// Default returns the codec registered first.
+func (cr *CodecRegistry) Default() (Codec, bool) {
+	cr.mu.RLock()
+	defer cr.mu.RUnlock()
+
+	if len(cr.entries) == 0 {
+		return nil, false
+	}
+	return cr.entries[0].codec, true
+}
+
+// Negotiate selects the codec for the given Accept header and value. It
+// returns the media type to respond with and false if none is acceptable.
+func (cr *CodecRegistry) Negotiate(accept string, v interface{}) (string, Codec, bool) {
+	ranges := parseAccept(accept)
+
+	cr.mu.RLock()
+	defer cr.mu.RUnlock()
+
+	var (
+		best      codecEntry
+		bestQ     = 0.0
+		available = false
+	)
+	for _, e := range cr.entries {
+		if s, ok := e.codec.(Supporter); ok && !s.Supports(v) {
+			continue
+		}
+		q := 1.0
+		if len(ranges) > 0 {
+			q = quality(ranges, e.mediaType)
+		}
+		if q > bestQ {
+			best, bestQ, available = e, q, true
+		}
+	}
+	return best.mediaType, best.codec, available
+}
+
+func normalizeMediaType(contentType string) string {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mt = strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0])
+	}
+	return strings.ToLower(mt)
+}
+
+// JSONCodec marshals values with encoding/json.
+type JSONCodec struct{}
+
+// ContentType returns application/json.
+func (JSONCodec) ContentType() string { return "application/json" }
+
+// Marshal marshals v to JSON.
+func (JSONCodec) Marshal(v interface{}) ([]byte, error) { return json.Marshal(v) }
+
+// Unmarshal unmarshals JSON data into v.
+func (JSONCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+// UnmarshalStrict unmarshals JSON data into v and rejects unknown fields.
+func (JSONCodec) UnmarshalStrict(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if dec.More() {
+		return errors.New("invalid data after top-level value")
+	}
+	return nil
+}
+
+// XMLCodec marshals values with encoding/xml.
+type XMLCodec struct{}
+
+// ContentType returns application/xml.
+func (XMLCodec) ContentType() string { return "application/xml" }
+
+// Marshal marshals v to XML.
+func (XMLCodec) Marshal(v interface{}) ([]byte, error) { return xml.Marshal(v) }
+
+// Unmarshal unmarshals XML data into v.
+func (XMLCodec) Unmarshal(data []byte, v interface{}) error { return xml.Unmarshal(data, v) }
+
+// ProtoMarshaler is implemented by protobuf messages which marshal themselves
+// to the binary wire format, as generated by gogo/protobuf.
+type ProtoMarshaler interface {
+	Marshal() ([]byte, error)
+}
+
+// ProtoUnmarshaler is implemented by protobuf messages which unmarshal
+// themselves from the binary wire format.
+type ProtoUnmarshaler interface {
+	Unmarshal(data []byte) error
+}
+
+// ProtoCodec marshals values satisfying ProtoMarshaler and ProtoUnmarshaler
+// to the protobuf binary format. Messages generated by protoc-gen-go are
+// supported by the ProtoCodec of the codecs package, which replaces this one.
+type ProtoCodec struct{}
+
+// ContentType returns application/x-protobuf.
+func (ProtoCodec) ContentType() string { return "application/x-protobuf" }
+
+// Supports reports whether v satisfies ProtoMarshaler.
+func (ProtoCodec) Supports(v interface{}) bool {
+	_, ok := v.(ProtoMarshaler)
+	return ok
+}
+
+// Marshal marshals v to the protobuf binary format.
+func (ProtoCodec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(ProtoMarshaler)
+	if !ok {
+		return nil, fmt.Errorf("%w: %T is no protobuf message", ErrUnsupportedType, v)
+	}
+	return m.Marshal()
+}
+
+// Unmarshal unmarshals protobuf binary data into v.
+func (ProtoCodec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(ProtoUnmarshaler)
+	if !ok {
+		return fmt.Errorf("%w: %T is no protobuf message", ErrUnsupportedType, v)
+	}
+	return m.Unmarshal(data)
+}
@@ -0,0 +1,191 @@
+// Package codecs provides rest.Codec implementations for YAML, MessagePack,
+// CBOR, the protobuf binary format of google.golang.org/protobuf and the
+// proto3 JSON mapping. They live in their own package, so the
+// rest package stays free of dependencies. Register adds all of them to a
+// registry, single codecs are added with rest.RegisterCodec.
+package codecs
+
+import (
+	"bytes"
+
+	"github.com/doozer-de/rest"
+	"github.com/fxamacker/cbor/v2"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/protoadapt"
+	"gopkg.in/yaml.v3"
+)
+
+// Register registers the codecs of the package in cr. The ProtoJSONCodec
+// and ProtoCodec replace the codecs of application/json and
+// application/x-protobuf, the others are appended, so they are preferred less
+// than the codecs registered before.
+func Register(cr *rest.CodecRegistry) {
+	cr.Register(ProtoJSONCodec{})
+	cr.Register(ProtoCodec{}, "application/protobuf")
+	cr.Register(YAMLCodec{}, "application/x-yaml", "text/yaml")
+	cr.Register(MessagePackCodec{}, "application/x-msgpack", "application/vnd.msgpack")
+	cr.Register(CBORCodec{})
+}
+
+// ProtoJSONCodec marshals protobuf messages with the proto3 JSON mapping and
+// other values with encoding/json. Messages must implement proto.Message or
+// the APIv1 interface of github.com/golang/protobuf.
+type ProtoJSONCodec struct {
+	MarshalOptions   protojson.MarshalOptions
+	UnmarshalOptions protojson.UnmarshalOptions
+}
+
+// ContentType returns application/json.
+func (ProtoJSONCodec) ContentType() string { return "application/json" }
+
+// Marshal marshals v to JSON.
+func (c ProtoJSONCodec) Marshal(v interface{}) ([]byte, error) {
+	if m, ok := message(v); ok {
+		return c.MarshalOptions.Marshal(m)
+	}
+	return rest.JSONCodec{}.Marshal(v)
+}
+
+// Unmarshal unmarshals JSON data into v, unknown fields of messages are ignored.
+func (c ProtoJSONCodec) Unmarshal(data []byte, v interface{}) error {
+	if m, ok := message(v); ok {
+		opts := c.UnmarshalOptions
+		opts.DiscardUnknown = true
+		return opts.Unmarshal(data, m)
+	}
+	return rest.JSONCodec{}.Unmarshal(data, v)
+}
+
+// UnmarshalStrict unmarshals JSON data into v and rejects unknown fields.
+func (c ProtoJSONCodec) UnmarshalStrict(data []byte, v interface{}) error {
+	if m, ok := message(v); ok {
+		opts := c.UnmarshalOptions
+		opts.DiscardUnknown = false
+		return opts.Unmarshal(data, m)
+	}
+	return rest.JSONCodec{}.UnmarshalStrict(data, v)
+}
+
+// ProtoCodec marshals protobuf messages to the binary format with
+// google.golang.org/protobuf. Messages must implement proto.Message or the
+// APIv1 interface of github.com/golang/protobuf, other values are handled by
+// rest.ProtoCodec.
+type ProtoCodec struct {
+	MarshalOptions   proto.MarshalOptions
+	UnmarshalOptions proto.UnmarshalOptions
+}
+
+// ContentType returns application/x-protobuf.
+func (ProtoCodec) ContentType() string { return "application/x-protobuf" }
+
+// Supports reports whether v is a protobuf message.
+func (ProtoCodec) Supports(v interface{}) bool {
+	if _, ok := message(v); ok {
+		return true
+	}
+	return rest.ProtoCodec{}.Supports(v)
+}
+
+// Marshal marshals v to the protobuf binary format.
+func (c ProtoCodec) Marshal(v interface{}) ([]byte, error) {
+	if m, ok := message(v); ok {
+		return c.MarshalOptions.Marshal(m)
+	}
+	return rest.ProtoCodec{}.Marshal(v)
+}
+
+// Unmarshal unmarshals protobuf binary data into v.
+func (c ProtoCodec) Unmarshal(data []byte, v interface{}) error {
+	if m, ok := message(v); ok {
+		return c.UnmarshalOptions.Unmarshal(data, m)
+	}
+	return rest.ProtoCodec{}.Unmarshal(data, v)
+}
+
+func message(v interface{}) (proto.Message, bool) {
+	switch m := v.(type) {
+	case proto.Message:
+		return m, true
+	case protoadapt.MessageV1:
+		return protoadapt.MessageV2Of(m), true
+	}
+	return nil, false
+}
+
+// YAMLCodec marshals values with gopkg.in/yaml.v3 using their yaml struct tags.
+type YAMLCodec struct{}
+
+// ContentType returns application/yaml.
+func (YAMLCodec) ContentType() string { return "application/yaml" }
+
+// Marshal marshals v to YAML.
+func (YAMLCodec) Marshal(v interface{}) ([]byte, error) { return yaml.Marshal(v) }
+
+// Unmarshal unmarshals YAML data into v.
+func (YAMLCodec) Unmarshal(data []byte, v interface{}) error { return yaml.Unmarshal(data, v) }
+
+// UnmarshalStrict unmarshals YAML data into v and rejects unknown fields.
+func (YAMLCodec) UnmarshalStrict(data []byte, v interface{}) error {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	return dec.Decode(v)
+}
+
+// MessagePackCodec marshals values with github.com/vmihailenco/msgpack using
+// their json struct tags, so the field names match those of JSON.
+type MessagePackCodec struct{}
+
+// ContentType returns application/msgpack.
+func (MessagePackCodec) ContentType() string { return "application/msgpack" }
+
+// Marshal marshals v to MessagePack.
+func (MessagePackCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	enc.UseCompactInts(true)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Unmarshal unmarshals MessagePack data into v.
+func (c MessagePackCodec) Unmarshal(data []byte, v interface{}) error {
+	return c.decode(data, v, false)
+}
+
+// UnmarshalStrict unmarshals MessagePack data into v and rejects unknown fields.
+func (c MessagePackCodec) UnmarshalStrict(data []byte, v interface{}) error {
+	return c.decode(data, v, true)
+}
+
+func (MessagePackCodec) decode(data []byte, v interface{}, strict bool) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	dec.DisallowUnknownFields(strict)
+	return dec.Decode(v)
+}
+
+// strictCBOR rejects fields unknown to the target value.
+var strictCBOR, _ = cbor.DecOptions{ExtraReturnErrors: cbor.ExtraDecErrorUnknownField}.DecMode()
+
+// CBORCodec marshals values with github.com/fxamacker/cbor using their cbor
+// struct tags or, if missing, their json struct tags.
+type CBORCodec struct{}
+
+// ContentType returns application/cbor.
+func (CBORCodec) ContentType() string { return "application/cbor" }
+
+// Marshal marshals v to CBOR.
+func (CBORCodec) Marshal(v interface{}) ([]byte, error) { return cbor.Marshal(v) }
+
+// Unmarshal unmarshals CBOR data into v.
+func (CBORCodec) Unmarshal(data []byte, v interface{}) error { return cbor.Unmarshal(data, v) }
+
+// UnmarshalStrict unmarshals CBOR data into v and rejects unknown fields.
+func (CBORCodec) UnmarshalStrict(data []byte, v interface{}) error {
+	return strictCBOR.Unmarshal(data, v)
+}
@@ -0,0 +1,193 @@
+package codecs
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/doozer-de/rest"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type item struct {
+	Name  string   `json:"name" yaml:"name"`
+	Count int      `json:"count" yaml:"count"`
+	Tags  []string `json:"tags" yaml:"tags"`
+}
+
+type unknownField struct {
+	Name    string `json:"name" yaml:"name"`
+	Unknown bool   `json:"unknown" yaml:"unknown"`
+}
+
+// legacyMessage marshals itself like messages generated by gogo/protobuf.
+type legacyMessage struct {
+	data string
+}
+
+func (m *legacyMessage) Marshal() ([]byte, error)    { return []byte(m.data), nil }
+func (m *legacyMessage) Unmarshal(data []byte) error { m.data = string(data); return nil }
+
+func TestCodecs(t *testing.T) {
+	in := item{Name: "a", Count: 2, Tags: []string{"x", "y"}}
+	extra := unknownField{Name: "a", Unknown: true}
+
+	testcases := []struct {
+		codec interface {
+			rest.Codec
+			rest.StrictUnmarshaler
+		}
+		contentType string
+		contains    string
+	}{
+		{ProtoJSONCodec{}, "application/json", `"name":"a"`},
+		{YAMLCodec{}, "application/yaml", "name: a"},
+		{MessagePackCodec{}, "application/msgpack", "\xa4name"},
+		{CBORCodec{}, "application/cbor", "\x64name"},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.contentType, func(t *testing.T) {
+			if ct := tc.codec.ContentType(); ct != tc.contentType {
+				t.Errorf("Got: %s - want: %s", ct, tc.contentType)
+			}
+
+			data, err := tc.codec.Marshal(in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(data), tc.contains) {
+				t.Errorf("Got: %q - want: containing %q", data, tc.contains)
+			}
+
+			var out item
+			if err := tc.codec.Unmarshal(data, &out); err != nil {
+				t.Fatal(err)
+			}
+			if out.Name != in.Name || out.Count != in.Count || strings.Join(out.Tags, ",") != "x,y" {
+				t.Errorf("Got: %+v - want: %+v", out, in)
+			}
+
+			data, _ = tc.codec.Marshal(extra)
+			if err := tc.codec.Unmarshal(data, &out); err != nil {
+				t.Errorf("Got: %v - want: unknown fields to be ignored", err)
+			}
+			if err := tc.codec.UnmarshalStrict(data, &out); err == nil {
+				t.Errorf("Got: nil - want: unknown fields to be rejected")
+			}
+		})
+	}
+}
+
+func TestProtoJSONCodec(t *testing.T) {
+	c := ProtoJSONCodec{}
+
+	data, err := c.Marshal(durationpb.New(1500 * time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `"1.500s"` {
+		t.Errorf("Got: %s - want: %s", data, `"1.500s"`)
+	}
+
+	var v wrapperspb.Int64Value
+	if err := c.Unmarshal([]byte(`"42"`), &v); err != nil || v.Value != 42 {
+		t.Errorf("Got: %d (%v) - want: 42", v.Value, err)
+	}
+}
+
+func TestProtoCodec(t *testing.T) {
+	c := ProtoCodec{}
+
+	if !c.Supports(wrapperspb.String("a")) || !c.Supports(&legacyMessage{}) || c.Supports(item{}) {
+		t.Errorf("Got: unexpected Supports result - want: messages of both APIs and no other values")
+	}
+
+	data, err := c.Marshal(wrapperspb.String("abc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "\x0a\x03abc" {
+		t.Errorf("Got: %q - want: %q", data, "\x0a\x03abc")
+	}
+	var v wrapperspb.StringValue
+	if err := c.Unmarshal(data, &v); err != nil || v.Value != "abc" {
+		t.Errorf("Got: %q (%v) - want: abc", v.Value, err)
+	}
+
+	data, err = c.Marshal(&legacyMessage{data: "legacy"})
+	if err != nil || string(data) != "legacy" {
+		t.Errorf("Got: %q (%v) - want: legacy", data, err)
+	}
+	var m legacyMessage
+	if err := c.Unmarshal(data, &m); err != nil || m.data != "legacy" {
+		t.Errorf("Got: %q (%v) - want: legacy", m.data, err)
+	}
+
+	if _, err := c.Marshal(item{}); err == nil {
+		t.Errorf("Got: nil - want: error for other values")
+	}
+}
+
+func TestRegister(t *testing.T) {
+	cr := &rest.CodecRegistry{}
+	cr.Register(rest.JSONCodec{})
+	cr.Register(rest.ProtoCodec{}, "application/protobuf")
+	Register(cr)
+
+	if c, _ := cr.Default(); c != (ProtoJSONCodec{}) {
+		t.Errorf("Got: %T - want: ProtoJSONCodec to replace the default JSON codec", c)
+	}
+	for _, ct := range []string{"application/x-protobuf", "application/protobuf"} {
+		if c, _ := cr.Lookup(ct); c != (ProtoCodec{}) {
+			t.Errorf("Got: %T for %s - want: ProtoCodec", c, ct)
+		}
+	}
+	for _, ct := range []string{"application/yaml", "text/yaml", "application/msgpack", "application/x-msgpack", "application/cbor"} {
+		if _, ok := cr.Lookup(ct); !ok {
+			t.Errorf("Got: no codec - want: codec for %s", ct)
+		}
+	}
+	if mt, _, _ := cr.Negotiate("application/cbor;q=0.9, application/yaml", item{}); mt != "application/yaml" {
+		t.Errorf("Got: %s - want: application/yaml", mt)
+	}
+	if mt, _, _ := cr.Negotiate("application/x-protobuf", wrapperspb.String("a")); mt != "application/x-protobuf" {
+		t.Errorf("Got: %s - want: application/x-protobuf", mt)
+	}
+}
+
+func TestRender(t *testing.T) {
+	// Render uses the DefaultCodecs, which are restored for the other tests
+	defer func(cr *rest.CodecRegistry) { rest.DefaultCodecs = cr }(rest.DefaultCodecs)
+	rest.DefaultCodecs = &rest.CodecRegistry{}
+	rest.DefaultCodecs.Register(rest.JSONCodec{})
+	Register(rest.DefaultCodecs)
+
+	testcases := []struct {
+		accept      string
+		v           interface{}
+		contentType string
+		body        string
+	}{
+		{"application/yaml", item{Name: "a"}, "application/yaml", "name: a"},
+		{"application/x-protobuf", wrapperspb.String("abc"), "application/x-protobuf", "\x0a\x03abc"},
+	}
+
+	for _, tc := range testcases {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Accept", tc.accept)
+		if err := rest.Render(w, r, http.StatusOK, tc.v); err != nil {
+			t.Fatal(err)
+		}
+		if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, tc.contentType) {
+			t.Errorf("Got: %s - want: %s", ct, tc.contentType)
+		}
+		if !strings.Contains(w.Body.String(), tc.body) {
+			t.Errorf("Got: %q - want: containing %q", w.Body.String(), tc.body)
+		}
+	}
+}
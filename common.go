@@ -16,6 +16,22 @@ type Register struct {
 	Method  string
 	Path    string
 	Handler http.HandlerFunc
+	// Name identifies the route, e.g. for cache invalidation. If empty the path pattern is used.
+	Name string
+	// MaxBodySize overrides the MaxBodySize of the Configuration for this route.
+	MaxBodySize int64
+	// CORS overrides the CORS policy of the registrator and the Configuration for this route.
+	CORS *CORSOptions
+	// RateLimit overrides the quota of the rate limit middleware for this route.
+	RateLimit *RateLimit
+	// Authenticator overrides the authenticator of the authentication middleware for this route.
+	Authenticator Authenticator
+	// Permissions are checked by the Authorizer of the Configuration before the handler is called.
+	Permissions Permissions
+	// SecurityHeaders overrides the headers of the security headers middleware for this route.
+	SecurityHeaders *SecurityHeaders
+	// Idempotent enables the Idempotency-Key handling of the idempotency middleware for this route.
+	Idempotent bool
 }
 
 // HandlerRegistration provides methods neccessary to register routes and handlers.
@@ -25,6 +41,45 @@ type HandlerRegistration interface {
 	SetErrorHandler(h ErrorHandler) error
 }
 
+// CORSRegistration can be implemented by a HandlerRegistration to provide a
+// CORS policy for all of its routes.
+type CORSRegistration interface {
+	GetCORSOptions() *CORSOptions
+}
+
+// ChainRegistration can be implemented by a HandlerRegistration to provide
+// middlewares for all of its routes. They are executed inside the Chain of the Configuration.
+type ChainRegistration interface {
+	GetChain() []Middleware
+}
+
+// Group bundles routes below a common base URI which share a CORS policy and middlewares.
+// It satisfies HandlerRegistration, CORSRegistration and ChainRegistration, so it can be passed
+// to New or to Service.RegisterGroup.
+type Group struct {
+	BaseURI   string
+	Registers []Register
+	// CORS is the policy of all routes of the group without an own one.
+	CORS *CORSOptions
+	// Chain wraps the handlers of the group inside the Chain of the Configuration, e.g. an IPFilter.
+	Chain []Middleware
+}
+
+// GetBaseURI returns the base URI of the group.
+func (g *Group) GetBaseURI() string { return g.BaseURI }
+
+// GetHandlersToRegister returns the routes of the group.
+func (g *Group) GetHandlersToRegister() []Register { return g.Registers }
+
+// SetErrorHandler is a no-op, the handlers of a group use HandleError.
+func (g *Group) SetErrorHandler(h ErrorHandler) error { return nil }
+
+// GetCORSOptions returns the CORS policy of the group.
+func (g *Group) GetCORSOptions() *CORSOptions { return g.CORS }
+
+// GetChain returns the middlewares of the group.
+func (g *Group) GetChain() []Middleware { return g.Chain }
+
 // Param wraps a key/value pair.
 type Param struct {
 	Key   string
@@ -66,3 +121,13 @@ func (p Int32Slice) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
 
 // Sort sorts an int32 slice.
 func (p Int32Slice) Sort() { sort.Sort(p) }
+
+// Parse converts repeated and delimited values and appends them to the slice.
+func (p *Int32Slice) Parse(values []string, sep string) error {
+	s, err := ToInt32Slice(values, sep)
+	if err != nil {
+		return err
+	}
+	*p = append(*p, s...)
+	return nil
+}
@@ -0,0 +1,342 @@
+package rest
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	headerAcceptEncoding = "Accept-Encoding"
+	headerContentRange   = "Content-Range"
+
+	// DefaultCompressionMinLength is the minimum size of a response body to be compressed.
+	DefaultCompressionMinLength = 1024
+)
+
+// defaultExcludedContentTypes lists content types which are usually compressed already.
+var defaultExcludedContentTypes = []string{
+	"image/", "audio/", "video/", "font/woff",
+	"application/zip", "application/gzip", "application/x-gzip",
+	"application/x-bzip2", "application/x-7z-compressed", "application/x-rar-compressed",
+	"application/x-protobuf", "application/octet-stream",
+}
+
+// CompressWriter is a writer compressing data for a content coding.
+// The writers are pooled and reused with Reset.
+type CompressWriter interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// Compressor provides writers for a content coding like gzip.
+type Compressor struct {
+	// Encoding is the content coding token, e.g. "br".
+	Encoding string
+	// New creates a new writer writing to w.
+	New func(w io.Writer) CompressWriter
+}
+
+// GzipCompressor returns a Compressor for gzip with the given level.
+func GzipCompressor(level int) Compressor {
+	return Compressor{
+		Encoding: "gzip",
+		New: func(w io.Writer) CompressWriter {
+			zw, err := gzip.NewWriterLevel(w, level)
+			if err != nil {
+				zw = gzip.NewWriter(w)
+			}
+			return zw
+		},
+	}
+}
+
+// DeflateCompressor returns a Compressor for deflate with the given level.
+func DeflateCompressor(level int) Compressor {
+	return Compressor{
+		Encoding: "deflate",
+		New: func(w io.Writer) CompressWriter {
+			zw, err := flate.NewWriter(w, level)
+			if err != nil {
+				zw, _ = flate.NewWriter(w, flate.DefaultCompression)
+			}
+			return zw
+		},
+	}
+}
+
+// CompressionOptions represents the available compression options
+type CompressionOptions struct {
+	// Compressors in order of preference. If empty gzip and deflate with the default level are used.
+	Compressors []Compressor
+	// Responses smaller than MinLength bytes are not compressed. If 0 DefaultCompressionMinLength is used.
+	MinLength int
+	// Content types, or prefixes of them, which are not compressed. If nil a list of already compressed types is used.
+	ExcludedContentTypes []string
+}
+
+// compressor is a Compressor with its pool of writers.
+type compressor struct {
+	Compressor
+	pool sync.Pool
+}
+
+// NewCompression creates a middleware compressing responses with the content
+// coding negotiated by the Accept-Encoding header of the request.
+// Partial content is not compressed and strong ETags of compressed responses
+// are made weak, as they identify the unencoded bytes.
+func NewCompression(opts *CompressionOptions) Middleware {
+	if opts == nil {
+		opts = &CompressionOptions{}
+	}
+
+	cs := opts.Compressors
+	if len(cs) == 0 {
+		cs = []Compressor{GzipCompressor(gzip.DefaultCompression), DeflateCompressor(flate.DefaultCompression)}
+	}
+
+	compressors := make([]*compressor, len(cs))
+	for i, c := range cs {
+		compressors[i] = &compressor{Compressor: c}
+	}
+
+	minLength := opts.MinLength
+	if minLength == 0 {
+		minLength = DefaultCompressionMinLength
+	}
+
+	excluded := opts.ExcludedContentTypes
+	if excluded == nil {
+		excluded = defaultExcludedContentTypes
+	}
+
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			addVary(w.Header(), headerAcceptEncoding)
+
+			c := negotiateEncoding(r.Header.Get(headerAcceptEncoding), compressors)
+			if c == nil || r.Method == http.MethodHead {
+				next(w, r)
+				return
+			}
+
+			cw := &compressResponseWriter{
+				ResponseWriter: w,
+				compressor:     c,
+				minLength:      minLength,
+				excluded:       excluded,
+			}
+			defer cw.close()
+
+			next(cw, r)
+		}
+	}
+}
+
+// negotiateEncoding selects the compressor with the highest q-value in the Accept-Encoding header.
+func negotiateEncoding(acceptEncoding string, compressors []*compressor) *compressor {
+	if acceptEncoding == "" {
+		return nil
+	}
+
+	q := map[string]float64{}
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		fields := strings.Split(part, ";")
+		coding := strings.ToLower(strings.TrimSpace(fields[0]))
+		if coding == "" {
+			continue
+		}
+
+		value := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				v, err := strconv.ParseFloat(param[2:], 64)
+				if err != nil {
+					v = 0
+				}
+				value = v
+			}
+		}
+		q[coding] = value
+	}
+
+	var (
+		best  *compressor
+		bestQ float64
+	)
+	for _, c := range compressors {
+		v, ok := q[c.Encoding]
+		if !ok {
+			v, ok = q["*"]
+		}
+		if ok && v > bestQ {
+			best, bestQ = c, v
+		}
+	}
+	return best
+}
+
+// compressResponseWriter buffers the beginning of a response until it is
+// decided whether it is compressed.
+type compressResponseWriter struct {
+	http.ResponseWriter
+	compressor *compressor
+	minLength  int
+	excluded   []string
+
+	status   int
+	buf      []byte
+	decided  bool
+	hijacked bool
+	writer   CompressWriter
+}
+
+func (cw *compressResponseWriter) WriteHeader(status int) {
+	if status >= 100 && status < 200 {
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if cw.decided || cw.status != 0 {
+		return
+	}
+	cw.status = status
+}
+
+func (cw *compressResponseWriter) Write(p []byte) (int, error) {
+	if !cw.decided {
+		cw.buf = append(cw.buf, p...)
+		if len(cw.buf) < cw.minLength {
+			return len(p), nil
+		}
+		if err := cw.decide(true); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+
+	if cw.writer != nil {
+		return cw.writer.Write(p)
+	}
+	return cw.ResponseWriter.Write(p)
+}
+
+// decide writes the header and the buffered data, compressed if allowed.
+func (cw *compressResponseWriter) decide(compress bool) error {
+	cw.decided = true
+
+	h := cw.Header()
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+
+	if h.Get(headerContentType) == "" && len(cw.buf) > 0 {
+		h.Set(headerContentType, http.DetectContentType(cw.buf))
+	}
+
+	if compress && cw.compressible() {
+		h.Del(headerContentLength)
+		h.Set(headerContentEncoding, cw.compressor.Encoding)
+		// the encoded bytes differ from those of the strong validator
+		if etag := h.Get(headerETag); etag != "" && !isWeakETag(etag) {
+			h.Set(headerETag, "W/"+etag)
+		}
+
+		if zw, ok := cw.compressor.pool.Get().(CompressWriter); ok {
+			zw.Reset(cw.ResponseWriter)
+			cw.writer = zw
+		} else {
+			cw.writer = cw.compressor.New(cw.ResponseWriter)
+		}
+	}
+
+	cw.ResponseWriter.WriteHeader(cw.status)
+
+	buf := cw.buf
+	cw.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	if cw.writer != nil {
+		_, err := cw.writer.Write(buf)
+		return err
+	}
+	_, err := cw.ResponseWriter.Write(buf)
+	return err
+}
+
+// compressible reports whether status and headers of the response allow
+// compression. Partial content is not compressed, as its ranges refer to the
+// unencoded representation.
+func (cw *compressResponseWriter) compressible() bool {
+	if cw.status < 200 || cw.status == http.StatusNoContent || cw.status == http.StatusNotModified ||
+		cw.status == http.StatusPartialContent {
+		return false
+	}
+
+	h := cw.Header()
+	if h.Get(headerContentRange) != "" {
+		return false
+	}
+	if ce := h.Get(headerContentEncoding); ce != "" && ce != "identity" {
+		return false
+	}
+
+	ct := strings.ToLower(h.Get(headerContentType))
+	for _, e := range cw.excluded {
+		if strings.HasPrefix(ct, e) {
+			return false
+		}
+	}
+	return true
+}
+
+// Flush sends the buffered data, compressing it if allowed regardless of its size.
+func (cw *compressResponseWriter) Flush() {
+	if !cw.decided {
+		cw.decide(len(cw.buf) > 0)
+	}
+	if cw.writer != nil {
+		cw.writer.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack hands over the connection if nothing has been written yet.
+func (cw *compressResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := cw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("rest: response writer does not support hijacking")
+	}
+	if cw.decided || len(cw.buf) > 0 {
+		return nil, nil, errors.New("rest: response already written")
+	}
+	cw.hijacked = true
+	return hj.Hijack()
+}
+
+// close completes the response after the handler returned.
+func (cw *compressResponseWriter) close() {
+	if cw.hijacked {
+		return
+	}
+	if !cw.decided {
+		cw.decide(len(cw.buf) >= cw.minLength)
+	}
+	if cw.writer != nil {
+		cw.writer.Close()
+		cw.writer.Reset(io.Discard)
+		cw.compressor.pool.Put(cw.writer)
+		cw.writer = nil
+	}
+}
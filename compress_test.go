@@ -0,0 +1,255 @@
+package rest
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func compressHandler(contentType, body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if contentType != "" {
+			w.Header().Set(headerContentType, contentType)
+		}
+		w.Header().Set(headerContentLength, "12345")
+		w.WriteHeader(http.StatusOK)
+		io.WriteString(w, body)
+	}
+}
+
+func TestCompression(t *testing.T) {
+	large := strings.Repeat("compress me ", 200)
+
+	testcases := []struct {
+		test           string
+		method         string
+		acceptEncoding string
+		contentType    string
+		body           string
+		encoding       string
+	}{
+		{
+			test:           "gzip",
+			acceptEncoding: "gzip, deflate",
+			contentType:    "application/json",
+			body:           large,
+			encoding:       "gzip",
+		},
+		{
+			test:           "deflate preferred by q-value",
+			acceptEncoding: "gzip;q=0.5, deflate",
+			contentType:    "application/json",
+			body:           large,
+			encoding:       "deflate",
+		},
+		{
+			test:           "wildcard",
+			acceptEncoding: "*",
+			contentType:    "application/json",
+			body:           large,
+			encoding:       "gzip",
+		},
+		{
+			test:           "gzip excluded",
+			acceptEncoding: "gzip;q=0, deflate;q=0",
+			contentType:    "application/json",
+			body:           large,
+		},
+		{
+			test:        "no accept encoding",
+			contentType: "application/json",
+			body:        large,
+		},
+		{
+			test:           "small body",
+			acceptEncoding: "gzip",
+			contentType:    "application/json",
+			body:           "{}",
+		},
+		{
+			test:           "compressed content type",
+			acceptEncoding: "gzip",
+			contentType:    "image/png",
+			body:           large,
+		},
+		{
+			test:           "sniffed content type",
+			acceptEncoding: "gzip",
+			body:           large,
+			encoding:       "gzip",
+		},
+		{
+			test:           "head",
+			method:         http.MethodHead,
+			acceptEncoding: "gzip",
+			contentType:    "application/json",
+			body:           large,
+		},
+	}
+
+	m := NewCompression(nil)
+
+	for _, tc := range testcases {
+		t.Run(tc.test, func(t *testing.T) {
+			method := tc.method
+			if method == "" {
+				method = http.MethodGet
+			}
+
+			recorder := httptest.NewRecorder()
+			r, _ := http.NewRequest(method, "/", nil)
+			if tc.acceptEncoding != "" {
+				r.Header.Set(headerAcceptEncoding, tc.acceptEncoding)
+			}
+			m(compressHandler(tc.contentType, tc.body))(recorder, r)
+
+			headers := recorder.Result().Header
+			if v := headers.Get(headerVary); v != headerAcceptEncoding {
+				t.Errorf("Vary is expected to be Accept-Encoding, found %v", v)
+			}
+
+			if v := headers.Get(headerContentEncoding); v != tc.encoding {
+				t.Fatalf("Content-Encoding is expected to be %v, found %v", tc.encoding, v)
+			}
+
+			if tc.encoding == "" {
+				if v := headers.Get(headerContentLength); v != "12345" {
+					t.Errorf("Content-Length is expected to be kept, found %v", v)
+				}
+				if method != http.MethodHead && recorder.Body.String() != tc.body {
+					t.Errorf("Body is expected to be unchanged")
+				}
+				return
+			}
+
+			if v := headers.Get(headerContentLength); v != "" {
+				t.Errorf("Content-Length should not exist, found %v", v)
+			}
+
+			if v := headers.Get(headerContentType); v == "" {
+				t.Errorf("Content-Type should be set")
+			}
+
+			var zr io.Reader
+			if tc.encoding == "gzip" {
+				var err error
+				if zr, err = gzip.NewReader(recorder.Body); err != nil {
+					t.Fatal(err)
+				}
+			} else {
+				zr = flate.NewReader(recorder.Body)
+			}
+
+			body, err := io.ReadAll(zr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(body) != tc.body {
+				t.Errorf("Body is expected to be decompressed to the original")
+			}
+		})
+	}
+}
+
+func TestCompressionRangeAndETag(t *testing.T) {
+	large := strings.Repeat("compress me ", 200)
+
+	testcases := []struct {
+		test     string
+		status   int
+		header   map[string]string
+		encoding string
+		etag     string
+	}{
+		{"strong etag weakened", http.StatusOK, map[string]string{headerETag: `"v1"`}, "gzip", `W/"v1"`},
+		{"weak etag kept", http.StatusOK, map[string]string{headerETag: `W/"v1"`}, "gzip", `W/"v1"`},
+		{"partial content", http.StatusPartialContent, map[string]string{headerETag: `"v1"`, headerContentRange: "bytes 0-2399/4800"}, "", `"v1"`},
+		{"content range", http.StatusOK, map[string]string{headerContentRange: "bytes 0-2399/2400"}, "", ""},
+	}
+
+	m := NewCompression(nil)
+
+	for _, tc := range testcases {
+		t.Run(tc.test, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			r, _ := http.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set(headerAcceptEncoding, "gzip")
+			m(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set(headerContentType, "text/plain")
+				for k, v := range tc.header {
+					w.Header().Set(k, v)
+				}
+				w.WriteHeader(tc.status)
+				io.WriteString(w, large)
+			})(recorder, r)
+
+			headers := recorder.Result().Header
+			if v := headers.Get(headerContentEncoding); v != tc.encoding {
+				t.Errorf("Content-Encoding is expected to be %q, found %q", tc.encoding, v)
+			}
+			if v := headers.Get(headerETag); v != tc.etag {
+				t.Errorf("ETag is expected to be %v, found %v", tc.etag, v)
+			}
+			if tc.encoding == "" && recorder.Body.String() != large {
+				t.Errorf("Body is expected to be unchanged")
+			}
+		})
+	}
+}
+
+func TestCompressionFlush(t *testing.T) {
+	m := NewCompression(nil)
+
+	recorder := httptest.NewRecorder()
+	r, _ := http.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(headerAcceptEncoding, "gzip")
+
+	m(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(headerContentType, "text/event-stream")
+		io.WriteString(w, "data: 1\n\n")
+		w.(http.Flusher).Flush()
+
+		if !recorder.Flushed {
+			t.Errorf("Response is expected to be flushed")
+		}
+
+		io.WriteString(w, "data: 2\n\n")
+	})(recorder, r)
+
+	if v := recorder.Header().Get(headerContentEncoding); v != "gzip" {
+		t.Fatalf("Content-Encoding is expected to be gzip, found %v", v)
+	}
+
+	zr, err := gzip.NewReader(recorder.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(zr)
+	if string(body) != "data: 1\n\ndata: 2\n\n" {
+		t.Errorf("Body is expected to contain both events, found %q", body)
+	}
+}
+
+func TestCompressionNoContent(t *testing.T) {
+	m := NewCompression(&CompressionOptions{MinLength: 1})
+
+	recorder := httptest.NewRecorder()
+	r, _ := http.NewRequest(http.MethodDelete, "/", nil)
+	r.Header.Set(headerAcceptEncoding, "gzip")
+
+	m(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})(recorder, r)
+
+	if recorder.Code != http.StatusNoContent {
+		t.Errorf("Status code is expected to be 204, found %d", recorder.Code)
+	}
+
+	if v := recorder.Header().Get(headerContentEncoding); v != "" {
+		t.Errorf("Content-Encoding should not exist, found %v", v)
+	}
+}
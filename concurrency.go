@@ -0,0 +1,276 @@
+package rest
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrOverloaded is reported with status 503 if a request is shed.
+var ErrOverloaded = errors.New("service overloaded")
+
+// AdaptiveLimit adjusts a concurrency limit by additive increase and
+// multiplicative decrease (AIMD): the limit grows by one per limit requests
+// faster than TargetLatency and shrinks by Backoff for each slower one or server error.
+type AdaptiveLimit struct {
+	// MinLimit is the lower bound of the limit, at least 1.
+	MinLimit int
+	// MaxLimit is the upper bound of the limit. If 0 the configured cap is used.
+	MaxLimit      int
+	TargetLatency time.Duration
+	// Backoff is the factor the limit is multiplied with on decrease. If 0 0.9 is used.
+	Backoff float64
+}
+
+// ConcurrencyOptions represents the available concurrency limit options
+type ConcurrencyOptions struct {
+	// MaxInFlight caps the requests processed concurrently by the service. 0 means no cap.
+	MaxInFlight int
+	// MaxInFlightPerRoute caps the requests processed concurrently per route pattern. 0 means no cap.
+	MaxInFlightPerRoute int
+	// MaxQueue is the number of requests waiting for a slot; further requests are shed.
+	MaxQueue int
+	// MaxWait is the time a request waits in the queue before it is shed.
+	MaxWait time.Duration
+	// RetryAfter is sent with shed requests. If 0 one second is used.
+	RetryAfter time.Duration
+	// Adaptive, if set, adjusts the limits within its bounds starting at the configured caps.
+	Adaptive *AdaptiveLimit
+}
+
+// ConcurrencyStats is the state of a concurrency limit.
+type ConcurrencyStats struct {
+	Limit    int
+	InFlight int
+	Queued   int
+	Shed     uint64
+}
+
+// ConcurrencyLimiter caps the requests in flight globally and per route
+// pattern. Requests exceeding the cap wait in a bounded queue and are shed
+// with ErrOverloaded, status 503 and Retry-After through the ErrorHandler.
+type ConcurrencyLimiter struct {
+	opts   ConcurrencyOptions
+	global *concurrencyLimit
+
+	mu     sync.Mutex
+	routes map[string]*concurrencyLimit
+}
+
+// NewConcurrencyLimiter creates a new ConcurrencyLimiter. Its Middleware is added to the Chain of the Configuration.
+func NewConcurrencyLimiter(opts *ConcurrencyOptions) *ConcurrencyLimiter {
+	if opts == nil {
+		opts = &ConcurrencyOptions{}
+	}
+
+	cl := &ConcurrencyLimiter{
+		opts:   *opts,
+		routes: map[string]*concurrencyLimit{},
+	}
+	if cl.opts.RetryAfter == 0 {
+		cl.opts.RetryAfter = time.Second
+	}
+	if opts.MaxInFlight > 0 {
+		cl.global = cl.newLimit(opts.MaxInFlight)
+	}
+	return cl
+}
+
+// Stats returns the state of the limits by route pattern. The global limit has the empty key.
+func (cl *ConcurrencyLimiter) Stats() map[string]ConcurrencyStats {
+	stats := map[string]ConcurrencyStats{}
+	if cl.global != nil {
+		stats[""] = cl.global.stats()
+	}
+
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	for pattern, l := range cl.routes {
+		stats[pattern] = l.stats()
+	}
+	return stats
+}
+
+// Middleware sheds requests exceeding the limits.
+func (cl *ConcurrencyLimiter) Middleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var limits []*concurrencyLimit
+		if l := cl.routeLimit(RoutePattern(r.Context())); l != nil {
+			limits = append(limits, l)
+		}
+		if cl.global != nil {
+			limits = append(limits, cl.global)
+		}
+
+		for i, l := range limits {
+			if !l.acquire(r, cl.opts.MaxQueue, cl.opts.MaxWait) {
+				for _, acquired := range limits[:i] {
+					acquired.release(0, false)
+				}
+				w.Header().Set(headerRetryAfter, strconv.Itoa(ceilSeconds(cl.opts.RetryAfter)))
+				HandleError(w, r, NewStatusError(http.StatusServiceUnavailable, ErrOverloaded))
+				return
+			}
+		}
+
+		sw := &statusResponseWriter{ResponseWriter: w}
+		start := time.Now()
+		defer func() {
+			latency := time.Since(start)
+			for _, l := range limits {
+				l.release(latency, sw.status >= 500)
+			}
+		}()
+
+		next(sw, r)
+	}
+}
+
+func (cl *ConcurrencyLimiter) routeLimit(pattern string) *concurrencyLimit {
+	if cl.opts.MaxInFlightPerRoute <= 0 {
+		return nil
+	}
+
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	l, ok := cl.routes[pattern]
+	if !ok {
+		l = cl.newLimit(cl.opts.MaxInFlightPerRoute)
+		cl.routes[pattern] = l
+	}
+	return l
+}
+
+func (cl *ConcurrencyLimiter) newLimit(limit int) *concurrencyLimit {
+	l := &concurrencyLimit{limit: float64(limit)}
+	if cl.opts.Adaptive != nil {
+		a := *cl.opts.Adaptive
+		if a.MaxLimit <= 0 {
+			a.MaxLimit = limit
+		}
+		l.adaptive = &a
+	}
+	return l
+}
+
+// concurrencyLimit is a single limit with its queue of waiting requests.
+type concurrencyLimit struct {
+	mu       sync.Mutex
+	limit    float64
+	inFlight int
+	queue    []chan struct{}
+	shed     uint64
+	adaptive *AdaptiveLimit
+}
+
+// acquire takes a slot, waiting in the queue if necessary. It reports false if the request is shed.
+func (l *concurrencyLimit) acquire(r *http.Request, maxQueue int, maxWait time.Duration) bool {
+	l.mu.Lock()
+	if l.inFlight < int(l.limit) {
+		l.inFlight++
+		l.mu.Unlock()
+		return true
+	}
+	if len(l.queue) >= maxQueue || maxWait <= 0 {
+		l.shed++
+		l.mu.Unlock()
+		return false
+	}
+
+	ready := make(chan struct{})
+	l.queue = append(l.queue, ready)
+	l.mu.Unlock()
+
+	timer := time.NewTimer(maxWait)
+	defer timer.Stop()
+
+	select {
+	case <-ready:
+		return true
+	case <-timer.C:
+	case <-r.Context().Done():
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i, c := range l.queue {
+		if c == ready {
+			l.queue = append(l.queue[:i], l.queue[i+1:]...)
+			l.shed++
+			return false
+		}
+	}
+	// the slot was granted concurrently
+	return true
+}
+
+// release frees a slot, adapts the limit and hands free slots to waiting requests.
+func (l *concurrencyLimit) release(latency time.Duration, failed bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.inFlight--
+
+	if a := l.adaptive; a != nil && latency > 0 {
+		if failed || latency > a.TargetLatency {
+			backoff := a.Backoff
+			if backoff == 0 {
+				backoff = 0.9
+			}
+			l.limit = math.Max(float64(a.MinLimit), l.limit*backoff)
+		} else {
+			l.limit = math.Min(float64(a.MaxLimit), l.limit+1/math.Max(l.limit, 1))
+		}
+		if l.limit < 1 {
+			l.limit = 1
+		}
+	}
+
+	for len(l.queue) > 0 && l.inFlight < int(l.limit) {
+		close(l.queue[0])
+		l.queue = l.queue[1:]
+		l.inFlight++
+	}
+}
+
+func (l *concurrencyLimit) stats() ConcurrencyStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return ConcurrencyStats{
+		Limit:    int(l.limit),
+		InFlight: l.inFlight,
+		Queued:   len(l.queue),
+		Shed:     l.shed,
+	}
+}
+
+// statusResponseWriter records the status of a response.
+type statusResponseWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sw *statusResponseWriter) WriteHeader(status int) {
+	if sw.status == 0 {
+		sw.status = status
+	}
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *statusResponseWriter) Write(p []byte) (int, error) {
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+	return sw.ResponseWriter.Write(p)
+}
+
+// Flush flushes the underlying ResponseWriter if it supports it.
+func (sw *statusResponseWriter) Flush() {
+	if f, ok := sw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,165 @@
+package rest
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConcurrencyLimiter(t *testing.T) {
+	testcases := []struct {
+		test       string
+		opts       ConcurrencyOptions
+		requests   int
+		concurrent int
+		served     int
+		shed       int
+	}{
+		{"global, no queue", ConcurrencyOptions{MaxInFlight: 2}, 5, 2, 2, 3},
+		{"per route, no queue", ConcurrencyOptions{MaxInFlightPerRoute: 1}, 3, 1, 1, 2},
+		{"queue", ConcurrencyOptions{MaxInFlight: 2, MaxQueue: 2, MaxWait: time.Second}, 5, 2, 4, 1},
+		{"queue timeout", ConcurrencyOptions{MaxInFlight: 1, MaxQueue: 5, MaxWait: 10 * time.Millisecond}, 3, 1, 1, 2},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.test, func(t *testing.T) {
+			var (
+				mu       sync.Mutex
+				served   int
+				shed     int
+				started  = make(chan struct{}, tc.requests)
+				release  = make(chan struct{})
+				reported error
+			)
+
+			cl := NewConcurrencyLimiter(&tc.opts)
+			s := New(Configuration{
+				Chain: []Middleware{cl.Middleware},
+				ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+					mu.Lock()
+					shed++
+					reported = err
+					mu.Unlock()
+					w.WriteHeader(ErrorStatus(err))
+				},
+			}, nil)
+			s.Register([]Register{{
+				Method: http.MethodGet,
+				Path:   "/slow",
+				Handler: func(w http.ResponseWriter, r *http.Request) {
+					started <- struct{}{}
+					<-release
+					mu.Lock()
+					served++
+					mu.Unlock()
+				},
+			}}, "")
+
+			var wg sync.WaitGroup
+			recorders := make([]*httptest.ResponseRecorder, tc.requests)
+			for i := 0; i < tc.requests; i++ {
+				recorders[i] = httptest.NewRecorder()
+				wg.Add(1)
+				go func(recorder *httptest.ResponseRecorder) {
+					defer wg.Done()
+					r, _ := http.NewRequest(http.MethodGet, "/slow", nil)
+					s.ServeHTTP(recorder, r)
+				}(recorders[i])
+			}
+
+			// wait until the limit is reached and the remaining requests are queued or shed
+			deadline := time.Now().Add(time.Second)
+			for time.Now().Before(deadline) {
+				mu.Lock()
+				n := shed
+				mu.Unlock()
+				queued := 0
+				for _, st := range cl.Stats() {
+					queued += st.Queued
+				}
+				if len(started) == tc.concurrent && n+queued == tc.requests-tc.concurrent {
+					break
+				}
+				time.Sleep(time.Millisecond)
+			}
+			time.Sleep(20 * time.Millisecond)
+
+			close(release)
+			wg.Wait()
+
+			if served != tc.served || shed != tc.shed {
+				t.Errorf("Got: %d served, %d shed - want: %d served, %d shed", served, shed, tc.served, tc.shed)
+			}
+
+			if !errors.Is(reported, ErrOverloaded) {
+				t.Errorf("Got: %v - want: %v", reported, ErrOverloaded)
+			}
+
+			for _, recorder := range recorders {
+				if recorder.Code == http.StatusServiceUnavailable && recorder.Header().Get(headerRetryAfter) != "1" {
+					t.Errorf("Retry-After is expected to be 1, found %v", recorder.Header().Get(headerRetryAfter))
+				}
+			}
+
+			for pattern, st := range cl.Stats() {
+				if st.InFlight != 0 || st.Queued != 0 {
+					t.Errorf("Limit %q is expected to be idle, found %+v", pattern, st)
+				}
+			}
+		})
+	}
+}
+
+func TestAdaptiveLimit(t *testing.T) {
+	l := &concurrencyLimit{
+		limit:    10,
+		adaptive: &AdaptiveLimit{MinLimit: 2, MaxLimit: 11, TargetLatency: 100 * time.Millisecond, Backoff: 0.5},
+	}
+
+	for i := 0; i < 15; i++ {
+		l.inFlight++
+		l.release(10*time.Millisecond, false)
+	}
+	if st := l.stats(); st.Limit != 11 {
+		t.Errorf("Limit is expected to grow to 11, found %d", st.Limit)
+	}
+
+	l.inFlight++
+	l.release(time.Second, false)
+	if st := l.stats(); st.Limit != 5 {
+		t.Errorf("Limit is expected to shrink to 5, found %d", st.Limit)
+	}
+
+	for i := 0; i < 5; i++ {
+		l.inFlight++
+		l.release(10*time.Millisecond, true)
+	}
+	if st := l.stats(); st.Limit != 2 {
+		t.Errorf("Limit is expected to shrink to the minimum 2, found %d", st.Limit)
+	}
+}
+
+func TestAdaptiveLimitDefaultMax(t *testing.T) {
+	cl := NewConcurrencyLimiter(&ConcurrencyOptions{
+		MaxInFlight:         50,
+		MaxInFlightPerRoute: 20,
+		Adaptive:            &AdaptiveLimit{TargetLatency: time.Second},
+	})
+	s := New(Configuration{Chain: []Middleware{cl.Middleware}}, nil)
+	s.Register([]Register{{Method: http.MethodGet, Path: "/fast", Handler: func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Millisecond)
+	}}}, "")
+
+	s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/fast", nil))
+
+	stats := cl.Stats()
+	if stats[""].Limit != 50 {
+		t.Errorf("expected the global limit to stay at 50, found %d", stats[""].Limit)
+	}
+	if stats["/fast"].Limit != 20 {
+		t.Errorf("expected the route limit to stay at 20, found %d", stats["/fast"].Limit)
+	}
+}
@@ -2,7 +2,13 @@ package rest
 
 import (
 	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"math"
 	"strconv"
+	"strings"
+	"time"
+	"unicode"
 )
 
 // ToBool tries to parse the given string to a bool value.
@@ -64,8 +70,360 @@ func ToUint64(value string) (uint64, bool) {
 	return uint64(v), err == nil
 }
 
-// ToBytes tries to parse the given string to a bytes value.
+// ToBytes tries to parse the given string to a bytes value using the DefaultBytesEncoding.
 func ToBytes(value string) ([]byte, bool) {
-	decoded, err := base64.URLEncoding.DecodeString(value)
+	return DefaultBytesEncoding.Decode(value)
+}
+
+// FromBytes converts the given bytes value to a string using the DefaultBytesEncoding.
+// The result can be used in paths and query strings without escaping.
+func FromBytes(value []byte) string {
+	return DefaultBytesEncoding.Encode(value)
+}
+
+// BytesEncoding describes how bytes values are represented in paths and query strings.
+//
+// By default base64 is used as defined by the proto3 JSON mapping: standard and
+// URL-safe alphabets, even mixed, with or without padding are accepted when
+// decoding, values are encoded URL-safe without padding.
+type BytesEncoding struct {
+	// If set, values are hex encoded instead of base64.
+	Hex bool
+	// If set, only canonical encodings are accepted: padding bits must be zero,
+	// the alphabets must not be mixed and spaces are not treated as "+" which
+	// an unescaped query string turns into spaces.
+	Strict bool
+}
+
+// DefaultBytesEncoding is used by ToBytes and FromBytes.
+var DefaultBytesEncoding = BytesEncoding{}
+
+// Decode tries to parse the given string to a bytes value.
+func (e BytesEncoding) Decode(value string) ([]byte, bool) {
+	if e.Hex {
+		decoded, err := hex.DecodeString(value)
+		return decoded, err == nil
+	}
+
+	enc := base64.StdEncoding
+	if !e.Strict {
+		// both alphabets may be mixed, so the URL-safe one is mapped to the standard one
+		value = strings.NewReplacer(" ", "+", "-", "+", "_", "/").Replace(value)
+	} else if strings.ContainsAny(value, "-_") {
+		if strings.ContainsAny(value, "+/") {
+			return nil, false
+		}
+		enc = base64.URLEncoding
+	}
+
+	if len(value)%4 != 0 {
+		enc = enc.WithPadding(base64.NoPadding)
+	}
+
+	if e.Strict {
+		enc = enc.Strict()
+	}
+
+	decoded, err := enc.DecodeString(value)
 	return decoded, err == nil
 }
+
+// Encode converts the given bytes value to a string.
+func (e BytesEncoding) Encode(value []byte) string {
+	if e.Hex {
+		return hex.EncodeToString(value)
+	}
+	return base64.RawURLEncoding.EncodeToString(value)
+}
+
+// Delimiters for array values in path and query parameters. They correspond
+// to the OpenAPI style keyword: Exploded expects repeated keys (?id=1&id=2),
+// the other delimiters split a single value (?id=1,2 for the form style).
+const (
+	Exploded       = ""
+	FormDelimited  = ","
+	SpaceDelimited = " "
+	PipeDelimited  = "|"
+)
+
+// ElementError describes a single element of a slice value that could not be converted.
+type ElementError struct {
+	Index int
+	Value string
+}
+
+// SliceError is returned by the slice converters and lists all elements that could not be converted.
+type SliceError []ElementError
+
+func (e SliceError) Error() string {
+	elems := make([]string, len(e))
+	for i, el := range e {
+		elems[i] = fmt.Sprintf("%d: %q", el.Index, el.Value)
+	}
+	return "invalid slice elements: " + strings.Join(elems, ", ")
+}
+
+// SplitValues flattens repeated values and splits each of them by sep.
+// Values which are entirely empty are skipped, so ?id= yields no elements.
+// With sep set to Exploded the values are only flattened.
+func SplitValues(values []string, sep string) []string {
+	var elems []string
+	for _, v := range values {
+		if v == "" {
+			continue
+		}
+		if sep == Exploded {
+			elems = append(elems, v)
+			continue
+		}
+		elems = append(elems, strings.Split(v, sep)...)
+	}
+	return elems
+}
+
+// ToSlice converts repeated and delimited values to a slice using the given
+// element converter. All elements are converted; if some of them fail the
+// returned error is a SliceError containing every invalid element.
+func ToSlice[T any](values []string, sep string, convert func(string) (T, bool)) ([]T, error) {
+	elems := SplitValues(values, sep)
+	if len(elems) == 0 {
+		return nil, nil
+	}
+
+	var serr SliceError
+	s := make([]T, 0, len(elems))
+	for i, e := range elems {
+		v, ok := convert(e)
+		if !ok {
+			serr = append(serr, ElementError{Index: i, Value: e})
+			continue
+		}
+		s = append(s, v)
+	}
+
+	if len(serr) > 0 {
+		return nil, serr
+	}
+	return s, nil
+}
+
+// ToBoolSlice tries to parse the given values to a bool slice.
+func ToBoolSlice(values []string, sep string) ([]bool, error) {
+	return ToSlice(values, sep, ToBool)
+}
+
+// ToFloat32Slice tries to parse the given values to a float32 slice.
+func ToFloat32Slice(values []string, sep string) ([]float32, error) {
+	return ToSlice(values, sep, ToFloat32)
+}
+
+// ToFloat64Slice tries to parse the given values to a float64 slice.
+func ToFloat64Slice(values []string, sep string) ([]float64, error) {
+	return ToSlice(values, sep, ToFloat64)
+}
+
+// ToStringSlice tries to parse the given values to a string slice. Empty elements are rejected.
+func ToStringSlice(values []string, sep string) ([]string, error) {
+	return ToSlice(values, sep, ToString)
+}
+
+// ToIntSlice tries to parse the given values to a int slice.
+func ToIntSlice(values []string, sep string) ([]int, error) {
+	return ToSlice(values, sep, ToInt)
+}
+
+// ToInt32Slice tries to parse the given values to a Int32Slice.
+func ToInt32Slice(values []string, sep string) (Int32Slice, error) {
+	return ToSlice(values, sep, ToInt32)
+}
+
+// ToInt64Slice tries to parse the given values to a int64 slice.
+func ToInt64Slice(values []string, sep string) ([]int64, error) {
+	return ToSlice(values, sep, ToInt64)
+}
+
+// ToUintSlice tries to parse the given values to a uint slice.
+func ToUintSlice(values []string, sep string) ([]uint, error) {
+	return ToSlice(values, sep, ToUint)
+}
+
+// ToUint32Slice tries to parse the given values to a uint32 slice.
+func ToUint32Slice(values []string, sep string) ([]uint32, error) {
+	return ToSlice(values, sep, ToUint32)
+}
+
+// ToUint64Slice tries to parse the given values to a uint64 slice.
+func ToUint64Slice(values []string, sep string) ([]uint64, error) {
+	return ToSlice(values, sep, ToUint64)
+}
+
+// ToBytesSlice tries to parse the given values to a slice of bytes values.
+func ToBytesSlice(values []string, sep string) ([][]byte, error) {
+	return ToSlice(values, sep, ToBytes)
+}
+
+// Limits of google.protobuf.Timestamp and google.protobuf.Duration as defined by the proto3 JSON mapping.
+var (
+	minTimestamp = time.Date(1, 1, 1, 0, 0, 0, 0, time.UTC)
+	maxTimestamp = time.Date(9999, 12, 31, 23, 59, 59, 999999999, time.UTC)
+)
+
+const maxDurationSeconds = 315576000000
+
+// ToTimestamp tries to parse the given RFC 3339 string to a time value as
+// google.protobuf.Timestamp does. Offsets are accepted and the result is in UTC.
+func ToTimestamp(value string) (time.Time, bool) {
+	v, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, false
+	}
+	v = v.UTC()
+	if v.Before(minTimestamp) || v.After(maxTimestamp) {
+		return time.Time{}, false
+	}
+	return v, true
+}
+
+// ToDuration tries to parse the given string to a duration value as
+// google.protobuf.Duration does: seconds with up to nine fractional digits
+// followed by "s", e.g. "1.5s" or "-0.000000001s". Durations which do not fit
+// into a time.Duration are rejected.
+func ToDuration(value string) (time.Duration, bool) {
+	s := strings.TrimSuffix(value, "s")
+	if s == value || s == "" {
+		return 0, false
+	}
+
+	neg := strings.HasPrefix(s, "-")
+	if neg {
+		s = s[1:]
+	}
+
+	secs, frac := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		secs, frac = s[:i], s[i+1:]
+		if frac == "" || len(frac) > 9 {
+			return 0, false
+		}
+	}
+
+	if secs == "" || strings.ContainsAny(secs+frac, "+-") {
+		return 0, false
+	}
+
+	sec, err := strconv.ParseInt(secs, 10, 64)
+	if err != nil || sec > maxDurationSeconds {
+		return 0, false
+	}
+
+	var nanos int64
+	if frac != "" {
+		nanos, err = strconv.ParseInt(frac+strings.Repeat("0", 9-len(frac)), 10, 64)
+		if err != nil {
+			return 0, false
+		}
+	}
+
+	if sec > (math.MaxInt64-nanos)/int64(time.Second) {
+		return 0, false
+	}
+
+	d := time.Duration(sec)*time.Second + time.Duration(nanos)
+	if neg {
+		d = -d
+	}
+	return d, true
+}
+
+// ToFieldMask tries to parse the given string to the paths of a
+// google.protobuf.FieldMask. Paths are comma separated and written in
+// lowerCamelCase, they are returned in snake_case, e.g. "user.displayName,id"
+// results in ["user.display_name", "id"].
+func ToFieldMask(value string) ([]string, bool) {
+	if value == "" {
+		return []string{}, true
+	}
+
+	paths := strings.Split(value, ",")
+	for i, p := range paths {
+		if p == "" || strings.ContainsRune(p, '_') {
+			return nil, false
+		}
+
+		var b strings.Builder
+		for _, r := range p {
+			if unicode.IsUpper(r) {
+				b.WriteByte('_')
+				r = unicode.ToLower(r)
+			}
+			b.WriteRune(r)
+		}
+		paths[i] = b.String()
+	}
+	return paths, true
+}
+
+// ToEnum tries to parse the given string to an enum value. The value can be
+// given by name or by number. values maps the names to the numbers, it is the
+// map generated for each enum by protoc-gen-go, e.g. Status_value.
+// As proto3 enums are open, numbers not contained in values are accepted.
+func ToEnum(value string, values map[string]int32) (int32, bool) {
+	if v, ok := values[value]; ok {
+		return v, true
+	}
+	return ToInt32(value)
+}
+
+// ToBoolValue tries to parse the given string to a google.protobuf.BoolValue.
+func ToBoolValue(value string) (*bool, bool) {
+	return toWrapper(value, ToBool)
+}
+
+// ToFloatValue tries to parse the given string to a google.protobuf.FloatValue.
+func ToFloatValue(value string) (*float32, bool) {
+	return toWrapper(value, ToFloat32)
+}
+
+// ToDoubleValue tries to parse the given string to a google.protobuf.DoubleValue.
+func ToDoubleValue(value string) (*float64, bool) {
+	return toWrapper(value, ToFloat64)
+}
+
+// ToInt32Value tries to parse the given string to a google.protobuf.Int32Value.
+func ToInt32Value(value string) (*int32, bool) {
+	return toWrapper(value, ToInt32)
+}
+
+// ToInt64Value tries to parse the given string to a google.protobuf.Int64Value.
+func ToInt64Value(value string) (*int64, bool) {
+	return toWrapper(value, ToInt64)
+}
+
+// ToUint32Value tries to parse the given string to a google.protobuf.UInt32Value.
+func ToUint32Value(value string) (*uint32, bool) {
+	return toWrapper(value, ToUint32)
+}
+
+// ToUint64Value tries to parse the given string to a google.protobuf.UInt64Value.
+func ToUint64Value(value string) (*uint64, bool) {
+	return toWrapper(value, ToUint64)
+}
+
+// ToStringValue returns the given string as google.protobuf.StringValue.
+// Unlike ToString an empty string is a valid value.
+func ToStringValue(value string) (*string, bool) {
+	return &value, true
+}
+
+// ToBytesValue tries to parse the given string to a google.protobuf.BytesValue.
+func ToBytesValue(value string) (*[]byte, bool) {
+	return toWrapper(value, ToBytes)
+}
+
+func toWrapper[T any](value string, convert func(string) (T, bool)) (*T, bool) {
+	v, ok := convert(value)
+	if !ok {
+		return nil, false
+	}
+	return &v, true
+}
@@ -3,7 +3,9 @@ package rest
 import (
 	"bytes"
 	"encoding/base64"
+	"reflect"
 	"testing"
+	"time"
 )
 
 func TestToBool(t *testing.T) {
@@ -580,6 +582,41 @@ func TestToBytes(t *testing.T) {
 			expected: []byte("test"),
 			ok:       true,
 		},
+		{
+			test:     "url unpadded, ok",
+			input:    base64.RawURLEncoding.EncodeToString([]byte{0xfb, 0xff}),
+			expected: []byte{0xfb, 0xff},
+			ok:       true,
+		},
+		{
+			test:     "std padded, ok",
+			input:    base64.StdEncoding.EncodeToString([]byte{0xfb, 0xff}),
+			expected: []byte{0xfb, 0xff},
+			ok:       true,
+		},
+		{
+			test:     "std unpadded, ok",
+			input:    base64.RawStdEncoding.EncodeToString([]byte{0xfb, 0xff}),
+			expected: []byte{0xfb, 0xff},
+			ok:       true,
+		},
+		{
+			test:     "unescaped plus, ok",
+			input:    "+/8",
+			expected: []byte{0xfb, 0xff},
+			ok:       true,
+		},
+		{
+			test:     "space as plus, ok",
+			input:    " /8",
+			expected: []byte{0xfb, 0xff},
+			ok:       true,
+		},
+		{
+			test:  "invalid, not ok",
+			input: "a",
+			ok:    false,
+		},
 	}
 
 	for _, tc := range testcases {
@@ -596,3 +633,442 @@ func TestToBytes(t *testing.T) {
 		})
 	}
 }
+
+func TestToInt64Slice(t *testing.T) {
+	testcases := []struct {
+		test     string
+		input    []string
+		sep      string
+		expected []int64
+		invalid  SliceError
+	}{
+		{
+			test:     "repeated, ok",
+			input:    []string{"1", "2"},
+			sep:      Exploded,
+			expected: []int64{1, 2},
+		},
+		{
+			test:     "comma, ok",
+			input:    []string{"1,2,3"},
+			sep:      FormDelimited,
+			expected: []int64{1, 2, 3},
+		},
+		{
+			test:     "repeated and pipe, ok",
+			input:    []string{"1|2", "3"},
+			sep:      PipeDelimited,
+			expected: []int64{1, 2, 3},
+		},
+		{
+			test:     "space, ok",
+			input:    []string{"-1 2"},
+			sep:      SpaceDelimited,
+			expected: []int64{-1, 2},
+		},
+		{
+			test:  "empty, ok",
+			input: []string{""},
+			sep:   FormDelimited,
+		},
+		{
+			test:    "not ok",
+			input:   []string{"1,a,,4"},
+			sep:     FormDelimited,
+			invalid: SliceError{{Index: 1, Value: "a"}, {Index: 2, Value: ""}},
+		},
+		{
+			test:    "delimiter mismatch, not ok",
+			input:   []string{"1,2"},
+			sep:     Exploded,
+			invalid: SliceError{{Index: 0, Value: "1,2"}},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.test, func(t *testing.T) {
+			actual, err := ToInt64Slice(tc.input, tc.sep)
+
+			if tc.invalid != nil {
+				serr, ok := err.(SliceError)
+				if !ok {
+					t.Fatalf("Got: %v - want: SliceError", err)
+				}
+				if !reflect.DeepEqual(serr, tc.invalid) {
+					t.Fatalf("Got: %v - want: %v", serr, tc.invalid)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Got: %v - want: no error", err)
+			}
+
+			if !reflect.DeepEqual(actual, tc.expected) {
+				t.Fatalf("Got: %v - want: %v", actual, tc.expected)
+			}
+		})
+	}
+}
+
+func TestToStringSlice(t *testing.T) {
+	actual, err := ToStringSlice([]string{"a,b", "c"}, FormDelimited)
+	if err != nil {
+		t.Fatalf("Got: %v - want: no error", err)
+	}
+
+	expected := []string{"a", "b", "c"}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("Got: %v - want: %v", actual, expected)
+	}
+
+	if _, err := ToStringSlice([]string{"a,,b"}, FormDelimited); err == nil {
+		t.Fatalf("Got: no error - want: error for empty element")
+	}
+}
+
+func TestInt32SliceParse(t *testing.T) {
+	p := Int32Slice{5}
+
+	if err := p.Parse([]string{"3|1"}, PipeDelimited); err != nil {
+		t.Fatalf("Got: %v - want: no error", err)
+	}
+	p.Sort()
+
+	expected := Int32Slice{1, 3, 5}
+	if !reflect.DeepEqual(p, expected) {
+		t.Fatalf("Got: %v - want: %v", p, expected)
+	}
+
+	if err := p.Parse([]string{"x"}, Exploded); err == nil {
+		t.Fatalf("Got: no error - want: error")
+	}
+}
+
+func TestToTimestamp(t *testing.T) {
+	testcases := []struct {
+		test     string
+		input    string
+		expected time.Time
+		ok       bool
+	}{
+		{
+			test:     "utc, ok",
+			input:    "2017-01-15T01:30:15.01Z",
+			expected: time.Date(2017, 1, 15, 1, 30, 15, 10000000, time.UTC),
+			ok:       true,
+		},
+		{
+			test:     "offset, ok",
+			input:    "2017-01-15T01:30:15+01:00",
+			expected: time.Date(2017, 1, 15, 0, 30, 15, 0, time.UTC),
+			ok:       true,
+		},
+		{
+			test:  "date only, not ok",
+			input: "2017-01-15",
+			ok:    false,
+		},
+		{
+			test:  "out of range, not ok",
+			input: "0000-12-31T23:59:59Z",
+			ok:    false,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.test, func(t *testing.T) {
+			actual, ok := ToTimestamp(tc.input)
+
+			if ok != tc.ok {
+				t.Fatalf("Got: %t - want: %t", ok, tc.ok)
+			}
+
+			if !actual.Equal(tc.expected) {
+				t.Fatalf("Got: %v - want: %v", actual, tc.expected)
+			}
+		})
+	}
+}
+
+func TestToDuration(t *testing.T) {
+	testcases := []struct {
+		test     string
+		input    string
+		expected time.Duration
+		ok       bool
+	}{
+		{
+			test:     "1.5s, ok",
+			input:    "1.5s",
+			expected: 1500 * time.Millisecond,
+			ok:       true,
+		},
+		{
+			test:     "-0.000000001s, ok",
+			input:    "-0.000000001s",
+			expected: -1,
+			ok:       true,
+		},
+		{
+			test:     "3s, ok",
+			input:    "3s",
+			expected: 3 * time.Second,
+			ok:       true,
+		},
+		{
+			test:  "no unit, not ok",
+			input: "3",
+			ok:    false,
+		},
+		{
+			test:  "go syntax, not ok",
+			input: "1m30s",
+			ok:    false,
+		},
+		{
+			test:  "too many digits, not ok",
+			input: "0.0000000001s",
+			ok:    false,
+		},
+		{
+			test:  "double sign, not ok",
+			input: "--1s",
+			ok:    false,
+		},
+		{
+			test:  "overflow, not ok",
+			input: "315576000000s",
+			ok:    false,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.test, func(t *testing.T) {
+			actual, ok := ToDuration(tc.input)
+
+			if ok != tc.ok {
+				t.Fatalf("Got: %t - want: %t", ok, tc.ok)
+			}
+
+			if actual != tc.expected {
+				t.Fatalf("Got: %v - want: %v", actual, tc.expected)
+			}
+		})
+	}
+}
+
+func TestToFieldMask(t *testing.T) {
+	testcases := []struct {
+		test     string
+		input    string
+		expected []string
+		ok       bool
+	}{
+		{
+			test:     "a.b,c, ok",
+			input:    "a.b,c",
+			expected: []string{"a.b", "c"},
+			ok:       true,
+		},
+		{
+			test:     "camel case, ok",
+			input:    "user.displayName,createTime",
+			expected: []string{"user.display_name", "create_time"},
+			ok:       true,
+		},
+		{
+			test:     "empty, ok",
+			input:    "",
+			expected: []string{},
+			ok:       true,
+		},
+		{
+			test:  "snake case, not ok",
+			input: "display_name",
+			ok:    false,
+		},
+		{
+			test:  "empty path, not ok",
+			input: "a,,b",
+			ok:    false,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.test, func(t *testing.T) {
+			actual, ok := ToFieldMask(tc.input)
+
+			if ok != tc.ok {
+				t.Fatalf("Got: %t - want: %t", ok, tc.ok)
+			}
+
+			if !reflect.DeepEqual(actual, tc.expected) {
+				t.Fatalf("Got: %v - want: %v", actual, tc.expected)
+			}
+		})
+	}
+}
+
+func TestToEnum(t *testing.T) {
+	values := map[string]int32{
+		"UNKNOWN": 0,
+		"ACTIVE":  1,
+	}
+
+	testcases := []struct {
+		test     string
+		input    string
+		expected int32
+		ok       bool
+	}{
+		{
+			test:     "name, ok",
+			input:    "ACTIVE",
+			expected: 1,
+			ok:       true,
+		},
+		{
+			test:     "number, ok",
+			input:    "1",
+			expected: 1,
+			ok:       true,
+		},
+		{
+			test:     "unknown number, ok",
+			input:    "7",
+			expected: 7,
+			ok:       true,
+		},
+		{
+			test:  "unknown name, not ok",
+			input: "active",
+			ok:    false,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.test, func(t *testing.T) {
+			actual, ok := ToEnum(tc.input, values)
+
+			if ok != tc.ok {
+				t.Fatalf("Got: %t - want: %t", ok, tc.ok)
+			}
+
+			if actual != tc.expected {
+				t.Fatalf("Got: %d - want: %d", actual, tc.expected)
+			}
+		})
+	}
+}
+
+func TestToWrappers(t *testing.T) {
+	if v, ok := ToInt64Value("42"); !ok || *v != 42 {
+		t.Fatalf("Got: %v, %t - want: 42, true", v, ok)
+	}
+
+	if v, ok := ToInt64Value("x"); ok || v != nil {
+		t.Fatalf("Got: %v, %t - want: nil, false", v, ok)
+	}
+
+	if v, ok := ToStringValue(""); !ok || *v != "" {
+		t.Fatalf("Got: %v, %t - want: empty string, true", v, ok)
+	}
+}
+
+func TestBytesEncoding(t *testing.T) {
+	testcases := []struct {
+		test     string
+		encoding BytesEncoding
+		input    string
+		expected []byte
+		ok       bool
+	}{
+		{
+			test:     "hex, ok",
+			encoding: BytesEncoding{Hex: true},
+			input:    "fbFF",
+			expected: []byte{0xfb, 0xff},
+			ok:       true,
+		},
+		{
+			test:     "hex, not ok",
+			encoding: BytesEncoding{Hex: true},
+			input:    "+/8",
+			ok:       false,
+		},
+		{
+			test:     "mixed alphabets, ok",
+			encoding: BytesEncoding{},
+			input:    "+_8",
+			expected: []byte{0xfb, 0xff},
+			ok:       true,
+		},
+		{
+			test:     "mixed alphabets with space and padding, ok",
+			encoding: BytesEncoding{},
+			input:    "-/ /-w==",
+			expected: []byte{0xfb, 0xff, 0xbf, 0xfb},
+			ok:       true,
+		},
+		{
+			test:     "strict, ok",
+			encoding: BytesEncoding{Strict: true},
+			input:    "-_8",
+			expected: []byte{0xfb, 0xff},
+			ok:       true,
+		},
+		{
+			test:     "strict space, not ok",
+			encoding: BytesEncoding{Strict: true},
+			input:    " /8",
+			ok:       false,
+		},
+		{
+			test:     "strict mixed alphabets, not ok",
+			encoding: BytesEncoding{Strict: true},
+			input:    "+_8",
+			ok:       false,
+		},
+		{
+			test:     "strict padding bits, not ok",
+			encoding: BytesEncoding{Strict: true},
+			input:    "-_9",
+			ok:       false,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.test, func(t *testing.T) {
+			actual, ok := tc.encoding.Decode(tc.input)
+
+			if ok != tc.ok {
+				t.Fatalf("Got: %t - want: %t", ok, tc.ok)
+			}
+
+			if !bytes.Equal(actual, tc.expected) {
+				t.Fatalf("Got: %v - want: %v", actual, tc.expected)
+			}
+		})
+	}
+}
+
+func TestFromBytesRoundTrip(t *testing.T) {
+	for _, enc := range []BytesEncoding{{}, {Strict: true}, {Hex: true}} {
+		for n := 0; n < 8; n++ {
+			input := make([]byte, n)
+			for i := range input {
+				input[i] = byte(0xf0 + i)
+			}
+
+			actual, ok := enc.Decode(enc.Encode(input))
+			if !ok || !bytes.Equal(actual, input) {
+				t.Fatalf("Got: %v, %t - want: %v, true", actual, ok, input)
+			}
+		}
+	}
+
+	if s := FromBytes([]byte{0xfb, 0xff}); s != "-_8" {
+		t.Fatalf("Got: %s - want: -_8", s)
+	}
+}
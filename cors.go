@@ -2,10 +2,12 @@
 package rest
 
 import (
+	"context"
 	"net/http"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -16,15 +18,20 @@ const (
 	headerAllowMethods     = "Access-Control-Allow-Methods"
 	headerExposeHeaders    = "Access-Control-Expose-Headers"
 	headerMaxAge           = "Access-Control-Max-Age"
+	headerAllowPrivateNet  = "Access-Control-Allow-Private-Network"
 
 	headerOrigin         = "Origin"
 	headerRequestMethod  = "Access-Control-Request-Method"
 	headerRequestHeaders = "Access-Control-Request-Headers"
+	headerRequestPrivNet = "Access-Control-Request-Private-Network"
 )
 
+// maxOriginCacheEntries bounds the number of cached AllowOriginFunc results.
+const maxOriginCacheEntries = 1024
+
 var (
 	defaultAllowHeaders = []string{"Origin", "Accept", "Content-Type", "Authorization"}
-	allowOriginPatterns = []string{}
+	safelistedMethods   = []string{http.MethodGet, http.MethodHead, http.MethodPost}
 )
 
 // CORSOptions represents the available CORS options
@@ -33,18 +40,55 @@ type CORSOptions struct {
 	AllowAllOrigins bool
 	// A list of allowed origins. Wild cards and FQDNs are supported.
 	AllowOrigins []string
+	// If set, it is called for origins not matching AllowOrigins to decide whether they are allowed.
+	AllowOriginFunc func(ctx context.Context, origin string) bool
+	// If set, the results of AllowOriginFunc are cached for the duration by the CORS handler.
+	AllowOriginCacheTTL time.Duration
 	// If set, allows to share auth credentials such as cookies.
 	AllowCredentials bool
 	// A list of allowed HTTP methods.
 	AllowMethods []string
 	// A list of allowed HTTP headers.
 	AllowHeaders []string
+	// If set, all headers requested in a preflight are allowed and reflected.
+	ReflectHeaders bool
+	// If set, preflights requesting access from a public to a private network are allowed.
+	AllowPrivateNetwork bool
 	// A list of exposed HTTP headers.
 	ExposeHeaders []string
 	// Max age of the CORS headers.
 	MaxAge time.Duration
 }
 
+// corsPolicy holds a copy of the options with the compiled AllowOrigins and
+// the cached results of AllowOriginFunc.
+type corsPolicy struct {
+	CORSOptions
+	originPatterns []*regexp.Regexp
+
+	originCacheMu sync.Mutex
+	originCache   map[string]originCacheEntry
+}
+
+// newCORSPolicy copies the options and compiles the AllowOrigins wild cards to regular expressions.
+func newCORSPolicy(opts *CORSOptions) *corsPolicy {
+	p := &corsPolicy{CORSOptions: *opts}
+	p.originPatterns = make([]*regexp.Regexp, 0, len(p.AllowOrigins))
+	for _, origin := range p.AllowOrigins {
+		pattern := regexp.QuoteMeta(origin)
+		pattern = strings.Replace(pattern, "\\*", ".*", -1)
+		pattern = strings.Replace(pattern, "\\?", ".", -1)
+		p.originPatterns = append(p.originPatterns, regexp.MustCompile("^"+pattern+"$"))
+	}
+	return p
+}
+
+// originCacheEntry is a cached result of AllowOriginFunc.
+type originCacheEntry struct {
+	allowed bool
+	expires time.Time
+}
+
 // DefaultCORSOptions creates new options, allows all origins and returns them.
 func DefaultCORSOptions() *CORSOptions {
 	return &CORSOptions{
@@ -53,19 +97,18 @@ func DefaultCORSOptions() *CORSOptions {
 }
 
 // Header converts options into CORS headers.
+// If credentials are allowed the origin is echoed instead of the wild card.
 func (o *CORSOptions) Header(origin string) (headers map[string]string) {
+	return newCORSPolicy(o).header(context.Background(), origin)
+}
+
+func (o *corsPolicy) header(ctx context.Context, origin string) (headers map[string]string) {
 	headers = make(map[string]string)
-	if !o.AllowAllOrigins && !o.IsOriginAllowed(origin) {
+	if !o.allowsOrigin(ctx, origin) {
 		return
 	}
 
-	if o.AllowAllOrigins {
-		headers[headerAllowOrigin] = "*"
-	} else {
-		headers[headerAllowOrigin] = origin
-	}
-
-	headers[headerAllowCredentials] = strconv.FormatBool(o.AllowCredentials)
+	o.setOrigin(headers, origin)
 
 	if len(o.AllowMethods) > 0 {
 		headers[headerAllowMethods] = strings.Join(o.AllowMethods, ",")
@@ -85,43 +128,65 @@ func (o *CORSOptions) Header(origin string) (headers map[string]string) {
 }
 
 // PreflightHeader converts options into CORS headers for a preflight response.
+// If the origin, the requested method or one of the requested headers is not
+// allowed no headers are returned. Without AllowMethods the CORS-safelisted
+// methods GET, HEAD and POST are allowed.
 func (o *CORSOptions) PreflightHeader(origin, rMethod, rHeaders string) (headers map[string]string) {
+	methods := o.AllowMethods
+	if len(methods) == 0 {
+		methods = safelistedMethods
+	}
+	return newCORSPolicy(o).preflightHeader(context.Background(), origin, rMethod, rHeaders, false, methods)
+}
+
+func (o *corsPolicy) preflightHeader(ctx context.Context, origin, rMethod, rHeaders string, privateNetwork bool, methods []string) (headers map[string]string) {
 	headers = make(map[string]string)
-	if !o.AllowAllOrigins && !o.IsOriginAllowed(origin) {
+	if !o.allowsOrigin(ctx, origin) {
 		return
 	}
+
+	if privateNetwork && !o.AllowPrivateNetwork {
+		return
+	}
+
 	// verify if requested method is allowed
-	for _, method := range o.AllowMethods {
+	methodAllowed := false
+	for _, method := range methods {
 		if method == rMethod {
-			headers[headerAllowMethods] = strings.Join(o.AllowMethods, ",")
+			methodAllowed = true
 			break
 		}
 	}
+	if !methodAllowed {
+		return
+	}
 
 	// verify if requested headers are allowed
 	var allowed []string
 	for _, rHeader := range strings.Split(rHeaders, ",") {
 		rHeader = strings.TrimSpace(rHeader)
-	lookupLoop:
-		for _, allowedHeader := range o.AllowHeaders {
-			if strings.EqualFold(rHeader, allowedHeader) {
-				allowed = append(allowed, rHeader)
-				break lookupLoop
-			}
+		if rHeader == "" {
+			continue
+		}
+		if !o.ReflectHeaders && !o.isHeaderAllowed(rHeader) {
+			return
 		}
+		allowed = append(allowed, rHeader)
 	}
 
-	headers[headerAllowCredentials] = strconv.FormatBool(o.AllowCredentials)
-	if o.AllowAllOrigins {
-		headers[headerAllowOrigin] = "*"
-	} else {
-		headers[headerAllowOrigin] = origin
+	headers = map[string]string{
+		headerAllowMethods: strings.Join(methods, ","),
 	}
+	o.setOrigin(headers, origin)
 
 	if len(allowed) > 0 {
 		headers[headerAllowHeaders] = strings.Join(allowed, ",")
 	}
 
+	if privateNetwork {
+		headers[headerAllowPrivateNet] = "true"
+	}
+
 	if len(o.ExposeHeaders) > 0 {
 		headers[headerExposeHeaders] = strings.Join(o.ExposeHeaders, ",")
 	}
@@ -131,31 +196,101 @@ func (o *CORSOptions) PreflightHeader(origin, rMethod, rHeaders string) (headers
 	return
 }
 
+// allowsOrigin reports whether CORS headers are sent for the origin. Without
+// Origin header only the wild card is sent, as there is no origin to echo.
+func (o *corsPolicy) allowsOrigin(ctx context.Context, origin string) bool {
+	if origin == "" {
+		return o.AllowAllOrigins && !o.AllowCredentials
+	}
+	return o.AllowAllOrigins || o.isOriginAllowed(ctx, origin)
+}
+
+// setOrigin sets the Allow-Origin and Allow-Credentials headers. The wild card
+// must not be combined with credentials, so the origin is echoed in that case.
+func (o *corsPolicy) setOrigin(headers map[string]string, origin string) {
+	if o.AllowAllOrigins && !o.AllowCredentials {
+		headers[headerAllowOrigin] = "*"
+	} else {
+		headers[headerAllowOrigin] = origin
+	}
+
+	if o.AllowCredentials {
+		headers[headerAllowCredentials] = "true"
+	}
+}
+
+// varyOrigin reports whether the headers depend on the Origin of the request.
+func (o *corsPolicy) varyOrigin() bool {
+	return !o.AllowAllOrigins || o.AllowCredentials
+}
+
+func (o *corsPolicy) isHeaderAllowed(header string) bool {
+	for _, allowedHeader := range o.AllowHeaders {
+		if strings.EqualFold(header, allowedHeader) {
+			return true
+		}
+	}
+	return false
+}
+
 // IsOriginAllowed looks up if the origin matches one of the patterns
 // generated from Options.AllowOrigins patterns.
-func (o *CORSOptions) IsOriginAllowed(origin string) (allowed bool) {
-	for _, pattern := range allowOriginPatterns {
-		allowed, _ = regexp.MatchString(pattern, origin)
-		if allowed {
-			return
+// The patterns are compiled on every call, the handlers created by NewCORS
+// compile them once. If the origin does not match, AllowOriginFunc is consulted.
+func (o *CORSOptions) IsOriginAllowed(origin string) bool {
+	return newCORSPolicy(o).isOriginAllowed(context.Background(), origin)
+}
+
+func (o *corsPolicy) isOriginAllowed(ctx context.Context, origin string) bool {
+	for _, pattern := range o.originPatterns {
+		if pattern.MatchString(origin) {
+			return true
 		}
 	}
-	return
+
+	if o.AllowOriginFunc == nil || origin == "" {
+		return false
+	}
+
+	if o.AllowOriginCacheTTL <= 0 {
+		return o.AllowOriginFunc(ctx, origin)
+	}
+
+	now := time.Now()
+	o.originCacheMu.Lock()
+	e, ok := o.originCache[origin]
+	o.originCacheMu.Unlock()
+	if ok && now.Before(e.expires) {
+		return e.allowed
+	}
+
+	allowed := o.AllowOriginFunc(ctx, origin)
+
+	o.originCacheMu.Lock()
+	if o.originCache == nil || len(o.originCache) >= maxOriginCacheEntries {
+		o.originCache = make(map[string]originCacheEntry)
+	}
+	o.originCache[origin] = originCacheEntry{allowed: allowed, expires: now.Add(o.AllowOriginCacheTTL)}
+	o.originCacheMu.Unlock()
+
+	return allowed
 }
 
-// NewCORS enables CORS for requests those match the provided options.
+// NewCORS enables CORS for requests those match the provided options. The
+// options are copied, later changes are ignored.
 func NewCORS(opts *CORSOptions) http.HandlerFunc {
+	return newCORS(opts, nil)
+}
+
+// newCORS creates the CORS handler of a Service. If AllowMethods is empty the
+// methods allowed in a preflight are looked up by routeMethods for the
+// requested path.
+func newCORS(o *CORSOptions, routeMethods func(path string) []string) http.HandlerFunc {
+	opts := newCORSPolicy(o)
 	if len(opts.AllowHeaders) == 0 {
 		opts.AllowHeaders = defaultAllowHeaders
 	}
 
-	for _, origin := range opts.AllowOrigins {
-		pattern := regexp.QuoteMeta(origin)
-		pattern = strings.Replace(pattern, "\\*", ".*", -1)
-		pattern = strings.Replace(pattern, "\\?", ".", -1)
-		allowOriginPatterns = append(allowOriginPatterns, "^"+pattern+"$")
-	}
-
 	return func(w http.ResponseWriter, r *http.Request) {
 		var (
 			origin           = r.Header.Get(headerOrigin)
@@ -164,17 +299,37 @@ func NewCORS(opts *CORSOptions) http.HandlerFunc {
 			headers          map[string]string
 		)
 
-		if r.Method == http.MethodOptions &&
-			(requestedMethod != "" || requestedHeaders != "") {
-			// TODO: if preflight, respond with exact headers if allowed
-			headers = opts.PreflightHeader(origin, requestedMethod, requestedHeaders)
+		if r.Method == http.MethodOptions && requestedMethod != "" {
+			addVary(w.Header(), headerOrigin, headerRequestMethod, headerRequestHeaders)
+			if opts.AllowPrivateNetwork {
+				addVary(w.Header(), headerRequestPrivNet)
+			}
+
+			methods := opts.AllowMethods
+			if len(methods) == 0 && routeMethods != nil {
+				methods = routeMethods(r.URL.Path)
+			} else if len(methods) == 0 {
+				methods = safelistedMethods
+			}
+
+			privateNetwork := strings.EqualFold(r.Header.Get(headerRequestPrivNet), "true")
+			headers = opts.preflightHeader(r.Context(), origin, requestedMethod, requestedHeaders, privateNetwork, methods)
+			if len(headers) == 0 {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+
 			for key, value := range headers {
 				w.Header().Set(key, value)
 			}
-			w.WriteHeader(http.StatusOK)
+			w.WriteHeader(http.StatusNoContent)
 			return
 		}
-		headers = opts.Header(origin)
+
+		if opts.varyOrigin() {
+			addVary(w.Header(), headerOrigin)
+		}
+		headers = opts.header(r.Context(), origin)
 
 		for key, value := range headers {
 			w.Header().Set(key, value)
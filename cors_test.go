@@ -2,6 +2,7 @@
 package rest
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -103,6 +104,7 @@ func TestHeaders(t *testing.T) {
 	s := New(Configuration{CORS: true, CORSOptions: opt}, nil)
 
 	r, _ := http.NewRequest(http.MethodPut, "foo", nil)
+	r.Header.Set(headerOrigin, "https://abc.org")
 	s.ServeHTTP(recorder, r)
 
 	credentialsVal := recorder.Result().Header.Get(headerAllowCredentials)
@@ -189,7 +191,437 @@ func TestPreflight(t *testing.T) {
 		t.Errorf("Allow-Origin is expected to be *, found %v", originVal)
 	}
 
-	if recorder.Code != http.StatusOK {
-		t.Errorf("Status code is expected to be 200, found %d", recorder.Code)
+	if recorder.Code != http.StatusNoContent {
+		t.Errorf("Status code is expected to be 204, found %d", recorder.Code)
+	}
+}
+
+func TestOriginsPerService(t *testing.T) {
+	a := New(Configuration{CORS: true, CORSOptions: &CORSOptions{
+		AllowOrigins: []string{"https://a.org"},
+	}}, nil)
+	b := New(Configuration{CORS: true, CORSOptions: &CORSOptions{
+		AllowOrigins: []string{"https://b.org"},
+	}}, nil)
+
+	for _, tc := range []struct {
+		service *Service
+		origin  string
+		allowed bool
+	}{
+		{a, "https://a.org", true},
+		{a, "https://b.org", false},
+		{b, "https://b.org", true},
+		{b, "https://a.org", false},
+	} {
+		recorder := httptest.NewRecorder()
+		r, _ := http.NewRequest(http.MethodGet, "foo", nil)
+		r.Header.Add(headerOrigin, tc.origin)
+		tc.service.ServeHTTP(recorder, r)
+
+		headerValue := recorder.Result().Header.Get(headerAllowOrigin)
+		if allowed := headerValue == tc.origin; allowed != tc.allowed {
+			t.Errorf("Origin %v allowed should be %t, found Allow-Origin %v", tc.origin, tc.allowed, headerValue)
+		}
+	}
+}
+
+func TestIsOriginAllowedWithoutNewCORS(t *testing.T) {
+	opt := &CORSOptions{AllowOrigins: []string{"https://?.cs.com"}}
+
+	if !opt.IsOriginAllowed("https://a.cs.com") {
+		t.Errorf("Origin https://a.cs.com should be allowed")
+	}
+
+	if opt.IsOriginAllowed("https://ab.cs.com") {
+		t.Errorf("Origin https://ab.cs.com should not be allowed")
+	}
+}
+
+func TestCORSOptionsCopied(t *testing.T) {
+	opt := &CORSOptions{AllowOrigins: []string{"https://abc.org"}}
+	s := New(Configuration{CORS: true, CORSOptions: opt}, nil)
+
+	// options are plain values, copies and later changes don't affect the service
+	copied := *opt
+	copied.AllowOrigins = []string{"https://other.org"}
+	opt.AllowOrigins = []string{"https://other.org"}
+
+	recorder := httptest.NewRecorder()
+	r, _ := http.NewRequest(http.MethodGet, "foo", nil)
+	r.Header.Set(headerOrigin, "https://abc.org")
+	s.ServeHTTP(recorder, r)
+
+	if originVal := recorder.Result().Header.Get(headerAllowOrigin); originVal != "https://abc.org" {
+		t.Errorf("Allow-Origin is expected to be https://abc.org, found %v", originVal)
+	}
+	if !copied.IsOriginAllowed("https://other.org") || copied.IsOriginAllowed("https://abc.org") {
+		t.Errorf("Copied options are expected to use their own origins")
+	}
+}
+
+func TestCredentialsEchoOrigin(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	opt := &CORSOptions{
+		AllowAllOrigins:  true,
+		AllowCredentials: true,
+	}
+
+	s := New(Configuration{CORS: true, CORSOptions: opt}, nil)
+
+	origin := "https://abc.org"
+	r, _ := http.NewRequest(http.MethodGet, "foo", nil)
+	r.Header.Add(headerOrigin, origin)
+	s.ServeHTTP(recorder, r)
+
+	headers := recorder.Result().Header
+	if originVal := headers.Get(headerAllowOrigin); originVal != origin {
+		t.Errorf("Allow-Origin is expected to be %v, found %v", origin, originVal)
+	}
+
+	if varyVal := headers.Get(headerVary); varyVal != headerOrigin {
+		t.Errorf("Vary is expected to be Origin, found %v", varyVal)
+	}
+}
+
+func TestCredentialsWithoutOrigin(t *testing.T) {
+	testcases := []struct {
+		test string
+		opt  *CORSOptions
+	}{
+		{"all origins", &CORSOptions{AllowAllOrigins: true, AllowCredentials: true}},
+		{"wild card pattern", &CORSOptions{AllowOrigins: []string{"*"}, AllowCredentials: true}},
+	}
+
+	for _, tc := range testcases {
+		recorder := httptest.NewRecorder()
+		s := New(Configuration{CORS: true, CORSOptions: tc.opt}, nil)
+		r, _ := http.NewRequest(http.MethodGet, "foo", nil)
+		s.ServeHTTP(recorder, r)
+
+		headers := recorder.Result().Header
+		if _, ok := headers[headerAllowOrigin]; ok {
+			t.Errorf("%s: Allow-Origin should not exist without Origin, found %q", tc.test, headers.Get(headerAllowOrigin))
+		}
+		if _, ok := headers[headerAllowCredentials]; ok {
+			t.Errorf("%s: Allow-Credentials should not exist without Origin", tc.test)
+		}
+	}
+}
+
+func TestNoCredentialsHeader(t *testing.T) {
+	recorder := httptest.NewRecorder()
+
+	s := New(Configuration{CORS: true}, nil)
+	r, _ := http.NewRequest(http.MethodGet, "foo", nil)
+	s.ServeHTTP(recorder, r)
+
+	headers := recorder.Result().Header
+	if _, ok := headers[headerAllowCredentials]; ok {
+		t.Errorf("Allow-Credentials should not exist, found %v", headers.Get(headerAllowCredentials))
+	}
+
+	if varyVal := headers.Get(headerVary); varyVal != "" {
+		t.Errorf("Vary should not exist for wild card origins, found %v", varyVal)
+	}
+}
+
+func TestPreflightRejected(t *testing.T) {
+	opt := &CORSOptions{
+		AllowOrigins: []string{"https://abc.org"},
+		AllowMethods: []string{http.MethodGet},
+		AllowHeaders: []string{"X-Allowed"},
+	}
+
+	s := New(Configuration{CORS: true, CORSOptions: opt}, nil)
+
+	testcases := []struct {
+		test    string
+		origin  string
+		method  string
+		headers string
+	}{
+		{"origin", "https://evil.org", http.MethodGet, ""},
+		{"method", "https://abc.org", http.MethodDelete, ""},
+		{"headers", "https://abc.org", http.MethodGet, "X-Allowed, X-Forbidden"},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.test, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			r, _ := http.NewRequest(http.MethodOptions, "foo", nil)
+			r.Header.Add(headerOrigin, tc.origin)
+			r.Header.Add(headerRequestMethod, tc.method)
+			if tc.headers != "" {
+				r.Header.Add(headerRequestHeaders, tc.headers)
+			}
+			s.ServeHTTP(recorder, r)
+
+			headers := recorder.Result().Header
+			for _, h := range []string{headerAllowOrigin, headerAllowMethods, headerAllowHeaders, headerAllowCredentials} {
+				if v := headers.Get(h); v != "" {
+					t.Errorf("%v should not exist, found %v", h, v)
+				}
+			}
+
+			if varyVal := strings.Join(headers[headerVary], ","); varyVal != "Origin,Access-Control-Request-Method,Access-Control-Request-Headers" {
+				t.Errorf("Vary is expected to list the preflight request headers, found %v", varyVal)
+			}
+
+			if recorder.Code != http.StatusForbidden {
+				t.Errorf("Status code is expected to be 403, found %d", recorder.Code)
+			}
+		})
+	}
+}
+
+func TestPreflightRouteMethods(t *testing.T) {
+	s := New(Configuration{CORS: true}, nil)
+	s.Get("/items/:id", http.HandlerFunc(AHandler))
+	s.Delete("/items/:id", http.HandlerFunc(AHandler))
+	s.Post("/items", http.HandlerFunc(AHandler))
+
+	testcases := []struct {
+		test    string
+		path    string
+		method  string
+		status  int
+		methods string
+	}{
+		{"registered", "/items/1", http.MethodDelete, http.StatusNoContent, "DELETE,GET"},
+		{"not registered", "/items/1", http.MethodPost, http.StatusForbidden, ""},
+		{"unknown path", "/unknown", http.MethodGet, http.StatusForbidden, ""},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.test, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			r, _ := http.NewRequest(http.MethodOptions, tc.path, nil)
+			r.Header.Add(headerOrigin, "https://abc.org")
+			r.Header.Add(headerRequestMethod, tc.method)
+			s.ServeHTTP(recorder, r)
+
+			if recorder.Code != tc.status {
+				t.Errorf("Status code is expected to be %d, found %d", tc.status, recorder.Code)
+			}
+
+			if methodsVal := recorder.Result().Header.Get(headerAllowMethods); methodsVal != tc.methods {
+				t.Errorf("Allow-Methods is expected to be %v, found %v", tc.methods, methodsVal)
+			}
+		})
+	}
+}
+
+func TestRouteAndGroupPolicies(t *testing.T) {
+	public := &CORSOptions{AllowAllOrigins: true}
+	console := &CORSOptions{
+		AllowOrigins:     []string{"https://console.abc.org"},
+		AllowCredentials: true,
+	}
+
+	admin := &Group{
+		BaseURI: "/admin",
+		CORS:    console,
+		Registers: []Register{
+			{Method: http.MethodGet, Path: "/users", Handler: AHandler},
+			{Method: http.MethodDelete, Path: "/users/:id", Handler: AHandler},
+			{Method: http.MethodGet, Path: "/status", Handler: AHandler, CORS: public},
+		},
+	}
+
+	s := New(Configuration{}, []HandlerRegistration{admin})
+	s.Register([]Register{
+		{Method: http.MethodGet, Path: "/items", Handler: AHandler, CORS: public},
+		{Method: http.MethodGet, Path: "/private", Handler: AHandler},
+	}, "")
+
+	testcases := []struct {
+		test          string
+		method        string
+		requestMethod string
+		path          string
+		origin        string
+		allowOrigin   string
+	}{
+		{"public route", http.MethodGet, "", "/items", "https://evil.org", "*"},
+		{"route without policy", http.MethodGet, "", "/private", "https://evil.org", ""},
+		{"group route, allowed", http.MethodGet, "", "/admin/users", "https://console.abc.org", "https://console.abc.org"},
+		{"group route, not allowed", http.MethodGet, "", "/admin/users", "https://evil.org", ""},
+		{"route overrides group", http.MethodGet, "", "/admin/status", "https://evil.org", "*"},
+		{"group preflight, allowed", http.MethodOptions, http.MethodDelete, "/admin/users/1", "https://console.abc.org", "https://console.abc.org"},
+		{"group preflight, not allowed", http.MethodOptions, http.MethodDelete, "/admin/users/1", "https://evil.org", ""},
+		{"public preflight", http.MethodOptions, http.MethodGet, "/items", "https://evil.org", "*"},
+		{"unknown path", http.MethodGet, "", "/unknown", "https://evil.org", ""},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.test, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			r, _ := http.NewRequest(tc.method, tc.path, nil)
+			r.Header.Add(headerOrigin, tc.origin)
+			if tc.requestMethod != "" {
+				r.Header.Add(headerRequestMethod, tc.requestMethod)
+			}
+			s.ServeHTTP(recorder, r)
+
+			if originVal := recorder.Result().Header.Get(headerAllowOrigin); originVal != tc.allowOrigin {
+				t.Errorf("Allow-Origin is expected to be %v, found %v", tc.allowOrigin, originVal)
+			}
+		})
+	}
+}
+
+func TestAllowOriginFunc(t *testing.T) {
+	tenants := map[string]bool{"https://tenant.org": true}
+	calls := 0
+
+	opt := &CORSOptions{
+		AllowOrigins: []string{"https://abc.org"},
+		AllowOriginFunc: func(ctx context.Context, origin string) bool {
+			calls++
+			return tenants[origin]
+		},
+		AllowOriginCacheTTL: time.Minute,
+	}
+
+	s := New(Configuration{CORS: true, CORSOptions: opt}, nil)
+
+	testcases := []struct {
+		test        string
+		origin      string
+		allowOrigin string
+		calls       int
+	}{
+		{"static origin", "https://abc.org", "https://abc.org", 0},
+		{"dynamic origin", "https://tenant.org", "https://tenant.org", 1},
+		{"dynamic origin cached", "https://tenant.org", "https://tenant.org", 1},
+		{"unknown origin", "https://evil.org", "", 2},
+		{"unknown origin cached", "https://evil.org", "", 2},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.test, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			r, _ := http.NewRequest(http.MethodGet, "foo", nil)
+			r.Header.Add(headerOrigin, tc.origin)
+			s.ServeHTTP(recorder, r)
+
+			if originVal := recorder.Result().Header.Get(headerAllowOrigin); originVal != tc.allowOrigin {
+				t.Errorf("Allow-Origin is expected to be %v, found %v", tc.allowOrigin, originVal)
+			}
+
+			if calls != tc.calls {
+				t.Errorf("AllowOriginFunc is expected to be called %d times, found %d", tc.calls, calls)
+			}
+		})
+	}
+}
+
+func TestPreflightPrivateNetworkAndReflection(t *testing.T) {
+	testcases := []struct {
+		test           string
+		opt            *CORSOptions
+		privateNetwork bool
+		headers        string
+		status         int
+		allowPrivate   string
+		allowHeaders   string
+	}{
+		{
+			test:           "private network allowed",
+			opt:            &CORSOptions{AllowAllOrigins: true, AllowPrivateNetwork: true},
+			privateNetwork: true,
+			status:         http.StatusNoContent,
+			allowPrivate:   "true",
+		},
+		{
+			test:           "private network not allowed",
+			opt:            &CORSOptions{AllowAllOrigins: true},
+			privateNetwork: true,
+			status:         http.StatusForbidden,
+		},
+		{
+			test:         "private network not requested",
+			opt:          &CORSOptions{AllowAllOrigins: true, AllowPrivateNetwork: true},
+			status:       http.StatusNoContent,
+			allowPrivate: "",
+		},
+		{
+			test:         "headers reflected",
+			opt:          &CORSOptions{AllowAllOrigins: true, ReflectHeaders: true},
+			headers:      "X-Custom, X-Trace-Id",
+			status:       http.StatusNoContent,
+			allowHeaders: "X-Custom,X-Trace-Id",
+		},
+		{
+			test:    "headers not reflected",
+			opt:     &CORSOptions{AllowAllOrigins: true},
+			headers: "X-Custom",
+			status:  http.StatusForbidden,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.test, func(t *testing.T) {
+			s := New(Configuration{CORS: true, CORSOptions: tc.opt}, nil)
+			s.Get("/foo", http.HandlerFunc(AHandler))
+
+			recorder := httptest.NewRecorder()
+			r, _ := http.NewRequest(http.MethodOptions, "/foo", nil)
+			r.Header.Add(headerOrigin, "https://abc.org")
+			r.Header.Add(headerRequestMethod, http.MethodGet)
+			if tc.privateNetwork {
+				r.Header.Add(headerRequestPrivNet, "true")
+			}
+			if tc.headers != "" {
+				r.Header.Add(headerRequestHeaders, tc.headers)
+			}
+			s.ServeHTTP(recorder, r)
+
+			headers := recorder.Result().Header
+			if recorder.Code != tc.status {
+				t.Errorf("Status code is expected to be %d, found %d", tc.status, recorder.Code)
+			}
+
+			if v := headers.Get(headerAllowPrivateNet); v != tc.allowPrivate {
+				t.Errorf("Allow-Private-Network is expected to be %v, found %v", tc.allowPrivate, v)
+			}
+
+			if v := headers.Get(headerAllowHeaders); v != tc.allowHeaders {
+				t.Errorf("Allow-Headers is expected to be %v, found %v", tc.allowHeaders, v)
+			}
+		})
+	}
+}
+
+func TestUnmatchedPathGetsServicePolicy(t *testing.T) {
+	admin := &Group{
+		BaseURI:   "/admin",
+		CORS:      &CORSOptions{AllowOrigins: []string{"https://console.abc.org"}},
+		Registers: []Register{{Method: http.MethodGet, Path: "/users", Handler: AHandler}},
+	}
+	s := New(Configuration{CORS: true, CORSOptions: &CORSOptions{AllowOrigins: []string{"https://abc.org"}}}, []HandlerRegistration{admin})
+
+	testcases := []struct {
+		path        string
+		origin      string
+		allowOrigin string
+	}{
+		{"/nope", "https://abc.org", "https://abc.org"},
+		{"/nope", "https://console.abc.org", ""},
+		{"/admin/nope", "https://console.abc.org", ""},
+		{"/admin/users", "https://console.abc.org", "https://console.abc.org"},
+		{"/admin/users", "https://abc.org", ""},
+	}
+
+	for _, tc := range testcases {
+		recorder := httptest.NewRecorder()
+		r, _ := http.NewRequest(http.MethodGet, tc.path, nil)
+		r.Header.Add(headerOrigin, tc.origin)
+		s.ServeHTTP(recorder, r)
+
+		if originVal := recorder.Result().Header.Get(headerAllowOrigin); originVal != tc.allowOrigin {
+			t.Errorf("%s from %s: Allow-Origin is expected to be %v, found %v", tc.path, tc.origin, tc.allowOrigin, originVal)
+		}
 	}
 }
@@ -0,0 +1,325 @@
+package rest
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Errors reported with status 403 by the CSRF protection.
+var (
+	ErrCSRFOrigin       = errors.New("cross-site request from untrusted origin")
+	ErrInvalidCSRFToken = errors.New("missing or invalid CSRF token")
+)
+
+// csrfKey defines the context key of the CSRF token of a request
+type csrfKey struct{}
+
+// csrfValue is the CSRF token of a request and the form field it is sent in.
+type csrfValue struct {
+	token string
+	field string
+}
+
+// CSRFMode selects how CSRF tokens are validated.
+type CSRFMode int
+
+// Modes of the CSRF protection.
+const (
+	// CSRFDoubleSubmit compares the token sent in a header or form field with a cookie.
+	CSRFDoubleSubmit CSRFMode = iota
+	// CSRFSynchronizer compares the token sent in a header or form field with the one stored for the session.
+	CSRFSynchronizer
+)
+
+// CSRFTokenStore keeps the tokens of the sessions in synchronizer mode.
+// Implementations must be safe for concurrent use.
+type CSRFTokenStore interface {
+	// Token returns the token of the session, empty if it has none.
+	Token(ctx context.Context, session string) (string, error)
+	SetToken(ctx context.Context, session, token string) error
+}
+
+// CSRFOptions represents the available CSRF options
+type CSRFOptions struct {
+	Mode CSRFMode
+	// Secret, if set, signs the tokens of the double submit mode. With SessionID
+	// the signature binds the token to the session, so tokens planted by a
+	// subdomain are rejected. Without a session it only proves the token was
+	// issued by the service, which an attacker can obtain as well.
+	Secret []byte
+	// Origins are trusted besides the origin of the service itself. Usually the CORSOptions of the Configuration.
+	Origins *CORSOptions
+	// Exempt lists route names or patterns which are not protected, e.g. webhooks.
+	Exempt []string
+
+	// CookieName is the cookie of the double submit mode. If empty csrf_token is used.
+	CookieName   string
+	CookiePath   string
+	CookieDomain string
+	// CookieSecure sets the Secure attribute of the cookie.
+	CookieSecure bool
+	// CookieSameSite is the SameSite attribute of the cookie. If 0 SameSiteLaxMode is used.
+	CookieSameSite http.SameSite
+
+	// HeaderName carries the token of a request. If empty X-CSRF-Token is used.
+	HeaderName string
+	// FormField carries the token of form submissions. If empty csrf_token is used.
+	FormField string
+
+	// SessionID returns the session of a request, empty if there is none. It is
+	// required in synchronizer mode and binds signed tokens in double submit mode.
+	SessionID func(r *http.Request) string
+	// Store keeps the tokens in synchronizer mode. If nil an in-memory store is used.
+	Store CSRFTokenStore
+}
+
+// NewCSRF creates a middleware protecting against cross-site request forgery.
+// Requests with unsafe methods must come from the origin of the service or
+// an origin allowed by Origins, checked by the Origin or Referer header, and
+// carry the token of the client in the header or form field. The token is
+// available for templates and SPAs by CSRFToken and CSRFTemplateField; in
+// double submit mode it is also readable from the cookie. Rejected requests
+// are answered with status 403 through the ErrorHandler.
+func NewCSRF(opts *CSRFOptions) Middleware {
+	c := &csrf{}
+	if opts != nil {
+		c.opts = *opts
+	}
+	if c.opts.CookieName == "" {
+		c.opts.CookieName = "csrf_token"
+	}
+	if c.opts.CookiePath == "" {
+		c.opts.CookiePath = "/"
+	}
+	if c.opts.CookieSameSite == 0 {
+		c.opts.CookieSameSite = http.SameSiteLaxMode
+	}
+	if c.opts.HeaderName == "" {
+		c.opts.HeaderName = "X-CSRF-Token"
+	}
+	if c.opts.FormField == "" {
+		c.opts.FormField = "csrf_token"
+	}
+	if c.opts.Mode == CSRFSynchronizer && c.opts.Store == nil {
+		c.opts.Store = NewMemoryCSRFTokenStore()
+	}
+	if c.opts.Origins != nil {
+		c.origins = newCORSPolicy(c.opts.Origins)
+	}
+	return c.middleware
+}
+
+type csrf struct {
+	opts    CSRFOptions
+	origins *corsPolicy
+}
+
+func (c *csrf) middleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if c.exempt(r) {
+			next(w, r)
+			return
+		}
+
+		token, err := c.token(w, r)
+		if err != nil {
+			HandleError(w, r, err)
+			return
+		}
+		r = r.WithContext(context.WithValue(r.Context(), csrfKey{}, csrfValue{token: token, field: c.opts.FormField}))
+
+		if isSafeMethod(r.Method) {
+			next(w, r)
+			return
+		}
+
+		if !c.trustedOrigin(r) {
+			HandleError(w, r, NewStatusError(http.StatusForbidden, ErrCSRFOrigin))
+			return
+		}
+
+		sent := r.Header.Get(c.opts.HeaderName)
+		if sent == "" {
+			sent = r.PostFormValue(c.opts.FormField)
+		}
+		if token == "" || subtle.ConstantTimeCompare([]byte(sent), []byte(token)) != 1 {
+			HandleError(w, r, NewStatusError(http.StatusForbidden, ErrInvalidCSRFToken))
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+func (c *csrf) exempt(r *http.Request) bool {
+	rt, ok := r.Context().Value(routeKey{}).(*route)
+	if !ok {
+		return false
+	}
+	for _, e := range c.opts.Exempt {
+		if e == rt.pattern || (rt.name != "" && e == rt.name) {
+			return true
+		}
+	}
+	return false
+}
+
+// token returns the token of the client and issues a new one if it has none.
+func (c *csrf) token(w http.ResponseWriter, r *http.Request) (string, error) {
+	session := ""
+	if c.opts.SessionID != nil {
+		session = c.opts.SessionID(r)
+	}
+
+	if c.opts.Mode == CSRFSynchronizer {
+		if session == "" {
+			return "", nil
+		}
+
+		token, err := c.opts.Store.Token(r.Context(), session)
+		if err != nil || token != "" {
+			return token, err
+		}
+		token = c.newToken(session)
+		return token, c.opts.Store.SetToken(r.Context(), session, token)
+	}
+
+	if cookie, err := r.Cookie(c.opts.CookieName); err == nil && c.validToken(session, cookie.Value) {
+		return cookie.Value, nil
+	}
+
+	token := c.newToken(session)
+	http.SetCookie(w, &http.Cookie{
+		Name:     c.opts.CookieName,
+		Value:    token,
+		Path:     c.opts.CookiePath,
+		Domain:   c.opts.CookieDomain,
+		Secure:   c.opts.CookieSecure,
+		SameSite: c.opts.CookieSameSite,
+	})
+	// the cookie is issued on safe requests, the token must match the new one
+	if !isSafeMethod(r.Method) {
+		return "", nil
+	}
+	return token, nil
+}
+
+// newToken creates a random token, signed with the session if a Secret is configured.
+func (c *csrf) newToken(session string) string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	if c.opts.Mode == CSRFDoubleSubmit && len(c.opts.Secret) > 0 {
+		token += "." + c.sign(session, token)
+	}
+	return token
+}
+
+// sign signs the nonce bound to the session, as nonces don't contain "!" the message is unambiguous.
+func (c *csrf) sign(session, nonce string) string {
+	mac := hmac.New(sha256.New, c.opts.Secret)
+	mac.Write([]byte(session + "!" + nonce))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (c *csrf) validToken(session, token string) bool {
+	if token == "" {
+		return false
+	}
+	if c.opts.Mode != CSRFDoubleSubmit || len(c.opts.Secret) == 0 {
+		return true
+	}
+	nonce, sig, ok := strings.Cut(token, ".")
+	return ok && hmac.Equal([]byte(sig), []byte(c.sign(session, nonce)))
+}
+
+// trustedOrigin checks the Origin header, or the Referer if it is missing.
+// Requests carrying neither are left to the token check.
+func (c *csrf) trustedOrigin(r *http.Request) bool {
+	origin := r.Header.Get(headerOrigin)
+	if origin == "" {
+		referer := r.Header.Get("Referer")
+		if referer == "" {
+			return true
+		}
+		u, err := url.Parse(referer)
+		if err != nil || u.Host == "" {
+			return false
+		}
+		origin = u.Scheme + "://" + u.Host
+	}
+
+	if u, err := url.Parse(origin); err == nil && u.Host != "" && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	return c.origins != nil && c.origins.isOriginAllowed(r.Context(), origin)
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+// CSRFToken returns the CSRF token to send with unsafe requests, empty outside of the CSRF middleware.
+func CSRFToken(ctx context.Context) string {
+	v, _ := ctx.Value(csrfKey{}).(csrfValue)
+	return v.token
+}
+
+// CSRFTemplateField returns a hidden input carrying the CSRF token in the form field for HTML templates.
+func CSRFTemplateField(ctx context.Context) template.HTML {
+	v, ok := ctx.Value(csrfKey{}).(csrfValue)
+	if !ok {
+		return ""
+	}
+	return template.HTML(`<input type="hidden" name="` + template.HTMLEscapeString(v.field) +
+		`" value="` + template.HTMLEscapeString(v.token) + `">`)
+}
+
+// MemoryCSRFTokenStore is an in-memory CSRFTokenStore.
+type MemoryCSRFTokenStore struct {
+	mu     sync.RWMutex
+	tokens map[string]string
+}
+
+// NewMemoryCSRFTokenStore creates a new MemoryCSRFTokenStore.
+func NewMemoryCSRFTokenStore() *MemoryCSRFTokenStore {
+	return &MemoryCSRFTokenStore{tokens: map[string]string{}}
+}
+
+// Token returns the token of the session.
+func (s *MemoryCSRFTokenStore) Token(ctx context.Context, session string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tokens[session], nil
+}
+
+// SetToken stores the token of the session.
+func (s *MemoryCSRFTokenStore) SetToken(ctx context.Context, session, token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens[session] = token
+	return nil
+}
+
+// DeleteToken removes the token of the session, e.g. on logout.
+func (s *MemoryCSRFTokenStore) DeleteToken(session string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.tokens, session)
+}
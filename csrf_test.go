@@ -0,0 +1,260 @@
+package rest
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func newCSRFService(opts *CSRFOptions, reported *error) *Service {
+	s := New(Configuration{
+		Chain: []Middleware{NewCSRF(opts)},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			*reported = err
+			w.WriteHeader(ErrorStatus(err))
+		},
+	}, nil)
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(CSRFToken(r.Context())))
+	}
+	s.Register([]Register{
+		{Method: http.MethodGet, Path: "/form", Handler: handler},
+		{Method: http.MethodPost, Path: "/form", Handler: handler},
+		{Method: http.MethodPost, Path: "/hooks", Handler: handler, Name: "webhook"},
+	}, "")
+	return s
+}
+
+func TestCSRFDoubleSubmit(t *testing.T) {
+	var reported error
+	s := newCSRFService(&CSRFOptions{
+		Secret:  []byte("secret"),
+		Origins: &CORSOptions{AllowOrigins: []string{"https://*.example.com"}},
+		Exempt:  []string{"webhook"},
+	}, &reported)
+
+	// a safe request issues the cookie and exposes the token
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "http://api.example.com/form", nil)
+	s.ServeHTTP(w, r)
+	cookies := w.Result().Cookies()
+	if w.Code != http.StatusOK || len(cookies) != 1 || cookies[0].Name != "csrf_token" {
+		t.Fatalf("expected csrf cookie, got %d %v", w.Code, cookies)
+	}
+	token := cookies[0].Value
+	if w.Body.String() != token {
+		t.Errorf("expected token %q in context, got %q", token, w.Body.String())
+	}
+	if cookies[0].HttpOnly || cookies[0].SameSite != http.SameSiteLaxMode {
+		t.Errorf("expected cookie readable by scripts with SameSite=Lax, got %+v", cookies[0])
+	}
+
+	// the cookie is kept by later requests
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodGet, "http://api.example.com/form", nil)
+	r.AddCookie(cookies[0])
+	s.ServeHTTP(w, r)
+	if len(w.Result().Cookies()) != 0 || w.Body.String() != token {
+		t.Errorf("expected the token of the cookie, got %q", w.Body.String())
+	}
+
+	testcases := []struct {
+		test    string
+		path    string
+		cookie  string
+		header  string
+		form    string
+		origin  string
+		referer string
+		code    int
+		err     error
+	}{
+		{"header", "/form", token, token, "", "", "", 200, nil},
+		{"form field", "/form", token, "", token, "", "", 200, nil},
+		{"same origin", "/form", token, token, "", "http://api.example.com", "", 200, nil},
+		{"trusted origin", "/form", token, token, "", "https://console.example.com", "", 200, nil},
+		{"trusted referer", "/form", token, token, "", "", "https://console.example.com/page", 200, nil},
+		{"untrusted origin", "/form", token, token, "", "https://evil.com", "", 403, ErrCSRFOrigin},
+		{"untrusted referer", "/form", token, token, "", "", "https://evil.com/page", 403, ErrCSRFOrigin},
+		{"null origin", "/form", token, token, "", "null", "", 403, ErrCSRFOrigin},
+		{"missing token", "/form", token, "", "", "", "", 403, ErrInvalidCSRFToken},
+		{"wrong token", "/form", token, "x" + token, "", "", "", 403, ErrInvalidCSRFToken},
+		{"missing cookie", "/form", "", token, "", "", "", 403, ErrInvalidCSRFToken},
+		{"unsigned cookie", "/form", "planted", "planted", "", "", "", 403, ErrInvalidCSRFToken},
+		{"exempt route", "/hooks", "", "", "", "https://evil.com", "", 200, nil},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.test, func(t *testing.T) {
+			reported = nil
+			form := url.Values{}
+			if tc.form != "" {
+				form.Set("csrf_token", tc.form)
+			}
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "http://api.example.com"+tc.path, strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tc.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: "csrf_token", Value: tc.cookie})
+			}
+			if tc.header != "" {
+				r.Header.Set("X-CSRF-Token", tc.header)
+			}
+			if tc.origin != "" {
+				r.Header.Set("Origin", tc.origin)
+			}
+			if tc.referer != "" {
+				r.Header.Set("Referer", tc.referer)
+			}
+			s.ServeHTTP(w, r)
+
+			if w.Code != tc.code {
+				t.Errorf("expected status %d, got %d (%v)", tc.code, w.Code, reported)
+			}
+			if tc.err != nil && !errors.Is(reported, tc.err) {
+				t.Errorf("expected error %v, got %v", tc.err, reported)
+			}
+		})
+	}
+}
+
+func TestCSRFDoubleSubmitSessionBinding(t *testing.T) {
+	var reported error
+	s := newCSRFService(&CSRFOptions{
+		Secret:    []byte("secret"),
+		SessionID: func(r *http.Request) string { return r.Header.Get("X-Session") },
+	}, &reported)
+
+	issue := func(session string) string {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/form", nil)
+		r.Header.Set("X-Session", session)
+		s.ServeHTTP(w, r)
+		return w.Body.String()
+	}
+	victim, attacker := issue("victim"), issue("attacker")
+
+	testcases := []struct {
+		test    string
+		session string
+		token   string
+		code    int
+	}{
+		{"own token", "victim", victim, 200},
+		{"token of another session", "victim", attacker, 403},
+		{"token without session", "", victim, 403},
+	}
+	for _, tc := range testcases {
+		reported = nil
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/form", nil)
+		r.Header.Set("X-Session", tc.session)
+		r.AddCookie(&http.Cookie{Name: "csrf_token", Value: tc.token})
+		r.Header.Set("X-CSRF-Token", tc.token)
+		s.ServeHTTP(w, r)
+
+		if w.Code != tc.code {
+			t.Errorf("%s: expected status %d, got %d (%v)", tc.test, tc.code, w.Code, reported)
+		}
+		if tc.code == 403 && !errors.Is(reported, ErrInvalidCSRFToken) {
+			t.Errorf("%s: expected ErrInvalidCSRFToken, got %v", tc.test, reported)
+		}
+	}
+}
+
+func TestCSRFNilOptions(t *testing.T) {
+	var reported error
+	s := newCSRFService(nil, &reported)
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/form", nil))
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("expected csrf cookie, got %v", cookies)
+	}
+
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/form", nil)
+	r.AddCookie(cookies[0])
+	r.Header.Set("X-CSRF-Token", cookies[0].Value)
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status 200 with the default options, got %d (%v)", w.Code, reported)
+	}
+}
+
+func TestCSRFSynchronizer(t *testing.T) {
+	var reported error
+	store := NewMemoryCSRFTokenStore()
+	s := newCSRFService(&CSRFOptions{
+		Mode:  CSRFSynchronizer,
+		Store: store,
+		SessionID: func(r *http.Request) string {
+			if c, err := r.Cookie("session"); err == nil {
+				return c.Value
+			}
+			return ""
+		},
+	}, &reported)
+
+	serve := func(method, session, token string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, "http://api.example.com/form", nil)
+		if session != "" {
+			r.AddCookie(&http.Cookie{Name: "session", Value: session})
+		}
+		if token != "" {
+			r.Header.Set("X-CSRF-Token", token)
+		}
+		s.ServeHTTP(w, r)
+		return w
+	}
+
+	alice := serve(http.MethodGet, "alice", "").Body.String()
+	bob := serve(http.MethodGet, "bob", "").Body.String()
+	if alice == "" || alice == bob {
+		t.Fatalf("expected distinct tokens per session, got %q and %q", alice, bob)
+	}
+	if again := serve(http.MethodGet, "alice", "").Body.String(); again != alice {
+		t.Errorf("expected stable token per session, got %q and %q", alice, again)
+	}
+	if w := serve(http.MethodGet, "alice", ""); len(w.Result().Cookies()) != 0 {
+		t.Errorf("expected no cookie in synchronizer mode")
+	}
+
+	if w := serve(http.MethodPost, "alice", alice); w.Code != http.StatusOK {
+		t.Errorf("expected status 200, got %d", w.Code)
+	}
+	if w := serve(http.MethodPost, "alice", bob); w.Code != http.StatusForbidden {
+		t.Errorf("expected token of other session to be rejected, got %d", w.Code)
+	}
+	if w := serve(http.MethodPost, "", alice); w.Code != http.StatusForbidden {
+		t.Errorf("expected request without session to be rejected, got %d", w.Code)
+	}
+
+	store.DeleteToken("alice")
+	if w := serve(http.MethodPost, "alice", alice); w.Code != http.StatusForbidden {
+		t.Errorf("expected deleted token to be rejected, got %d", w.Code)
+	}
+}
+
+func TestCSRFTemplateField(t *testing.T) {
+	var field string
+	h := NewCSRF(&CSRFOptions{FormField: "_csrf"})(func(w http.ResponseWriter, r *http.Request) {
+		field = string(CSRFTemplateField(r.Context()))
+	})
+
+	w := httptest.NewRecorder()
+	h(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	token := w.Result().Cookies()[0].Value
+	if expected := `<input type="hidden" name="_csrf" value="` + token + `">`; field != expected {
+		t.Errorf("expected %q, got %q", expected, field)
+	}
+	if CSRFTemplateField(httptest.NewRequest(http.MethodGet, "/", nil).Context()) != "" {
+		t.Errorf("expected no field outside of the middleware")
+	}
+}
@@ -0,0 +1,154 @@
+package rest
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const headerContentEncoding = "Content-Encoding"
+
+// DefaultMaxBodySize is the maximum size of a request body accepted by Decode
+// if no other limit is configured.
+const DefaultMaxBodySize int64 = 10 << 20
+
+// Errors reported by Decode. They are wrapped in a StatusError.
+var (
+	ErrBodyTooLarge         = errors.New("request body too large")
+	ErrUnsupportedMediaType = errors.New("unsupported media type")
+	ErrInvalidBody          = errors.New("invalid request body")
+)
+
+// decodeKey defines the context key of the decodeOptions
+type decodeKey struct{}
+
+// decodeOptions holds the settings of the Service and route for Decode.
+type decodeOptions struct {
+	maxBodySize           int64
+	disallowUnknownFields bool
+}
+
+func getDecodeOptions(ctx context.Context) decodeOptions {
+	if o, ok := ctx.Value(decodeKey{}).(decodeOptions); ok {
+		return o
+	}
+	return decodeOptions{maxBodySize: DefaultMaxBodySize}
+}
+
+func withDecodeOptions(ctx context.Context, o decodeOptions) context.Context {
+	return context.WithValue(ctx, decodeKey{}, o)
+}
+
+// withMaxBodySize overrides the body size limit of the Service for a single route.
+func withMaxBodySize(h http.HandlerFunc, size int64) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		o := getDecodeOptions(r.Context())
+		o.maxBodySize = size
+		h(w, r.WithContext(withDecodeOptions(r.Context(), o)))
+	}
+}
+
+// Decode unmarshals the body of r into v using the codec registered in the
+// DefaultCodecs for the Content-Type of the request. If the request has no
+// Content-Type the default codec is used. Bodies with Content-Encoding gzip
+// are decompressed.
+//
+// The size of the body, compressed and decompressed, is limited by the
+// MaxBodySize of the Register or the Configuration. Errors are returned as
+// StatusError with status 413 (ErrBodyTooLarge), 415 (ErrUnsupportedMediaType)
+// or 400 (ErrInvalidBody) and can be passed to the ErrorHandler.
+func Decode(r *http.Request, v interface{}) error {
+	o := getDecodeOptions(r.Context())
+
+	var (
+		codec Codec
+		ok    bool
+	)
+	if ct := r.Header.Get(headerContentType); ct != "" {
+		codec, ok = DefaultCodecs.Lookup(ct)
+	} else {
+		codec, ok = DefaultCodecs.Default()
+	}
+	if !ok {
+		return NewStatusError(http.StatusUnsupportedMediaType,
+			fmt.Errorf("%w: %q", ErrUnsupportedMediaType, r.Header.Get(headerContentType)))
+	}
+
+	if r.Body == nil {
+		return NewStatusError(http.StatusBadRequest, fmt.Errorf("%w: empty body", ErrInvalidBody))
+	}
+	if o.maxBodySize > 0 && r.ContentLength > o.maxBodySize {
+		return NewStatusError(http.StatusRequestEntityTooLarge, ErrBodyTooLarge)
+	}
+
+	body := limitReader(r.Body, o.maxBodySize)
+	switch enc := strings.ToLower(strings.TrimSpace(r.Header.Get(headerContentEncoding))); enc {
+	case "", "identity":
+	case "gzip", "x-gzip":
+		zr, err := gzip.NewReader(body)
+		if err != nil {
+			if errors.Is(err, ErrBodyTooLarge) {
+				return NewStatusError(http.StatusRequestEntityTooLarge, ErrBodyTooLarge)
+			}
+			return NewStatusError(http.StatusBadRequest, fmt.Errorf("%w: %v", ErrInvalidBody, err))
+		}
+		defer zr.Close()
+		body = limitReader(zr, o.maxBodySize)
+	default:
+		return NewStatusError(http.StatusUnsupportedMediaType,
+			fmt.Errorf("%w: content encoding %q", ErrUnsupportedMediaType, enc))
+	}
+
+	data, err := io.ReadAll(body)
+	if errors.Is(err, ErrBodyTooLarge) {
+		return NewStatusError(http.StatusRequestEntityTooLarge, ErrBodyTooLarge)
+	}
+	if err != nil {
+		return NewStatusError(http.StatusBadRequest, fmt.Errorf("%w: %v", ErrInvalidBody, err))
+	}
+	if len(data) == 0 {
+		return NewStatusError(http.StatusBadRequest, fmt.Errorf("%w: empty body", ErrInvalidBody))
+	}
+
+	if s, ok := codec.(StrictUnmarshaler); ok && o.disallowUnknownFields {
+		err = s.UnmarshalStrict(data, v)
+	} else {
+		err = codec.Unmarshal(data, v)
+	}
+	if err != nil {
+		return NewStatusError(http.StatusBadRequest, fmt.Errorf("%w: %v", ErrInvalidBody, err))
+	}
+	return nil
+}
+
+// limitedReader reads at most n bytes and fails with ErrBodyTooLarge if more are available.
+type limitedReader struct {
+	r io.Reader
+	n int64
+}
+
+func limitReader(r io.Reader, n int64) io.Reader {
+	if n <= 0 {
+		return r
+	}
+	return &limitedReader{r: r, n: n}
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.n < 0 {
+		return 0, ErrBodyTooLarge
+	}
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	if l.n < 0 {
+		return n, ErrBodyTooLarge
+	}
+	return n, err
+}
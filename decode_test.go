@@ -0,0 +1,175 @@
+package rest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type decodeValue struct {
+	Name string `json:"name" xml:"name"`
+}
+
+func gzipped(s string) string {
+	var b bytes.Buffer
+	zw := gzip.NewWriter(&b)
+	zw.Write([]byte(s))
+	zw.Close()
+	return b.String()
+}
+
+func TestDecode(t *testing.T) {
+	testcases := []struct {
+		test            string
+		cfg             Configuration
+		maxBodySize     int64
+		contentType     string
+		contentEncoding string
+		body            string
+		expected        decodeValue
+		status          int
+		err             error
+	}{
+		{
+			test:        "json",
+			contentType: "application/json; charset=utf-8",
+			body:        `{"name":"a"}`,
+			expected:    decodeValue{Name: "a"},
+		},
+		{
+			test:     "no content type",
+			body:     `{"name":"a"}`,
+			expected: decodeValue{Name: "a"},
+		},
+		{
+			test:        "xml",
+			contentType: "text/xml",
+			body:        `<decodeValue><name>a</name></decodeValue>`,
+			expected:    decodeValue{Name: "a"},
+		},
+		{
+			test:            "gzip",
+			contentType:     "application/json",
+			contentEncoding: "gzip",
+			body:            gzipped(`{"name":"a"}`),
+			expected:        decodeValue{Name: "a"},
+		},
+		{
+			test:        "unknown fields allowed",
+			contentType: "application/json",
+			body:        `{"name":"a","other":1}`,
+			expected:    decodeValue{Name: "a"},
+		},
+		{
+			test:        "unknown fields rejected",
+			cfg:         Configuration{DisallowUnknownFields: true},
+			contentType: "application/json",
+			body:        `{"name":"a","other":1}`,
+			status:      http.StatusBadRequest,
+			err:         ErrInvalidBody,
+		},
+		{
+			test:        "invalid",
+			contentType: "application/json",
+			body:        `{"name":`,
+			status:      http.StatusBadRequest,
+			err:         ErrInvalidBody,
+		},
+		{
+			test:        "empty",
+			contentType: "application/json",
+			status:      http.StatusBadRequest,
+			err:         ErrInvalidBody,
+		},
+		{
+			test:        "unsupported media type",
+			contentType: "image/png",
+			body:        `{"name":"a"}`,
+			status:      http.StatusUnsupportedMediaType,
+			err:         ErrUnsupportedMediaType,
+		},
+		{
+			test:            "unsupported encoding",
+			contentType:     "application/json",
+			contentEncoding: "br",
+			body:            `{"name":"a"}`,
+			status:          http.StatusUnsupportedMediaType,
+			err:             ErrUnsupportedMediaType,
+		},
+		{
+			test:        "global limit",
+			cfg:         Configuration{MaxBodySize: 8},
+			contentType: "application/json",
+			body:        `{"name":"a"}`,
+			status:      http.StatusRequestEntityTooLarge,
+			err:         ErrBodyTooLarge,
+		},
+		{
+			test:        "route limit",
+			cfg:         Configuration{MaxBodySize: 8},
+			maxBodySize: 64,
+			contentType: "application/json",
+			body:        `{"name":"a"}`,
+			expected:    decodeValue{Name: "a"},
+		},
+		{
+			test:            "decompressed limit",
+			maxBodySize:     64,
+			contentType:     "application/json",
+			contentEncoding: "gzip",
+			body:            gzipped(`{"name":"` + strings.Repeat("a", 1000) + `"}`),
+			status:          http.StatusRequestEntityTooLarge,
+			err:             ErrBodyTooLarge,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.test, func(t *testing.T) {
+			var (
+				actual decodeValue
+				err    error
+			)
+
+			s := New(tc.cfg, nil)
+			s.Register([]Register{{
+				Method:      http.MethodPost,
+				Path:        "/foo",
+				MaxBodySize: tc.maxBodySize,
+				Handler: func(w http.ResponseWriter, r *http.Request) {
+					err = Decode(r, &actual)
+				},
+			}}, "")
+
+			r, _ := http.NewRequest(http.MethodPost, "/foo", strings.NewReader(tc.body))
+			if tc.contentType != "" {
+				r.Header.Set(headerContentType, tc.contentType)
+			}
+			if tc.contentEncoding != "" {
+				r.Header.Set(headerContentEncoding, tc.contentEncoding)
+			}
+			s.ServeHTTP(httptest.NewRecorder(), r)
+
+			if tc.err != nil {
+				if !errors.Is(err, tc.err) {
+					t.Fatalf("Got: %v - want: %v", err, tc.err)
+				}
+				if status := ErrorStatus(err); status != tc.status {
+					t.Fatalf("Got: %d - want: %d", status, tc.status)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Got: %v - want: no error", err)
+			}
+
+			if actual != tc.expected {
+				t.Fatalf("Got: %v - want: %v", actual, tc.expected)
+			}
+		})
+	}
+}
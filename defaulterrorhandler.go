@@ -3,8 +3,9 @@ package rest
 import "net/http"
 
 // DefaultErrorHandler is a default implementation of an Error Handler taken by the service framework.
+// Errors satisfying the Statuser interface are answered with their status, all others with 500.
 func DefaultErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
-	http.Error(w, err.Error(), http.StatusInternalServerError)
+	http.Error(w, err.Error(), ErrorStatus(err))
 }
 
 // NotFoundHandler is a default implementation of an NotFound Handler taken by the service framework.
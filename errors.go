@@ -0,0 +1,66 @@
+package rest
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// errorHandlerKey defines the context key of the ErrorHandler of the serving Service
+type errorHandlerKey struct{}
+
+// ErrNotAcceptable is reported if no Codec matches the Accept header of a request.
+var ErrNotAcceptable = errors.New("no acceptable representation available")
+
+// StatusError wraps an error with the HTTP status code it should be answered with.
+// It satisfies the Statuser interface.
+type StatusError struct {
+	Code int
+	Err  error
+}
+
+// NewStatusError creates a new StatusError.
+func NewStatusError(code int, err error) *StatusError {
+	return &StatusError{Code: code, Err: err}
+}
+
+func (e *StatusError) Error() string {
+	if e.Err == nil {
+		return http.StatusText(e.Code)
+	}
+	return e.Err.Error()
+}
+
+// Status returns the HTTP status code.
+func (e *StatusError) Status() int { return e.Code }
+
+// Unwrap returns the wrapped error.
+func (e *StatusError) Unwrap() error { return e.Err }
+
+// ErrorStatus returns the status of the first error in err's chain that
+// satisfies the Statuser interface, otherwise http.StatusInternalServerError.
+func ErrorStatus(err error) int {
+	var s Statuser
+	if errors.As(err, &s) {
+		return s.Status()
+	}
+	return http.StatusInternalServerError
+}
+
+// HandleError reports err to the ErrorHandler of the Service serving r.
+// Outside of a Service the DefaultErrorHandler is used.
+func HandleError(w http.ResponseWriter, r *http.Request, err error) {
+	errorHandlerOf(r.Context())(w, r, err)
+}
+
+// errorHandlerOf returns the ErrorHandler carried by ctx or the DefaultErrorHandler.
+func errorHandlerOf(ctx context.Context) ErrorHandler {
+	if h, ok := ctx.Value(errorHandlerKey{}).(ErrorHandler); ok && h != nil {
+		return h
+	}
+	return DefaultErrorHandler
+}
+
+func withErrorHandler(ctx context.Context, h ErrorHandler) context.Context {
+	return context.WithValue(ctx, errorHandlerKey{}, h)
+}
@@ -0,0 +1,262 @@
+package rest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	headerETag              = "ETag"
+	headerLastModified      = "Last-Modified"
+	headerIfMatch           = "If-Match"
+	headerIfNoneMatch       = "If-None-Match"
+	headerIfModifiedSince   = "If-Modified-Since"
+	headerIfUnmodifiedSince = "If-Unmodified-Since"
+)
+
+// ErrPreconditionFailed is reported if a precondition of a request does not hold.
+var ErrPreconditionFailed = errors.New("precondition failed")
+
+// ETagger is an interface to get the entity tag from an object.
+// The tag may be given with or without quotes, e.g. a version number.
+type ETagger interface {
+	ETag() string
+}
+
+// LastModifieder is an interface to get the time of the last modification from an object.
+type LastModifieder interface {
+	LastModified() time.Time
+}
+
+// SetValidators sets the ETag and Last-Modified headers in w if v satisfies
+// the ETagger or LastModifieder interface.
+func SetValidators(w http.ResponseWriter, v interface{}) {
+	if e, ok := v.(ETagger); ok {
+		if tag := e.ETag(); tag != "" {
+			w.Header().Set(headerETag, quoteETag(tag))
+		}
+	}
+	if l, ok := v.(LastModifieder); ok {
+		if t := l.LastModified(); !t.IsZero() {
+			w.Header().Set(headerLastModified, t.UTC().Format(http.TimeFormat))
+		}
+	}
+}
+
+// CheckPreconditions evaluates the conditional headers of r against the
+// current state of the resource v, which may satisfy ETagger and
+// LastModifieder, as defined in RFC 7232. v is nil if the resource does not
+// exist. It returns true if the request should be processed. Otherwise the
+// response has been written: 304 for GET and HEAD requests whose
+// representation has not changed, else ErrPreconditionFailed with status 412
+// through the ErrorHandler.
+func CheckPreconditions(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	var (
+		etag         string
+		lastModified time.Time
+	)
+	if e, ok := v.(ETagger); ok {
+		if tag := e.ETag(); tag != "" {
+			etag = quoteETag(tag)
+		}
+	}
+	if l, ok := v.(LastModifieder); ok {
+		lastModified = l.LastModified()
+	}
+
+	switch evaluatePreconditions(r, v != nil, etag, lastModified) {
+	case http.StatusNotModified:
+		SetValidators(w, v)
+		w.WriteHeader(http.StatusNotModified)
+		return false
+	case http.StatusPreconditionFailed:
+		HandleError(w, r, NewStatusError(http.StatusPreconditionFailed, ErrPreconditionFailed))
+		return false
+	}
+	return true
+}
+
+// evaluatePreconditions returns 0 if the request should be processed, otherwise 304 or 412.
+func evaluatePreconditions(r *http.Request, exists bool, etag string, lastModified time.Time) int {
+	safe := r.Method == http.MethodGet || r.Method == http.MethodHead
+
+	if im := r.Header.Get(headerIfMatch); im != "" {
+		if !matchETag(im, etag, exists, true) {
+			return http.StatusPreconditionFailed
+		}
+	} else if ius := r.Header.Get(headerIfUnmodifiedSince); ius != "" && !lastModified.IsZero() {
+		if t, err := http.ParseTime(ius); err == nil && lastModified.Truncate(time.Second).After(t) {
+			return http.StatusPreconditionFailed
+		}
+	}
+
+	if inm := r.Header.Get(headerIfNoneMatch); inm != "" {
+		if matchETag(inm, etag, exists, false) {
+			if safe {
+				return http.StatusNotModified
+			}
+			return http.StatusPreconditionFailed
+		}
+	} else if ims := r.Header.Get(headerIfModifiedSince); ims != "" && safe && !lastModified.IsZero() {
+		if t, err := http.ParseTime(ims); err == nil && !lastModified.Truncate(time.Second).After(t) {
+			return http.StatusNotModified
+		}
+	}
+
+	return 0
+}
+
+// matchETag reports whether etag is contained in the list of a conditional header.
+func matchETag(list, etag string, exists, strong bool) bool {
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return exists
+		}
+		if etag == "" {
+			continue
+		}
+		if strong {
+			if !isWeakETag(candidate) && !isWeakETag(etag) && candidate == etag {
+				return true
+			}
+			continue
+		}
+		if strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+func isWeakETag(etag string) bool {
+	return strings.HasPrefix(etag, "W/")
+}
+
+// quoteETag quotes tag unless it is quoted already.
+func quoteETag(tag string) string {
+	if strings.HasPrefix(tag, `"`) || strings.HasPrefix(tag, `W/"`) {
+		return tag
+	}
+	return `"` + tag + `"`
+}
+
+// ETagOptions represents the available ETag options
+type ETagOptions struct {
+	// If set, generated entity tags are weak. Use weak tags if the
+	// representation is changed later on, e.g. by compression.
+	Weak bool
+}
+
+// NewETag creates a middleware answering conditional GET and HEAD requests.
+// Successful responses are buffered; if the handler did not set an ETag, it
+// is generated from a hash of the body. If-None-Match and If-Modified-Since
+// are answered with 304 Not Modified.
+//
+// Conditional writes require the current state of the resource and are
+// handled by CheckPreconditions within the handler.
+func NewETag(opts *ETagOptions) Middleware {
+	if opts == nil {
+		opts = &ETagOptions{}
+	}
+
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				next(w, r)
+				return
+			}
+
+			bw := &bufferedResponseWriter{ResponseWriter: w}
+			next(bw, r)
+			if bw.flushed {
+				return
+			}
+
+			if bw.status == 0 {
+				bw.status = http.StatusOK
+			}
+			if bw.status != http.StatusOK {
+				bw.writeTo(w)
+				return
+			}
+
+			h := w.Header()
+			etag := h.Get(headerETag)
+			if etag == "" && r.Method == http.MethodGet {
+				sum := sha256.Sum256(bw.body.Bytes())
+				etag = `"` + base64.RawURLEncoding.EncodeToString(sum[:18]) + `"`
+				if opts.Weak {
+					etag = "W/" + etag
+				}
+				h.Set(headerETag, etag)
+			}
+
+			var lastModified time.Time
+			if lm := h.Get(headerLastModified); lm != "" {
+				lastModified, _ = http.ParseTime(lm)
+			}
+
+			if evaluatePreconditions(r, true, etag, lastModified) == http.StatusNotModified {
+				for _, k := range []string{headerContentType, headerContentLength, headerContentEncoding} {
+					h.Del(k)
+				}
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+
+			bw.writeTo(w)
+		}
+	}
+}
+
+// bufferedResponseWriter keeps status and body of a response until it is
+// written by writeTo. After a Flush the response is passed through.
+type bufferedResponseWriter struct {
+	http.ResponseWriter
+	status  int
+	body    bytes.Buffer
+	flushed bool
+}
+
+func (bw *bufferedResponseWriter) WriteHeader(status int) {
+	if bw.flushed {
+		bw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if bw.status == 0 {
+		bw.status = status
+	}
+}
+
+func (bw *bufferedResponseWriter) Write(p []byte) (int, error) {
+	if bw.flushed {
+		return bw.ResponseWriter.Write(p)
+	}
+	return bw.body.Write(p)
+}
+
+// Flush writes the buffered response and passes the remaining one through.
+func (bw *bufferedResponseWriter) Flush() {
+	if !bw.flushed {
+		bw.flushed = true
+		if bw.status == 0 {
+			bw.status = http.StatusOK
+		}
+		bw.writeTo(bw.ResponseWriter)
+	}
+	if f, ok := bw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (bw *bufferedResponseWriter) writeTo(w http.ResponseWriter) {
+	w.WriteHeader(bw.status)
+	w.Write(bw.body.Bytes())
+	bw.body.Reset()
+}
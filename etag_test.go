@@ -0,0 +1,162 @@
+package rest
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type versioned struct {
+	version  string
+	modified time.Time
+}
+
+func (v versioned) ETag() string            { return v.version }
+func (v versioned) LastModified() time.Time { return v.modified }
+
+func TestETagMiddleware(t *testing.T) {
+	m := NewETag(nil)
+	handler := m(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"name":"a"}`)
+	})
+
+	recorder := httptest.NewRecorder()
+	r, _ := http.NewRequest(http.MethodGet, "/", nil)
+	handler(recorder, r)
+
+	etag := recorder.Header().Get(headerETag)
+	if etag == "" || recorder.Code != http.StatusOK || recorder.Body.String() != `{"name":"a"}` {
+		t.Fatalf("Response is expected to be 200 with ETag, found %d %q", recorder.Code, etag)
+	}
+
+	testcases := []struct {
+		test        string
+		ifNoneMatch string
+		status      int
+	}{
+		{"matching", etag, http.StatusNotModified},
+		{"matching weak", "W/" + etag, http.StatusNotModified},
+		{"matching in list", `"other", ` + etag, http.StatusNotModified},
+		{"wildcard", "*", http.StatusNotModified},
+		{"not matching", `"other"`, http.StatusOK},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.test, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			r, _ := http.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set(headerIfNoneMatch, tc.ifNoneMatch)
+			handler(recorder, r)
+
+			if recorder.Code != tc.status {
+				t.Errorf("Status code is expected to be %d, found %d", tc.status, recorder.Code)
+			}
+
+			if tc.status == http.StatusNotModified && recorder.Body.Len() != 0 {
+				t.Errorf("Body is expected to be empty, found %q", recorder.Body.String())
+			}
+
+			if v := recorder.Header().Get(headerETag); v != etag {
+				t.Errorf("ETag is expected to be %v, found %v", etag, v)
+			}
+		})
+	}
+}
+
+func TestETagMiddlewareWeakAndHandlerSupplied(t *testing.T) {
+	m := NewETag(&ETagOptions{Weak: true})
+
+	recorder := httptest.NewRecorder()
+	r, _ := http.NewRequest(http.MethodGet, "/", nil)
+	m(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "a")
+	})(recorder, r)
+
+	if v := recorder.Header().Get(headerETag); !isWeakETag(v) {
+		t.Errorf("ETag is expected to be weak, found %v", v)
+	}
+
+	modified := time.Date(2017, 1, 15, 1, 30, 15, 0, time.UTC)
+	handler := m(func(w http.ResponseWriter, r *http.Request) {
+		Render(w, r, http.StatusOK, versioned{version: "v7", modified: modified})
+	})
+
+	recorder = httptest.NewRecorder()
+	r, _ = http.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(headerIfModifiedSince, modified.Format(http.TimeFormat))
+	handler(recorder, r)
+
+	if recorder.Code != http.StatusNotModified {
+		t.Errorf("Status code is expected to be 304, found %d", recorder.Code)
+	}
+
+	if v := recorder.Header().Get(headerETag); v != `"v7"` {
+		t.Errorf(`ETag is expected to be "v7", found %v`, v)
+	}
+}
+
+func TestCheckPreconditions(t *testing.T) {
+	modified := time.Date(2017, 1, 15, 1, 30, 15, 0, time.UTC)
+	current := versioned{version: "v2", modified: modified}
+
+	testcases := []struct {
+		test    string
+		method  string
+		header  string
+		value   string
+		current interface{}
+		ok      bool
+		status  int
+	}{
+		{"if-match", http.MethodPut, headerIfMatch, `"v2"`, current, true, 0},
+		{"if-match outdated", http.MethodPut, headerIfMatch, `"v1"`, current, false, http.StatusPreconditionFailed},
+		{"if-match weak", http.MethodPut, headerIfMatch, `W/"v2"`, current, false, http.StatusPreconditionFailed},
+		{"if-match wildcard", http.MethodPut, headerIfMatch, "*", current, true, 0},
+		{"if-match wildcard missing", http.MethodPut, headerIfMatch, "*", nil, false, http.StatusPreconditionFailed},
+		{"if-none-match create", http.MethodPut, headerIfNoneMatch, "*", nil, true, 0},
+		{"if-none-match exists", http.MethodPut, headerIfNoneMatch, "*", current, false, http.StatusPreconditionFailed},
+		{"if-none-match get", http.MethodGet, headerIfNoneMatch, `"v2"`, current, false, http.StatusNotModified},
+		{"if-unmodified-since", http.MethodPatch, headerIfUnmodifiedSince, modified.Format(http.TimeFormat), current, true, 0},
+		{"if-unmodified-since outdated", http.MethodPatch, headerIfUnmodifiedSince, modified.Add(-time.Hour).Format(http.TimeFormat), current, false, http.StatusPreconditionFailed},
+		{"no conditions", http.MethodDelete, "", "", current, true, 0},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.test, func(t *testing.T) {
+			var reported error
+			s := New(Configuration{
+				ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+					reported = err
+					w.WriteHeader(ErrorStatus(err))
+				},
+			}, nil)
+
+			var ok bool
+			s.Route(tc.method, "/foo", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ok = CheckPreconditions(w, r, tc.current)
+			}))
+
+			recorder := httptest.NewRecorder()
+			r, _ := http.NewRequest(tc.method, "/foo", nil)
+			if tc.header != "" {
+				r.Header.Set(tc.header, tc.value)
+			}
+			s.ServeHTTP(recorder, r)
+
+			if ok != tc.ok {
+				t.Fatalf("Got: %t - want: %t", ok, tc.ok)
+			}
+
+			if tc.status != 0 && recorder.Code != tc.status {
+				t.Errorf("Status code is expected to be %d, found %d", tc.status, recorder.Code)
+			}
+
+			if tc.status == http.StatusPreconditionFailed && !errors.Is(reported, ErrPreconditionFailed) {
+				t.Errorf("Got: %v - want: %v", reported, ErrPreconditionFailed)
+			}
+		})
+	}
+}
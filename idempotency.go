@@ -0,0 +1,237 @@
+package rest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	headerIdempotencyKey     = "Idempotency-Key"
+	headerIdempotentReplayed = "Idempotent-Replayed"
+	maxIdempotencyKeyLength  = 255
+	defaultIdempotencyTTL    = 24 * time.Hour
+)
+
+var (
+	// ErrMissingIdempotencyKey is reported with status 400 if a required Idempotency-Key is missing or invalid.
+	ErrMissingIdempotencyKey = errors.New("missing or invalid idempotency key")
+	// ErrIdempotencyKeyMismatch is reported with status 422 if a key is reused with another payload.
+	ErrIdempotencyKeyMismatch = errors.New("idempotency key reused with another payload")
+	// ErrIdempotencyKeyInFlight is reported with status 409 while the first request of a key is processed.
+	ErrIdempotencyKeyInFlight = errors.New("request with idempotency key is in progress")
+)
+
+// IdempotentResponse is the state of an idempotency key stored in an IdempotencyStore.
+type IdempotentResponse struct {
+	// Fingerprint identifies the payload of the first request.
+	Fingerprint string
+	// Completed is false while the first request is processed.
+	Completed bool
+	Status    int
+	Header    http.Header
+	Body      []byte
+	// Expires is the time the key may be used again.
+	Expires time.Time
+}
+
+// IdempotencyStore keeps the responses of idempotency keys. It must be safe
+// for concurrent use and for stores shared between instances Lock must be atomic.
+type IdempotencyStore interface {
+	// Lock stores the in-flight entry if the key is unknown or expired and
+	// reports true. Otherwise it returns the stored entry.
+	Lock(key string, entry *IdempotentResponse, now time.Time) (*IdempotentResponse, bool, error)
+	// Save replaces the in-flight entry of the key by the completed one.
+	Save(key string, entry *IdempotentResponse) error
+	// Unlock removes the in-flight entry of the key, so the request can be retried.
+	Unlock(key string) error
+}
+
+// MemoryIdempotencyStore is an in-memory IdempotencyStore for a single instance.
+type MemoryIdempotencyStore struct {
+	mu      sync.Mutex
+	entries map[string]*IdempotentResponse
+	ops     int
+}
+
+// NewMemoryIdempotencyStore creates a new MemoryIdempotencyStore.
+func NewMemoryIdempotencyStore() *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{entries: map[string]*IdempotentResponse{}}
+}
+
+// Lock implements IdempotencyStore.
+func (s *MemoryIdempotencyStore) Lock(key string, entry *IdempotentResponse, now time.Time) (*IdempotentResponse, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ops++; s.ops >= sweepInterval {
+		s.ops = 0
+		for k, e := range s.entries {
+			if !now.Before(e.Expires) {
+				delete(s.entries, k)
+			}
+		}
+	}
+
+	if e, ok := s.entries[key]; ok && now.Before(e.Expires) {
+		return e, false, nil
+	}
+	s.entries[key] = entry
+	return nil, true, nil
+}
+
+// Save implements IdempotencyStore.
+func (s *MemoryIdempotencyStore) Save(key string, entry *IdempotentResponse) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[key] = entry
+	return nil
+}
+
+// Unlock implements IdempotencyStore.
+func (s *MemoryIdempotencyStore) Unlock(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.entries[key]; ok && !e.Completed {
+		delete(s.entries, key)
+	}
+	return nil
+}
+
+// IdempotencyOptions represents the available idempotency options
+type IdempotencyOptions struct {
+	// Store keeps the responses. If nil an in-memory store is used.
+	Store IdempotencyStore
+	// TTL is the time a key and its response are kept. If 0 24 hours are used.
+	TTL time.Duration
+	// Required rejects requests to idempotent routes without key with status 400.
+	Required bool
+}
+
+// NewIdempotency creates a middleware implementing the Idempotency-Key header
+// for the routes registered with Idempotent. The first response of a key is
+// stored per key, principal and route and replayed on retries with the
+// Idempotent-Replayed header. A key reused with another payload is rejected
+// with status 422, a retry while the first request is processed with status
+// 409 through the ErrorHandler. Responses with status 5xx are not stored, so
+// the request can be retried. It must follow the authentication middleware in
+// the Chain to separate the keys of principals.
+func NewIdempotency(opts *IdempotencyOptions) Middleware {
+	return newIdempotency(opts, time.Now)
+}
+
+func newIdempotency(opts *IdempotencyOptions, now func() time.Time) Middleware {
+	var o IdempotencyOptions
+	if opts != nil {
+		o = *opts
+	}
+	if o.Store == nil {
+		o.Store = NewMemoryIdempotencyStore()
+	}
+	if o.TTL <= 0 {
+		o.TTL = defaultIdempotencyTTL
+	}
+
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if rt, ok := r.Context().Value(routeKey{}).(*route); !ok || !rt.idempotent {
+				next(w, r)
+				return
+			}
+
+			key, ok := idempotencyKey(r)
+			if !ok || (key == "" && o.Required) {
+				HandleError(w, r, NewStatusError(http.StatusBadRequest, ErrMissingIdempotencyKey))
+				return
+			}
+			if key == "" {
+				next(w, r)
+				return
+			}
+
+			body, err := readBody(r)
+			if err != nil {
+				HandleError(w, r, err)
+				return
+			}
+			fingerprint := idempotencyFingerprint(r, body)
+
+			var principal string
+			if p := Principal(r.Context()); p != nil {
+				principal = p.ID
+			}
+			storeKey := strings.Join([]string{r.Method, RoutePattern(r.Context()), principal, key}, "\x00")
+
+			t := now()
+			entry := &IdempotentResponse{Fingerprint: fingerprint, Expires: t.Add(o.TTL)}
+			stored, locked, err := o.Store.Lock(storeKey, entry, t)
+			if err != nil {
+				HandleError(w, r, err)
+				return
+			}
+			if !locked {
+				switch {
+				case stored.Fingerprint != fingerprint:
+					HandleError(w, r, NewStatusError(http.StatusUnprocessableEntity, ErrIdempotencyKeyMismatch))
+				case !stored.Completed:
+					HandleError(w, r, NewStatusError(http.StatusConflict, ErrIdempotencyKeyInFlight))
+				default:
+					w.Header().Set(headerIdempotentReplayed, "true")
+					rec := &cacheRecorder{header: stored.Header, status: stored.Status, body: stored.Body}
+					rec.copyTo(w)
+				}
+				return
+			}
+
+			saved := false
+			defer func() {
+				if !saved {
+					o.Store.Unlock(storeKey)
+				}
+			}()
+
+			rec := &cacheRecorder{header: http.Header{}}
+			next(rec, r)
+			if rec.status == 0 {
+				rec.status = http.StatusOK
+			}
+
+			if rec.status < 500 {
+				entry = &IdempotentResponse{
+					Fingerprint: fingerprint,
+					Completed:   true,
+					Status:      rec.status,
+					Header:      rec.header.Clone(),
+					Body:        rec.body,
+					Expires:     entry.Expires,
+				}
+				saved = o.Store.Save(storeKey, entry) == nil
+			}
+			rec.copyTo(w)
+		}
+	}
+}
+
+// idempotencyFingerprint identifies the payload of the request by its content
+// type, raw body and parsed form.
+func idempotencyFingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(normalizeMediaType(r.Header.Get("Content-Type")) + "\x00"))
+	h.Write(body)
+	h.Write([]byte("\x00" + r.PostForm.Encode()))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// idempotencyKey returns the Idempotency-Key of the request, given as
+// structured field string or plain token. It reports false if it is invalid.
+func idempotencyKey(r *http.Request) (string, bool) {
+	key := strings.TrimSpace(r.Header.Get(headerIdempotencyKey))
+	if len(key) >= 2 && key[0] == '"' && key[len(key)-1] == '"' {
+		key = key[1 : len(key)-1]
+	}
+	return key, len(key) <= maxIdempotencyKeyLength
+}
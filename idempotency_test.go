@@ -0,0 +1,232 @@
+package rest
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIdempotency(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	var (
+		calls    int
+		reported error
+	)
+
+	users := testAuthenticator{"alice": {ID: "alice"}, "bob": {ID: "bob"}}
+	s := New(Configuration{
+		Chain: []Middleware{
+			NewAuthentication(users),
+			newIdempotency(&IdempotencyOptions{TTL: time.Hour}, func() time.Time { return now }),
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			reported = err
+			w.WriteHeader(ErrorStatus(err))
+		},
+	}, nil)
+	s.Register([]Register{
+		{Method: http.MethodPost, Path: "/payments", Idempotent: true, Handler: func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			w.Header().Set("Location", fmt.Sprintf("/payments/%d", calls))
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprintf(w, "payment %d", calls)
+		}},
+		{Method: http.MethodPost, Path: "/failing", Idempotent: true, Handler: func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}},
+		{Method: http.MethodPost, Path: "/plain", Handler: func(w http.ResponseWriter, r *http.Request) {
+			calls++
+		}},
+	}, "")
+
+	do := func(path, user, key, body string) *httptest.ResponseRecorder {
+		reported = nil
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		r.Header.Set("X-User", user)
+		if key != "" {
+			r.Header.Set("Idempotency-Key", key)
+		}
+		s.ServeHTTP(w, r)
+		return w
+	}
+
+	testcases := []struct {
+		test     string
+		path     string
+		user     string
+		key      string
+		body     string
+		code     int
+		response string
+		replayed bool
+		calls    int
+		err      error
+	}{
+		{"first request", "/payments", "alice", "k1", `{"amount":10}`, 201, "payment 1", false, 1, nil},
+		{"retry", "/payments", "alice", "k1", `{"amount":10}`, 201, "payment 1", true, 1, nil},
+		{"quoted key", "/payments", "alice", `"k1"`, `{"amount":10}`, 201, "payment 1", true, 1, nil},
+		{"payload mismatch", "/payments", "alice", "k1", `{"amount":20}`, 422, "", false, 1, ErrIdempotencyKeyMismatch},
+		{"other principal", "/payments", "bob", "k1", `{"amount":10}`, 201, "payment 2", false, 2, nil},
+		{"other key", "/payments", "alice", "k2", `{"amount":10}`, 201, "payment 3", false, 3, nil},
+		{"without key", "/payments", "alice", "", `{"amount":10}`, 201, "payment 4", false, 4, nil},
+		{"key too long", "/payments", "alice", strings.Repeat("k", 256), `{}`, 400, "", false, 4, ErrMissingIdempotencyKey},
+		{"server error", "/failing", "alice", "k1", `{}`, 503, "", false, 5, nil},
+		{"server error retried", "/failing", "alice", "k1", `{}`, 503, "", false, 6, nil},
+		{"not idempotent", "/plain", "alice", "k1", `{}`, 200, "", false, 7, nil},
+		{"not idempotent retried", "/plain", "alice", "k1", `{}`, 200, "", false, 8, nil},
+	}
+
+	for _, tc := range testcases {
+		w := do(tc.path, tc.user, tc.key, tc.body)
+
+		if w.Code != tc.code {
+			t.Errorf("%s: expected status %d, got %d", tc.test, tc.code, w.Code)
+		}
+		if tc.response != "" && w.Body.String() != tc.response {
+			t.Errorf("%s: expected body %q, got %q", tc.test, tc.response, w.Body.String())
+		}
+		if tc.response != "" && w.Header().Get("Location") == "" {
+			t.Errorf("%s: expected the headers of the response", tc.test)
+		}
+		if replayed := w.Header().Get(headerIdempotentReplayed) == "true"; replayed != tc.replayed {
+			t.Errorf("%s: expected replayed %t, got %t", tc.test, tc.replayed, replayed)
+		}
+		if calls != tc.calls {
+			t.Errorf("%s: expected %d calls, got %d", tc.test, tc.calls, calls)
+		}
+		if !errors.Is(reported, tc.err) {
+			t.Errorf("%s: expected error %v, got %v", tc.test, tc.err, reported)
+		}
+	}
+
+	// the key expires after the TTL
+	now = now.Add(time.Hour)
+	if w := do("/payments", "alice", "k1", `{"amount":20}`); w.Code != 201 || calls != 9 {
+		t.Errorf("expected expired key to be reused, got status %d and %d calls", w.Code, calls)
+	}
+}
+
+func TestIdempotencyForm(t *testing.T) {
+	var calls int
+	s := New(Configuration{Chain: []Middleware{NewIdempotency(nil)}}, nil)
+	s.Register([]Register{{Method: http.MethodPost, Path: "/payments", Idempotent: true, Handler: func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		fmt.Fprintf(w, "amount %s", r.PostForm.Get("amount"))
+	}}}, "")
+
+	testcases := []struct {
+		body     string
+		code     int
+		response string
+		calls    int
+	}{
+		{"amount=1", 200, "amount 1", 1},
+		{"amount=1", 200, "amount 1", 1},
+		{"amount=1000", 422, "", 1},
+	}
+	for _, tc := range testcases {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/payments", strings.NewReader(tc.body))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.Header.Set("Idempotency-Key", "k")
+		s.ServeHTTP(w, r)
+
+		if w.Code != tc.code {
+			t.Errorf("%s: expected status %d, got %d", tc.body, tc.code, w.Code)
+		}
+		if tc.response != "" && w.Body.String() != tc.response {
+			t.Errorf("%s: expected body %q, got %q", tc.body, tc.response, w.Body.String())
+		}
+		if calls != tc.calls {
+			t.Errorf("%s: expected %d calls, got %d", tc.body, tc.calls, calls)
+		}
+	}
+}
+
+func TestIdempotencyInFlight(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	var reported error
+	s := New(Configuration{
+		Chain: []Middleware{NewIdempotency(&IdempotencyOptions{})},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			reported = err
+			w.WriteHeader(ErrorStatus(err))
+		},
+	}, nil)
+	s.Register([]Register{{Method: http.MethodPost, Path: "/payments", Idempotent: true, Handler: func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.WriteHeader(http.StatusCreated)
+	}}}, "")
+
+	do := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/payments", strings.NewReader(`{}`))
+		r.Header.Set("Idempotency-Key", "k")
+		s.ServeHTTP(w, r)
+		return w
+	}
+
+	done := make(chan int)
+	go func() { done <- do().Code }()
+	<-started
+
+	if w := do(); w.Code != http.StatusConflict || !errors.Is(reported, ErrIdempotencyKeyInFlight) {
+		t.Errorf("expected status 409 with ErrIdempotencyKeyInFlight, got %d with %v", w.Code, reported)
+	}
+	close(release)
+	if code := <-done; code != http.StatusCreated {
+		t.Errorf("expected first request to succeed, got status %d", code)
+	}
+	if w := do(); w.Code != http.StatusCreated || w.Header().Get(headerIdempotentReplayed) != "true" {
+		t.Errorf("expected replayed response, got status %d", w.Code)
+	}
+}
+
+func TestIdempotencyRequired(t *testing.T) {
+	s := New(Configuration{Chain: []Middleware{NewIdempotency(&IdempotencyOptions{Required: true})}}, nil)
+	s.Register([]Register{
+		{Method: http.MethodPost, Path: "/payments", Idempotent: true, Handler: func(w http.ResponseWriter, r *http.Request) {}},
+		{Method: http.MethodPost, Path: "/plain", Handler: func(w http.ResponseWriter, r *http.Request) {}},
+	}, "")
+
+	testcases := []struct {
+		path string
+		code int
+	}{
+		{"/payments", 400},
+		{"/plain", 200},
+	}
+	for _, tc := range testcases {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodPost, tc.path, nil))
+		if w.Code != tc.code {
+			t.Errorf("%s: expected status %d, got %d", tc.path, tc.code, w.Code)
+		}
+	}
+}
+
+func TestMemoryIdempotencyStoreUnlock(t *testing.T) {
+	now := time.Now()
+	s := NewMemoryIdempotencyStore()
+
+	if _, locked, _ := s.Lock("k", &IdempotentResponse{Expires: now.Add(time.Hour)}, now); !locked {
+		t.Fatalf("expected lock")
+	}
+	s.Unlock("k")
+	if _, locked, _ := s.Lock("k", &IdempotentResponse{Expires: now.Add(time.Hour)}, now); !locked {
+		t.Fatalf("expected lock after unlock")
+	}
+
+	s.Save("k", &IdempotentResponse{Completed: true, Expires: now.Add(time.Hour)})
+	s.Unlock("k")
+	if e, locked, _ := s.Lock("k", &IdempotentResponse{Expires: now.Add(time.Hour)}, now); locked || !e.Completed {
+		t.Errorf("expected completed entry to be kept")
+	}
+}
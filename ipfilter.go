@@ -0,0 +1,171 @@
+package rest
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"sync/atomic"
+)
+
+// ErrIPForbidden is reported with status 403 if the client IP is denied.
+var ErrIPForbidden = errors.New("client IP not allowed")
+
+// IPSet is an immutable set of IPv4 and IPv6 networks stored in prefix tries,
+// so lookups take at most one step per address bit regardless of the number of networks.
+type IPSet struct {
+	v4, v6 *ipTrieNode
+	size   int
+}
+
+type ipTrieNode struct {
+	children [2]*ipTrieNode
+	terminal bool // the path to the node is a network of the set
+}
+
+// NewIPSet creates a set of the networks given as CIDRs or single IPs.
+func NewIPSet(networks ...string) (*IPSet, error) {
+	s := &IPSet{v4: &ipTrieNode{}, v6: &ipTrieNode{}}
+	for _, n := range networks {
+		prefix, err := netip.ParsePrefix(n)
+		if err != nil {
+			addr, aerr := netip.ParseAddr(n)
+			if aerr != nil {
+				return nil, fmt.Errorf("invalid network %q: %w", n, err)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		s.add(prefix)
+	}
+	return s, nil
+}
+
+func (s *IPSet) add(prefix netip.Prefix) {
+	addr := prefix.Addr()
+	bits := prefix.Bits()
+	node := s.v6
+	if addr.Is4In6() {
+		// ::ffff:a.b.c.d/n covers the IPv4 network a.b.c.d/(n-96)
+		addr, bits = addr.Unmap(), bits-96
+		if bits < 0 {
+			bits = 0
+		}
+	}
+	if addr.Is4() {
+		node = s.v4
+	}
+
+	b := addr.AsSlice()
+	for i := 0; i < bits; i++ {
+		bit := b[i/8] >> (7 - i%8) & 1
+		if node.children[bit] == nil {
+			node.children[bit] = &ipTrieNode{}
+		}
+		node = node.children[bit]
+	}
+	if !node.terminal {
+		node.terminal = true
+		s.size++
+	}
+}
+
+// Len returns the number of networks in the set.
+func (s *IPSet) Len() int {
+	return s.size
+}
+
+// Contains reports whether the IP is in one of the networks of the set.
+func (s *IPSet) Contains(ip string) bool {
+	addr := parseIP(ip)
+	return addr.IsValid() && s.contains(addr)
+}
+
+func (s *IPSet) contains(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	node := s.v6
+	if addr.Is4() {
+		node = s.v4
+	}
+
+	b := addr.AsSlice()
+	for i := 0; node != nil; i++ {
+		if node.terminal {
+			return true
+		}
+		if i == len(b)*8 {
+			return false
+		}
+		node = node.children[b[i/8]>>(7-i%8)&1]
+	}
+	return false
+}
+
+// IPFilterOptions represents the available IP filter options
+type IPFilterOptions struct {
+	// Allow are the allowed networks. If empty all IPs not denied are allowed.
+	Allow []string
+	// Deny are the denied networks, they take precedence over Allow.
+	Deny []string
+}
+
+// IPFilter allows or denies requests by their client IP, see ClientIP. It is
+// added to the Chain of the Configuration or of a Group. The lists can be
+// replaced at runtime by Update.
+type IPFilter struct {
+	lists atomic.Value // *ipLists
+}
+
+type ipLists struct {
+	allow, deny *IPSet
+}
+
+// NewIPFilter creates a new IPFilter, nil options allow all IPs. It fails if a network is invalid.
+func NewIPFilter(opts *IPFilterOptions) (*IPFilter, error) {
+	f := &IPFilter{}
+	if err := f.Update(opts); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Update replaces the lists atomically, nil options allow all IPs. On error the current lists are kept.
+func (f *IPFilter) Update(opts *IPFilterOptions) error {
+	if opts == nil {
+		opts = &IPFilterOptions{}
+	}
+	allow, err := NewIPSet(opts.Allow...)
+	if err != nil {
+		return err
+	}
+	deny, err := NewIPSet(opts.Deny...)
+	if err != nil {
+		return err
+	}
+	f.lists.Store(&ipLists{allow: allow, deny: deny})
+	return nil
+}
+
+// Allowed reports whether the IP passes the filter.
+func (f *IPFilter) Allowed(ip string) bool {
+	addr := parseIP(ip)
+	if !addr.IsValid() {
+		return false
+	}
+
+	l := f.lists.Load().(*ipLists)
+	if l.deny.contains(addr) {
+		return false
+	}
+	return l.allow.Len() == 0 || l.allow.contains(addr)
+}
+
+// Middleware rejects requests from denied IPs with ErrIPForbidden and status 403 through the ErrorHandler.
+func (f *IPFilter) Middleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !f.Allowed(clientIP(r)) {
+			HandleError(w, r, NewStatusError(http.StatusForbidden, ErrIPForbidden))
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,138 @@
+package rest
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIPSet(t *testing.T) {
+	s, err := NewIPSet("10.0.0.0/8", "192.168.1.0/24", "203.0.113.7", "2001:db8::/32", "::ffff:172.16.0.0/108", "10.1.0.0/16")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Len() != 6 {
+		t.Errorf("expected 6 networks, got %d", s.Len())
+	}
+
+	testcases := []struct {
+		ip       string
+		contains bool
+	}{
+		{"10.0.0.1", true},
+		{"10.255.255.255", true},
+		{"11.0.0.0", false},
+		{"192.168.1.200", true},
+		{"192.168.2.1", false},
+		{"203.0.113.7", true},
+		{"203.0.113.8", false},
+		{"::ffff:10.1.2.3", true},
+		{"172.16.5.5", true},
+		{"172.32.0.1", false},
+		{"2001:db8:1::1", true},
+		{"2001:db9::1", false},
+		{"[2001:db8::1]:443", true},
+		{"10.0.0.1:80", true},
+		{"garbage", false},
+	}
+	for _, tc := range testcases {
+		if got := s.Contains(tc.ip); got != tc.contains {
+			t.Errorf("%s: expected %t, got %t", tc.ip, tc.contains, got)
+		}
+	}
+
+	all, _ := NewIPSet("0.0.0.0/0")
+	if !all.Contains("1.2.3.4") || all.Contains("::1") {
+		t.Errorf("expected 0.0.0.0/0 to contain all IPv4 addresses only")
+	}
+
+	if _, err := NewIPSet("10.0.0.0/40"); err == nil {
+		t.Errorf("expected error for invalid network")
+	}
+}
+
+func TestIPFilter(t *testing.T) {
+	f, err := NewIPFilter(&IPFilterOptions{Allow: []string{"10.0.0.0/8", "192.0.2.0/24"}, Deny: []string{"10.0.0.66"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var reported error
+	s := New(Configuration{
+		Chain: []Middleware{NewProxyHeaders(&ProxyOptions{TrustedProxies: []string{"127.0.0.1"}})},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			reported = err
+			w.WriteHeader(ErrorStatus(err))
+		},
+	}, []HandlerRegistration{&Group{
+		BaseURI:   "/admin",
+		Registers: []Register{{Method: http.MethodGet, Path: "/stats", Handler: func(w http.ResponseWriter, r *http.Request) {}}},
+		Chain:     []Middleware{f.Middleware},
+	}})
+	s.Register([]Register{{Method: http.MethodGet, Path: "/public", Handler: func(w http.ResponseWriter, r *http.Request) {}}}, "")
+
+	testcases := []struct {
+		path   string
+		remote string
+		xff    string
+		code   int
+	}{
+		{"/admin/stats", "10.1.2.3:1234", "", 200},
+		{"/admin/stats", "192.0.2.5:1234", "", 200},
+		{"/admin/stats", "203.0.113.1:1234", "", 403},
+		{"/admin/stats", "10.0.0.66:1234", "", 403},
+		{"/admin/stats", "127.0.0.1:1234", "10.1.2.3", 200},
+		{"/admin/stats", "127.0.0.1:1234", "203.0.113.1", 403},
+		{"/admin/stats", "203.0.113.1:1234", "10.1.2.3", 403},
+		{"/public", "203.0.113.1:1234", "", 200},
+	}
+
+	for _, tc := range testcases {
+		reported = nil
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, tc.path, nil)
+		r.RemoteAddr = tc.remote
+		if tc.xff != "" {
+			r.Header.Set("X-Forwarded-For", tc.xff)
+		}
+		s.ServeHTTP(w, r)
+
+		if w.Code != tc.code {
+			t.Errorf("%s from %s (%s): expected status %d, got %d", tc.path, tc.remote, tc.xff, tc.code, w.Code)
+		}
+		if tc.code == 403 && !errors.Is(reported, ErrIPForbidden) {
+			t.Errorf("expected ErrIPForbidden, got %v", reported)
+		}
+	}
+
+	// hot swap
+	if err := f.Update(&IPFilterOptions{Allow: []string{"203.0.113.0/24"}}); err != nil {
+		t.Fatal(err)
+	}
+	if !f.Allowed("203.0.113.1") || f.Allowed("10.1.2.3") {
+		t.Errorf("expected updated lists")
+	}
+	if err := f.Update(&IPFilterOptions{Deny: []string{"invalid"}}); err == nil {
+		t.Errorf("expected error for invalid network")
+	}
+	if !f.Allowed("203.0.113.1") {
+		t.Errorf("expected lists to be kept after failed update")
+	}
+	if err := f.Update(&IPFilterOptions{Deny: []string{"203.0.113.1"}}); err != nil {
+		t.Fatal(err)
+	}
+	if f.Allowed("203.0.113.1") || !f.Allowed("10.1.2.3") {
+		t.Errorf("expected deny list only")
+	}
+	if err := f.Update(nil); err != nil || !f.Allowed("203.0.113.1") {
+		t.Errorf("expected nil options to allow all IPs, got %v", err)
+	}
+}
+
+func TestIPFilterNilOptions(t *testing.T) {
+	f, err := NewIPFilter(nil)
+	if err != nil || !f.Allowed("203.0.113.1") {
+		t.Errorf("expected nil options to allow all IPs, got %v", err)
+	}
+}
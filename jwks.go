@@ -0,0 +1,328 @@
+package rest
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// JWK is a key tokens are verified with. Key is a []byte secret for HS256,
+// a *rsa.PublicKey for RS256, a *ecdsa.PublicKey for ES256 or an
+// ed25519.PublicKey for EdDSA. An empty Algorithm matches all algorithms of the key type.
+type JWK struct {
+	KeyID     string
+	Algorithm string
+	Key       interface{}
+}
+
+// KeySet provides the keys tokens are verified with. Implementations must be safe for concurrent use.
+type KeySet interface {
+	// LookupKeys returns the keys with the key ID, all keys if kid is empty.
+	LookupKeys(ctx context.Context, kid string) ([]JWK, error)
+}
+
+// StaticKeySet is a fixed set of keys.
+type StaticKeySet []JWK
+
+// LookupKeys returns the keys with the key ID, all keys if kid is empty.
+func (s StaticKeySet) LookupKeys(ctx context.Context, kid string) ([]JWK, error) {
+	if kid == "" {
+		return s, nil
+	}
+	var keys []JWK
+	for _, k := range s {
+		if k.KeyID == kid {
+			keys = append(keys, k)
+		}
+	}
+	return keys, nil
+}
+
+// jsonWebKey is a key of a JWKS document as defined by RFC 7517.
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	K   string `json:"k"`
+}
+
+// ParseJWKS parses a JWKS document. Keys not used for signatures or of
+// unsupported types are skipped.
+func ParseJWKS(data []byte) (StaticKeySet, error) {
+	var doc struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("parsing JWKS: %w", err)
+	}
+
+	var keys StaticKeySet
+	for _, k := range doc.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("parsing JWKS key %q: %w", k.Kid, err)
+		}
+		if key != nil {
+			keys = append(keys, JWK{KeyID: k.Kid, Algorithm: k.Alg, Key: key})
+		}
+	}
+	return keys, nil
+}
+
+// LoadJWKSFile reads and parses a JWKS document from a file.
+func LoadJWKSFile(name string) (StaticKeySet, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return ParseJWKS(data)
+}
+
+// publicKey returns the key, nil if its type is not supported.
+func (k jsonWebKey) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "oct":
+		return decodeKeyParam(k.K)
+	case "RSA":
+		n, err := decodeKeyParam(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeKeyParam(k.E)
+		if err != nil {
+			return nil, err
+		}
+		exp := new(big.Int).SetBytes(e)
+		if !exp.IsInt64() || exp.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exp.Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, nil
+		}
+		x, err := decodeKeyParam(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeKeyParam(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, fmt.Errorf("invalid EC point")
+		}
+		return pub, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, nil
+		}
+		x, err := decodeKeyParam(k.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid Ed25519 key size")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, nil
+}
+
+func decodeKeyParam(s string) ([]byte, error) {
+	if s == "" {
+		return nil, fmt.Errorf("missing key parameter")
+	}
+	return base64.RawURLEncoding.DecodeString(s)
+}
+
+// RemoteKeySetOptions represents the available options of a RemoteKeySet
+type RemoteKeySetOptions struct {
+	// Client fetches the document. If nil http.DefaultClient is used.
+	Client *http.Client
+	// RefreshInterval is the time the keys are cached. If 0 one hour is used.
+	RefreshInterval time.Duration
+	// MinRefreshInterval limits the refreshes triggered by unknown key IDs. If 0 one minute is used.
+	MinRefreshInterval time.Duration
+	// Timeout limits a refresh, which is not bound to the request triggering it. If 0 ten seconds are used.
+	Timeout time.Duration
+}
+
+// RemoteKeySet fetches the keys from a JWKS URL and caches them. The keys are
+// refreshed after the RefreshInterval and when a token references an unknown
+// key ID, e.g. after a key rotation. If a refresh fails the cached keys are kept.
+// A single refresh runs at a time in the background; lookups only wait for it
+// if there are no keys yet or the key ID is unknown.
+type RemoteKeySet struct {
+	url  string
+	opts RemoteKeySetOptions
+	now  func() time.Time
+
+	mu         sync.Mutex
+	keys       StaticKeySet
+	fetched    time.Time
+	err        error         // error of the last refresh
+	refreshing chan struct{} // closed when the running refresh is done, nil if none
+}
+
+// NewRemoteKeySet creates a new RemoteKeySet for the JWKS URL.
+func NewRemoteKeySet(url string, opts *RemoteKeySetOptions) *RemoteKeySet {
+	s := &RemoteKeySet{url: url, now: time.Now}
+	if opts != nil {
+		s.opts = *opts
+	}
+	if s.opts.Client == nil {
+		s.opts.Client = http.DefaultClient
+	}
+	if s.opts.RefreshInterval <= 0 {
+		s.opts.RefreshInterval = time.Hour
+	}
+	if s.opts.MinRefreshInterval <= 0 {
+		s.opts.MinRefreshInterval = time.Minute
+	}
+	if s.opts.Timeout <= 0 {
+		s.opts.Timeout = 10 * time.Second
+	}
+	return s
+}
+
+// LookupKeys returns the keys with the key ID, all keys if kid is empty.
+func (s *RemoteKeySet) LookupKeys(ctx context.Context, kid string) ([]JWK, error) {
+	s.mu.Lock()
+	age := s.now().Sub(s.fetched)
+	if age >= s.opts.RefreshInterval || (s.keys == nil && age >= s.opts.MinRefreshInterval) {
+		s.refresh()
+	}
+	done := s.refreshing
+	if s.keys != nil {
+		// the cached keys are used while they are refreshed
+		done = nil
+	}
+	s.mu.Unlock()
+
+	if err := wait(ctx, done); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	keys, err := s.keys, s.err
+	s.mu.Unlock()
+	if keys == nil {
+		return nil, err
+	}
+
+	found, _ := keys.LookupKeys(ctx, kid)
+	if len(found) > 0 || kid == "" {
+		return found, nil
+	}
+
+	// the key may have been rotated
+	s.mu.Lock()
+	if s.now().Sub(s.fetched) >= s.opts.MinRefreshInterval {
+		s.refresh()
+	}
+	done = s.refreshing
+	s.mu.Unlock()
+	if done == nil {
+		return nil, nil
+	}
+
+	if err := wait(ctx, done); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	keys = s.keys
+	s.mu.Unlock()
+	return keys.LookupKeys(ctx, kid)
+}
+
+// wait waits until done is closed or the context is done. A nil done returns immediately.
+func wait(ctx context.Context, done <-chan struct{}) error {
+	if done == nil {
+		return nil
+	}
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// refresh starts fetching the document unless a refresh is running. It must
+// be called with mu held. The time of the attempt is recorded even if it
+// fails, so an unavailable URL is not requested on every lookup.
+func (s *RemoteKeySet) refresh() {
+	if s.refreshing != nil {
+		return
+	}
+	s.fetched = s.now()
+	done := make(chan struct{})
+	s.refreshing = done
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), s.opts.Timeout)
+		defer cancel()
+		keys, err := s.fetch(ctx)
+
+		s.mu.Lock()
+		if err == nil {
+			s.keys = keys
+		}
+		s.err = err
+		s.refreshing = nil
+		s.mu.Unlock()
+		close(done)
+	}()
+}
+
+// fetch fetches and parses the document.
+func (s *RemoteKeySet) fetch(ctx context.Context) (StaticKeySet, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.opts.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching JWKS: unexpected status %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("fetching JWKS: %w", err)
+	}
+
+	keys, err := ParseJWKS(data)
+	if err != nil {
+		return nil, err
+	}
+	if keys == nil {
+		keys = StaticKeySet{}
+	}
+	return keys, nil
+}
@@ -0,0 +1,238 @@
+package rest
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// jwksDocument encodes the keys as JWKS document.
+func jwksDocument(t *testing.T, keys StaticKeySet) []byte {
+	enc := base64.RawURLEncoding.EncodeToString
+	var doc struct {
+		Keys []map[string]string `json:"keys"`
+	}
+	for _, k := range keys {
+		jwk := map[string]string{"kid": k.KeyID, "alg": k.Algorithm}
+		switch key := k.Key.(type) {
+		case []byte:
+			jwk["kty"], jwk["k"] = "oct", enc(key)
+		case *rsa.PublicKey:
+			jwk["kty"], jwk["n"], jwk["e"] = "RSA", enc(key.N.Bytes()), enc(big.NewInt(int64(key.E)).Bytes())
+		case *ecdsa.PublicKey:
+			jwk["kty"], jwk["crv"], jwk["x"], jwk["y"] = "EC", "P-256", enc(key.X.Bytes()), enc(key.Y.Bytes())
+		case ed25519.PublicKey:
+			jwk["kty"], jwk["crv"], jwk["x"] = "OKP", "Ed25519", enc(key)
+		}
+		doc.Keys = append(doc.Keys, jwk)
+	}
+	b, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestParseJWKS(t *testing.T) {
+	signers, keys := newTestSigners(t)
+
+	doc := jwksDocument(t, keys)
+	// keys for encryption and of unsupported curves are skipped
+	doc = append(doc[:len(doc)-2], []byte(`,{"kty":"RSA","use":"enc","kid":"enc","n":"AQ","e":"AQ"},{"kty":"EC","crv":"P-521","kid":"p521"}]}`)...)
+
+	name := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(name, doc, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := LoadJWKSFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed) != len(keys) {
+		t.Fatalf("expected %d keys, got %d", len(keys), len(parsed))
+	}
+
+	h := NewJWT(&JWTOptions{Keys: parsed})(func(w http.ResponseWriter, r *http.Request) {})
+	for _, signer := range signers {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set(headerAuthz, "Bearer "+signer.sign(t, map[string]interface{}{"sub": "alice"}))
+		h(w, r)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: expected status 200, got %d: %s", signer.alg, w.Code, w.Body.String())
+		}
+	}
+
+	invalid := []string{
+		`{"keys":`,
+		`{"keys":[{"kty":"EC","crv":"P-256","x":"AQ","y":"AQ"}]}`,
+		`{"keys":[{"kty":"OKP","crv":"Ed25519","x":"AQ"}]}`,
+		`{"keys":[{"kty":"RSA","n":"AQ"}]}`,
+	}
+	for _, doc := range invalid {
+		if _, err := ParseJWKS([]byte(doc)); err == nil {
+			t.Errorf("expected error for %s", doc)
+		}
+	}
+}
+
+func TestRemoteKeySet(t *testing.T) {
+	_, keys := newTestSigners(t)
+
+	var (
+		mu       sync.Mutex
+		fetches  int
+		served   = keys[:1]
+		failures bool
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		fetches++
+		if failures {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write(jwksDocument(t, served))
+	}))
+	defer server.Close()
+
+	now := time.Unix(0, 0)
+	ks := NewRemoteKeySet(server.URL, &RemoteKeySetOptions{RefreshInterval: time.Hour, MinRefreshInterval: time.Minute})
+	ks.now = func() time.Time { return now }
+
+	lookup := func(kid string, expectedKeys, expectedFetches int) {
+		t.Helper()
+		found, err := ks.LookupKeys(context.Background(), kid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		waitRefresh(ks)
+		mu.Lock()
+		defer mu.Unlock()
+		if len(found) != expectedKeys || fetches != expectedFetches {
+			t.Errorf("kid %q: expected %d keys after %d fetches, got %d after %d", kid, expectedKeys, expectedFetches, len(found), fetches)
+		}
+	}
+
+	lookup("hs", 1, 1)
+	lookup("hs", 1, 1)
+	// unknown kids trigger a refresh at most every MinRefreshInterval
+	lookup("rs", 0, 1)
+	mu.Lock()
+	served = keys[:2]
+	mu.Unlock()
+	now = now.Add(time.Minute)
+	lookup("rs", 1, 2)
+	lookup("es", 0, 2)
+	// the keys are refreshed after the RefreshInterval and kept if it fails
+	mu.Lock()
+	failures = true
+	mu.Unlock()
+	now = now.Add(time.Hour)
+	lookup("", 2, 3)
+	lookup("", 2, 3)
+}
+
+// waitRefresh waits for the background refresh of the key set.
+func waitRefresh(ks *RemoteKeySet) {
+	ks.mu.Lock()
+	done := ks.refreshing
+	ks.mu.Unlock()
+	if done != nil {
+		<-done
+	}
+}
+
+func TestRemoteKeySetSlowRefresh(t *testing.T) {
+	_, keys := newTestSigners(t)
+
+	block := make(chan struct{})
+	var fetches int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&fetches, 1) > 1 {
+			<-block
+		}
+		w.Write(jwksDocument(t, keys[:1]))
+	}))
+	defer server.Close()
+	defer close(block)
+
+	now := time.Unix(0, 0)
+	ks := NewRemoteKeySet(server.URL, nil)
+	ks.now = func() time.Time { return now }
+
+	// concurrent lookups share a single fetch
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if found, err := ks.LookupKeys(context.Background(), "hs"); err != nil || len(found) != 1 {
+				t.Errorf("expected 1 key, got %d (%v)", len(found), err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Fatalf("expected 1 fetch, got %d", n)
+	}
+
+	// the cached keys are served while a slow refresh is running
+	now = now.Add(time.Hour)
+	if found, err := ks.LookupKeys(context.Background(), "hs"); err != nil || len(found) != 1 {
+		t.Errorf("expected cached key, got %d (%v)", len(found), err)
+	}
+
+	// a cancelled lookup waiting for an unknown kid does not abort the refresh
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := ks.LookupKeys(ctx, "rs"); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	ks.mu.Lock()
+	running := ks.refreshing != nil
+	ks.mu.Unlock()
+	if !running || atomic.LoadInt32(&fetches) > 2 {
+		t.Errorf("expected the refresh to keep running, got %d fetches", atomic.LoadInt32(&fetches))
+	}
+}
+
+func TestRemoteKeySetUnavailable(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	var reported error
+	s := New(Configuration{
+		Chain: []Middleware{NewJWT(&JWTOptions{Keys: NewRemoteKeySet(server.URL, nil)})},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			reported = err
+			w.WriteHeader(ErrorStatus(err))
+		},
+	}, nil)
+	s.Register([]Register{{Method: http.MethodGet, Path: "/", Handler: func(w http.ResponseWriter, r *http.Request) {}}}, "")
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(headerAuthz, "Bearer "+testSigner{alg: AlgHS256, hs: []byte("secret")}.sign(t, map[string]interface{}{}))
+	s.ServeHTTP(w, r)
+
+	if w.Code != http.StatusInternalServerError || reported == nil {
+		t.Errorf("expected status 500, got %d (%v)", w.Code, reported)
+	}
+}
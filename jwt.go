@@ -0,0 +1,407 @@
+package rest
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const headerWWWAuthenticate = "WWW-Authenticate"
+
+// Signing algorithms supported by the JWT middleware.
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgES256 = "ES256"
+	AlgEdDSA = "EdDSA"
+)
+
+// Errors reported with status 401 by the JWT middleware.
+var (
+	ErrMissingToken = errors.New("missing bearer token")
+	ErrInvalidToken = errors.New("invalid token")
+	ErrTokenExpired = errors.New("token expired")
+)
+
+// claimsKey defines the context key of the claims of a verified token
+type claimsKey struct{}
+
+// JWTOptions represents the available JWT options
+type JWTOptions struct {
+	// Keys provides the keys tokens are verified with.
+	Keys KeySet
+	// Algorithms are the accepted signing algorithms. If empty all supported ones are accepted.
+	Algorithms []string
+	// Issuer, if set, must match the iss claim.
+	Issuer string
+	// Audience, if set, must be contained in the aud claim.
+	Audience string
+	// ClockSkew is the tolerance applied to the exp, nbf and iat claims.
+	ClockSkew time.Duration
+	// Realm is sent in the WWW-Authenticate header.
+	Realm string
+}
+
+// NewJWT creates a middleware authenticating requests by a JWT bearer token.
+// Tokens must be signed with an accepted algorithm by a key of the KeySet and
+// be valid at the time of the request. The claims of the token are stored in
+// the context, see JWTClaims. Requests without a valid token are answered with
+// status 401 and a WWW-Authenticate header through the ErrorHandler. It panics
+// if the Keys are nil.
+func NewJWT(opts *JWTOptions) Middleware {
+	return newJWT(opts, time.Now)
+}
+
+func newJWT(opts *JWTOptions, now func() time.Time) Middleware {
+	v := newJWTVerifier(opts, now)
+
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			token, ok := bearerToken(r)
+			if !ok {
+				w.Header().Set(headerWWWAuthenticate, v.challenge(nil))
+				HandleError(w, r, NewStatusError(http.StatusUnauthorized, ErrMissingToken))
+				return
+			}
+
+			claims, err := v.verify(r.Context(), token)
+			if err != nil {
+				if errors.Is(err, ErrInvalidToken) || errors.Is(err, ErrTokenExpired) {
+					w.Header().Set(headerWWWAuthenticate, v.challenge(err))
+					err = NewStatusError(http.StatusUnauthorized, err)
+				}
+				HandleError(w, r, err)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), claimsKey{}, claims)
+			next(w, r.WithContext(withPrincipal(ctx, claims.identity())))
+		}
+	}
+}
+
+// NewJWTAuthenticator creates an Authenticator for JWT bearer tokens to be
+// combined with other schemes by the authentication middleware. The claims
+// of the token are the Attributes of the principal. It panics if the Keys are nil.
+func NewJWTAuthenticator(opts *JWTOptions) Authenticator {
+	return &jwtAuthenticator{newJWTVerifier(opts, time.Now)}
+}
+
+type jwtAuthenticator struct {
+	v *jwtVerifier
+}
+
+func (a *jwtAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	token, ok := bearerToken(r)
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+
+	claims, err := a.v.verify(r.Context(), token)
+	if err != nil {
+		if errors.Is(err, ErrInvalidToken) || errors.Is(err, ErrTokenExpired) {
+			err = NewStatusError(http.StatusUnauthorized, err)
+		}
+		return nil, err
+	}
+	return claims.identity(), nil
+}
+
+func (a *jwtAuthenticator) Challenge() string {
+	return a.v.challenge(nil)
+}
+
+// bearerToken returns the token of the Authorization header.
+func bearerToken(r *http.Request) (string, bool) {
+	authz := r.Header.Get(headerAuthz)
+	if len(authz) < 7 || !strings.EqualFold(authz[:7], "Bearer ") {
+		return "", false
+	}
+	token := strings.TrimSpace(authz[7:])
+	return token, token != ""
+}
+
+type jwtVerifier struct {
+	opts JWTOptions
+	now  func() time.Time
+}
+
+func newJWTVerifier(opts *JWTOptions, now func() time.Time) *jwtVerifier {
+	v := &jwtVerifier{now: now}
+	if opts != nil {
+		v.opts = *opts
+	}
+	if v.opts.Keys == nil {
+		panic("rest: JWTOptions.Keys is required")
+	}
+	if len(v.opts.Algorithms) == 0 {
+		v.opts.Algorithms = []string{AlgHS256, AlgRS256, AlgES256, AlgEdDSA}
+	}
+	return v
+}
+
+// challenge returns the WWW-Authenticate header of RFC 6750 for the error.
+func (v *jwtVerifier) challenge(err error) string {
+	var params []string
+	if v.opts.Realm != "" {
+		params = append(params, fmt.Sprintf("realm=%q", v.opts.Realm))
+	}
+	if err != nil {
+		params = append(params, `error="invalid_token"`, fmt.Sprintf("error_description=%q", err.Error()))
+	}
+	if len(params) == 0 {
+		return "Bearer"
+	}
+	return "Bearer " + strings.Join(params, ", ")
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// verify checks the signature and the registered claims of the token and returns its claims.
+func (v *jwtVerifier) verify(ctx context.Context, token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed token", ErrInvalidToken)
+	}
+
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: malformed header", ErrInvalidToken)
+	}
+	if !containsString(v.opts.Algorithms, header.Alg) {
+		return nil, fmt.Errorf("%w: algorithm %q not accepted", ErrInvalidToken, header.Alg)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed signature", ErrInvalidToken)
+	}
+
+	keys, err := v.opts.Keys.LookupKeys(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	signed := []byte(parts[0] + "." + parts[1])
+	verified := false
+	for _, k := range keys {
+		if (k.Algorithm == "" || k.Algorithm == header.Alg) && verifySignature(header.Alg, k.Key, signed, sig) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, fmt.Errorf("%w: invalid signature", ErrInvalidToken)
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil || claims == nil {
+		return nil, fmt.Errorf("%w: malformed claims", ErrInvalidToken)
+	}
+	return claims, v.validate(claims)
+}
+
+// validate checks the time, issuer and audience claims.
+func (v *jwtVerifier) validate(c Claims) error {
+	now, skew := v.now(), v.opts.ClockSkew
+
+	for _, name := range []string{"exp", "nbf", "iat"} {
+		if _, ok := c[name]; !ok {
+			continue
+		}
+		if _, ok := c.Time(name); !ok {
+			return fmt.Errorf("%w: invalid %s claim", ErrInvalidToken, name)
+		}
+	}
+	if exp, ok := c.Time("exp"); ok && !now.Before(exp.Add(skew)) {
+		return ErrTokenExpired
+	}
+	if nbf, ok := c.Time("nbf"); ok && now.Add(skew).Before(nbf) {
+		return fmt.Errorf("%w: token not valid yet", ErrInvalidToken)
+	}
+	if iat, ok := c.Time("iat"); ok && now.Add(skew).Before(iat) {
+		return fmt.Errorf("%w: token issued in the future", ErrInvalidToken)
+	}
+	if v.opts.Issuer != "" && c.Issuer() != v.opts.Issuer {
+		return fmt.Errorf("%w: unexpected issuer", ErrInvalidToken)
+	}
+	if v.opts.Audience != "" && !containsString(c.Audience(), v.opts.Audience) {
+		return fmt.Errorf("%w: unexpected audience", ErrInvalidToken)
+	}
+	return nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	d := json.NewDecoder(strings.NewReader(string(b)))
+	d.UseNumber()
+	return d.Decode(v)
+}
+
+// verifySignature verifies sig of signed with the key, which must be of the type of the algorithm.
+func verifySignature(alg string, key interface{}, signed, sig []byte) bool {
+	sum := sha256.Sum256(signed)
+
+	switch alg {
+	case AlgHS256:
+		secret, ok := key.([]byte)
+		if !ok {
+			return false
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(signed)
+		return hmac.Equal(sig, mac.Sum(nil))
+	case AlgRS256:
+		pub, ok := key.(*rsa.PublicKey)
+		return ok && rsa.VerifyPKCS1v15(pub, crypto.SHA256, sum[:], sig) == nil
+	case AlgES256:
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(sig) != 64 {
+			return false
+		}
+		r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+		return ecdsa.Verify(pub, sum[:], r, s)
+	case AlgEdDSA:
+		pub, ok := key.(ed25519.PublicKey)
+		return ok && len(pub) == ed25519.PublicKeySize && ed25519.Verify(pub, signed, sig)
+	}
+	return false
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Claims are the claims of a verified JWT.
+type Claims map[string]interface{}
+
+// JWTClaims returns the claims of the token the request was authenticated with, nil if there is none.
+func JWTClaims(ctx context.Context) Claims {
+	c, _ := ctx.Value(claimsKey{}).(Claims)
+	return c
+}
+
+// String returns the claim name if it is a string.
+func (c Claims) String(name string) (string, bool) {
+	s, ok := c[name].(string)
+	return s, ok
+}
+
+// Strings returns the claim name if it is a string or an array of strings.
+func (c Claims) Strings(name string) []string {
+	switch v := c[name].(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, e := range v {
+			if s, ok := e.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// Int64 returns the claim name if it is an integer.
+func (c Claims) Int64(name string) (int64, bool) {
+	n, ok := c[name].(json.Number)
+	if !ok {
+		return 0, false
+	}
+	i, err := n.Int64()
+	if err != nil {
+		f, err := n.Float64()
+		return int64(f), err == nil
+	}
+	return i, true
+}
+
+// Bool returns the claim name if it is a boolean.
+func (c Claims) Bool(name string) (bool, bool) {
+	b, ok := c[name].(bool)
+	return b, ok
+}
+
+// maxNumericDate bounds NumericDates to the seconds a float64 represents exactly.
+const maxNumericDate = 1 << 53
+
+// Time returns the claim name if it is a NumericDate.
+func (c Claims) Time(name string) (time.Time, bool) {
+	n, ok := c[name].(json.Number)
+	if !ok {
+		return time.Time{}, false
+	}
+	f, err := n.Float64()
+	if err != nil || math.Abs(f) >= maxNumericDate {
+		return time.Time{}, false
+	}
+	sec := math.Floor(f)
+	return time.Unix(int64(sec), int64((f-sec)*float64(time.Second))), true
+}
+
+// Subject returns the sub claim.
+func (c Claims) Subject() string {
+	s, _ := c.String("sub")
+	return s
+}
+
+// Issuer returns the iss claim.
+func (c Claims) Issuer() string {
+	s, _ := c.String("iss")
+	return s
+}
+
+// Audience returns the aud claim.
+func (c Claims) Audience() []string {
+	return c.Strings("aud")
+}
+
+// ExpiresAt returns the exp claim, the zero time if the token does not expire.
+func (c Claims) ExpiresAt() time.Time {
+	t, _ := c.Time("exp")
+	return t
+}
+
+// identity returns the principal of the token.
+func (c Claims) identity() *Identity {
+	return &Identity{
+		ID:         c.Subject(),
+		Scheme:     "Bearer",
+		Roles:      c.Strings("roles"),
+		Scopes:     c.Scopes(),
+		Attributes: c,
+	}
+}
+
+// Scopes returns the space separated scope claim or the scp claim.
+func (c Claims) Scopes() []string {
+	if s, ok := c.String("scope"); ok {
+		return strings.Fields(s)
+	}
+	return c.Strings("scp")
+}
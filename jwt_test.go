@@ -0,0 +1,260 @@
+package rest
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testSigner struct {
+	alg string
+	kid string
+	key crypto.Signer // nil for HS256
+	hs  []byte
+}
+
+func (s testSigner) sign(t *testing.T, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": s.alg, "kid": s.kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	var sig []byte
+	sum := sha256.Sum256([]byte(signed))
+	switch s.alg {
+	case AlgHS256:
+		mac := hmac.New(sha256.New, s.hs)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case AlgRS256:
+		sig, _ = rsa.SignPKCS1v15(rand.Reader, s.key.(*rsa.PrivateKey), crypto.SHA256, sum[:])
+	case AlgES256:
+		r, ss, err := ecdsa.Sign(rand.Reader, s.key.(*ecdsa.PrivateKey), sum[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		ss.FillBytes(sig[32:])
+	case AlgEdDSA:
+		sig = ed25519.Sign(s.key.(ed25519.PrivateKey), []byte(signed))
+	default:
+		sig = []byte("x")
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func newTestSigners(t *testing.T) ([]testSigner, StaticKeySet) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := []byte("secret")
+
+	signers := []testSigner{
+		{alg: AlgHS256, kid: "hs", hs: secret},
+		{alg: AlgRS256, kid: "rs", key: rsaKey},
+		{alg: AlgES256, kid: "es", key: ecKey},
+		{alg: AlgEdDSA, kid: "ed", key: edKey},
+	}
+	keys := StaticKeySet{
+		{KeyID: "hs", Algorithm: AlgHS256, Key: secret},
+		{KeyID: "rs", Key: &rsaKey.PublicKey},
+		{KeyID: "es", Key: &ecKey.PublicKey},
+		{KeyID: "ed", Key: edKey.Public()},
+	}
+	return signers, keys
+}
+
+func TestJWT(t *testing.T) {
+	signers, keys := newTestSigners(t)
+	hs, rs := signers[0], signers[1]
+	now := time.Unix(1700000000, 0)
+
+	valid := func(extra map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"sub": "alice",
+			"iss": "https://issuer",
+			"aud": []string{"api", "other"},
+			"exp": now.Add(time.Minute).Unix(),
+			"iat": now.Unix(),
+		}
+		for k, v := range extra {
+			c[k] = v
+		}
+		return c
+	}
+
+	testcases := []struct {
+		test      string
+		authz     string
+		code      int
+		err       error
+		challenge string
+	}{
+		{"HS256", "Bearer " + hs.sign(t, valid(nil)), 200, nil, ""},
+		{"RS256", "Bearer " + rs.sign(t, valid(nil)), 200, nil, ""},
+		{"ES256", "Bearer " + signers[2].sign(t, valid(nil)), 200, nil, ""},
+		{"EdDSA", "bearer " + signers[3].sign(t, valid(nil)), 200, nil, ""},
+		{"missing", "", 401, ErrMissingToken, `Bearer realm="api"`},
+		{"basic", "Basic YTpi", 401, ErrMissingToken, `Bearer realm="api"`},
+		{"malformed", "Bearer abc", 401, ErrInvalidToken, `Bearer realm="api", error="invalid_token", error_description="invalid token: malformed token"`},
+		{"none", "Bearer " + testSigner{alg: "none"}.sign(t, valid(nil)), 401, ErrInvalidToken, ""},
+		{"wrong key", "Bearer " + testSigner{alg: AlgHS256, kid: "hs", hs: []byte("other")}.sign(t, valid(nil)), 401, ErrInvalidToken, ""},
+		{"unknown kid", "Bearer " + testSigner{alg: AlgHS256, kid: "x", hs: []byte("secret")}.sign(t, valid(nil)), 401, ErrInvalidToken, ""},
+		{"algorithm confusion", "Bearer " + testSigner{alg: AlgHS256, kid: "rs", hs: []byte("secret")}.sign(t, valid(nil)), 401, ErrInvalidToken, ""},
+		{"expired", "Bearer " + hs.sign(t, valid(map[string]interface{}{"exp": now.Add(-time.Minute).Unix()})), 401, ErrTokenExpired, `Bearer realm="api", error="invalid_token", error_description="token expired"`},
+		{"expired within skew", "Bearer " + hs.sign(t, valid(map[string]interface{}{"exp": now.Add(-5 * time.Second).Unix()})), 200, nil, ""},
+		{"not valid yet", "Bearer " + hs.sign(t, valid(map[string]interface{}{"nbf": now.Add(time.Minute).Unix()})), 401, ErrInvalidToken, ""},
+		{"not valid yet within skew", "Bearer " + hs.sign(t, valid(map[string]interface{}{"nbf": now.Add(5 * time.Second).Unix()})), 200, nil, ""},
+		{"not valid yet far future", "Bearer " + hs.sign(t, valid(map[string]interface{}{"nbf": 1e19})), 401, ErrInvalidToken, ""},
+		{"expiry out of range", "Bearer " + hs.sign(t, valid(map[string]interface{}{"exp": 1e19})), 401, ErrInvalidToken, ""},
+		{"issued at not a date", "Bearer " + hs.sign(t, valid(map[string]interface{}{"iat": "now"})), 401, ErrInvalidToken, ""},
+		{"expiry far future", "Bearer " + hs.sign(t, valid(map[string]interface{}{"exp": 1e15})), 200, nil, ""},
+		{"wrong issuer", "Bearer " + hs.sign(t, valid(map[string]interface{}{"iss": "https://evil"})), 401, ErrInvalidToken, ""},
+		{"wrong audience", "Bearer " + hs.sign(t, valid(map[string]interface{}{"aud": "other"})), 401, ErrInvalidToken, ""},
+		{"audience string", "Bearer " + hs.sign(t, valid(map[string]interface{}{"aud": "api"})), 200, nil, ""},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.test, func(t *testing.T) {
+			var (
+				reported error
+				claims   Claims
+			)
+			s := New(Configuration{
+				Chain: []Middleware{newJWT(&JWTOptions{
+					Keys:      keys,
+					Issuer:    "https://issuer",
+					Audience:  "api",
+					ClockSkew: 10 * time.Second,
+					Realm:     "api",
+				}, func() time.Time { return now })},
+				ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+					reported = err
+					w.WriteHeader(ErrorStatus(err))
+				},
+			}, nil)
+			s.Register([]Register{{
+				Method: http.MethodGet,
+				Path:   "/me",
+				Handler: func(w http.ResponseWriter, r *http.Request) {
+					claims = JWTClaims(r.Context())
+				},
+			}}, "")
+
+			w := httptest.NewRecorder()
+			r, _ := http.NewRequest(http.MethodGet, "/me", nil)
+			if tc.authz != "" {
+				r.Header.Set(headerAuthz, tc.authz)
+			}
+			s.ServeHTTP(w, r)
+
+			if w.Code != tc.code {
+				t.Errorf("expected status %d, got %d (%v)", tc.code, w.Code, reported)
+			}
+			if tc.err != nil && !errors.Is(reported, tc.err) {
+				t.Errorf("expected error %v, got %v", tc.err, reported)
+			}
+			if tc.code == 200 && claims.Subject() != "alice" {
+				t.Errorf("expected subject alice, got %v", claims)
+			}
+			if tc.code == 401 && !strings.HasPrefix(w.Header().Get(headerWWWAuthenticate), "Bearer") {
+				t.Errorf("expected Bearer challenge, got %q", w.Header().Get(headerWWWAuthenticate))
+			}
+			if tc.challenge != "" && w.Header().Get(headerWWWAuthenticate) != tc.challenge {
+				t.Errorf("expected challenge %q, got %q", tc.challenge, w.Header().Get(headerWWWAuthenticate))
+			}
+		})
+	}
+}
+
+func TestJWTAlgorithms(t *testing.T) {
+	signers, keys := newTestSigners(t)
+	mw := NewJWT(&JWTOptions{Keys: keys, Algorithms: []string{AlgRS256}})
+	h := mw(func(w http.ResponseWriter, r *http.Request) {})
+
+	for _, signer := range signers {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set(headerAuthz, "Bearer "+signer.sign(t, map[string]interface{}{"sub": "alice"}))
+		h(w, r)
+
+		expected := http.StatusUnauthorized
+		if signer.alg == AlgRS256 {
+			expected = http.StatusOK
+		}
+		if w.Code != expected {
+			t.Errorf("%s: expected status %d, got %d", signer.alg, expected, w.Code)
+		}
+	}
+}
+
+func TestClaims(t *testing.T) {
+	var c Claims
+	err := decodeSegment(base64.RawURLEncoding.EncodeToString([]byte(`{
+		"sub": "alice",
+		"iss": "issuer",
+		"aud": "api",
+		"exp": 1700000000,
+		"iat": 1699999999.5,
+		"scope": "read write",
+		"roles": ["admin", 1, "user"],
+		"tenant": 42,
+		"admin": true
+	}`)), &c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Subject() != "alice" || c.Issuer() != "issuer" {
+		t.Errorf("unexpected subject or issuer: %q, %q", c.Subject(), c.Issuer())
+	}
+	if aud := c.Audience(); len(aud) != 1 || aud[0] != "api" {
+		t.Errorf("unexpected audience %v", aud)
+	}
+	if !c.ExpiresAt().Equal(time.Unix(1700000000, 0)) {
+		t.Errorf("unexpected expiry %v", c.ExpiresAt())
+	}
+	if iat, _ := c.Time("iat"); !iat.Equal(time.Unix(1699999999, 5e8)) {
+		t.Errorf("unexpected iat %v", iat)
+	}
+	if scopes := c.Scopes(); len(scopes) != 2 || scopes[1] != "write" {
+		t.Errorf("unexpected scopes %v", scopes)
+	}
+	if roles := c.Strings("roles"); len(roles) != 2 || roles[1] != "user" {
+		t.Errorf("unexpected roles %v", roles)
+	}
+	if n, ok := c.Int64("tenant"); !ok || n != 42 {
+		t.Errorf("unexpected tenant %d, %t", n, ok)
+	}
+	if b, ok := c.Bool("admin"); !ok || !b {
+		t.Errorf("unexpected admin %t, %t", b, ok)
+	}
+	if _, ok := c.String("tenant"); ok {
+		t.Errorf("expected tenant not to be a string")
+	}
+	if JWTClaims(httptest.NewRequest(http.MethodGet, "/", nil).Context()) != nil {
+		t.Errorf("expected no claims without token")
+	}
+}
@@ -0,0 +1,31 @@
+// Package password provides a rest.PasswordVerifier for bcrypt hashes. It
+// lives in its own package, so the rest package stays free of dependencies.
+package password
+
+import (
+	"crypto/rand"
+
+	"github.com/doozer-de/rest"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// BcryptVerifier checks a password against its bcrypt hash.
+var BcryptVerifier rest.PasswordVerifier = bcrypt.CompareHashAndPassword
+
+// BcryptDummyHash returns the bcrypt hash of a random password with the cost,
+// to be used as DummyHash of the rest.BasicOptions. The cost should be the one
+// of the stored hashes, bcrypt.DefaultCost if it is 0.
+func BcryptDummyHash(cost int) []byte {
+	if cost == 0 {
+		cost = bcrypt.DefaultCost
+	}
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		panic("rest: reading random password: " + err.Error())
+	}
+	hash, err := bcrypt.GenerateFromPassword(random, cost)
+	if err != nil {
+		panic("rest: hashing random password: " + err.Error())
+	}
+	return hash
+}
@@ -0,0 +1,62 @@
+package password
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/doozer-de/rest"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type credentials map[string][]byte
+
+func (c credentials) LookupCredentials(ctx context.Context, username string) ([]byte, *rest.Identity, error) {
+	hash, ok := c[username]
+	if !ok {
+		return nil, nil, nil
+	}
+	return hash, &rest.Identity{}, nil
+}
+
+func TestBcrypt(t *testing.T) {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	dummy := BcryptDummyHash(bcrypt.MinCost)
+	if cost, err := bcrypt.Cost(dummy); err != nil || cost != bcrypt.MinCost {
+		t.Fatalf("Got: cost %d (%v) - want: %d", cost, err, bcrypt.MinCost)
+	}
+
+	var verified [][]byte
+	basic := rest.NewBasicAuthenticator(&rest.BasicOptions{
+		Store: credentials{"alice": hash},
+		Verify: func(hash, password []byte) error {
+			verified = append(verified, hash)
+			return BcryptVerifier(hash, password)
+		},
+		DummyHash: dummy,
+	})
+
+	testcases := []struct {
+		user, password string
+		ok             bool
+		hash           []byte
+	}{
+		{"alice", "secret", true, hash},
+		{"alice", "wrong", false, hash},
+		{"bob", "secret", false, dummy},
+	}
+	for _, tc := range testcases {
+		verified = nil
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.SetBasicAuth(tc.user, tc.password)
+		_, err := basic.Authenticate(r)
+		if ok := err == nil; ok != tc.ok || (!ok && !errors.Is(err, rest.ErrInvalidCredentials)) {
+			t.Errorf("%s/%s: Got: %v - want: ok %t", tc.user, tc.password, err, tc.ok)
+		}
+		if len(verified) != 1 || string(verified[0]) != string(tc.hash) {
+			t.Errorf("%s/%s: Got: %q - want: one verification against %q", tc.user, tc.password, verified, tc.hash)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package rest
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+const (
+	headerForwarded       = "Forwarded"
+	headerXForwardedFor   = "X-Forwarded-For"
+	headerXForwardedProto = "X-Forwarded-Proto"
+	headerXForwardedHost  = "X-Forwarded-Host"
+	headerXRealIP         = "X-Real-IP"
+)
+
+// PrivateNetworks are the loopback and private address ranges, e.g. to trust all proxies of an internal network.
+var PrivateNetworks = []string{"127.0.0.0/8", "10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "::1/128", "fc00::/7"}
+
+// clientIPKey defines the context key of the client IP
+type clientIPKey struct{}
+
+// ProxyOptions represents the available trusted proxy options
+type ProxyOptions struct {
+	// TrustedProxies are the IPs or CIDRs of proxies whose forwarding headers are trusted.
+	TrustedProxies []string
+}
+
+// NewProxyHeaders creates a middleware determining the client IP, scheme and
+// host of requests forwarded by trusted proxies. The Forwarded header (RFC 7239)
+// is preferred over X-Forwarded-For, X-Forwarded-Proto and X-Forwarded-Host,
+// followed by X-Real-IP. The forwarded addresses are walked from right to left
+// while they belong to trusted proxies, so addresses prepended by clients are
+// ignored. The scheme and host of the request URL are rewritten for URL
+// generation and the client IP is available by ClientIP. Headers of requests
+// from untrusted peers are ignored. It panics if a trusted proxy is invalid.
+func NewProxyHeaders(opts *ProxyOptions) Middleware {
+	if opts == nil {
+		opts = &ProxyOptions{}
+	}
+
+	p := &proxyHeaders{}
+	for _, s := range opts.TrustedProxies {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			addr, aerr := netip.ParseAddr(s)
+			if aerr != nil {
+				panic("rest: invalid trusted proxy " + s + ": " + err.Error())
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		p.trusted = append(p.trusted, prefix.Masked())
+	}
+
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			client, proto, host := p.resolve(r)
+			if proto != "" {
+				r.URL.Scheme = proto
+			}
+			if host != "" {
+				r.URL.Host = host
+				r.Host = host
+			}
+			if client.IsValid() {
+				r = r.WithContext(context.WithValue(r.Context(), clientIPKey{}, client.String()))
+			}
+			next(w, r)
+		}
+	}
+}
+
+type proxyHeaders struct {
+	trusted []netip.Prefix
+}
+
+func (p *proxyHeaders) isTrusted(addr netip.Addr) bool {
+	for _, prefix := range p.trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolve returns the client IP and the forwarded scheme and host, which are empty if not forwarded.
+func (p *proxyHeaders) resolve(r *http.Request) (client netip.Addr, proto, host string) {
+	client = parseIP(r.RemoteAddr)
+	if !client.IsValid() || !p.isTrusted(client) {
+		return client, "", ""
+	}
+
+	if fwd := r.Header.Values(headerForwarded); len(fwd) > 0 {
+		elements := parseForwarded(strings.Join(fwd, ","))
+		for i := len(elements) - 1; i >= 0; i-- {
+			addr := parseIP(elements[i]["for"])
+			if !addr.IsValid() {
+				// unknown or obfuscated, the hop before is the last known
+				break
+			}
+			client, proto, host = addr, elements[i]["proto"], elements[i]["host"]
+			if !p.isTrusted(addr) {
+				break
+			}
+		}
+		return client, strings.ToLower(proto), host
+	}
+
+	if xff := splitHeaderList(r.Header.Values(headerXForwardedFor)); len(xff) > 0 {
+		protos := splitHeaderList(r.Header.Values(headerXForwardedProto))
+		hosts := splitHeaderList(r.Header.Values(headerXForwardedHost))
+		for i := len(xff) - 1; i >= 0; i-- {
+			addr := parseIP(xff[i])
+			if !addr.IsValid() {
+				break
+			}
+			client = addr
+			proto, host = listEntry(protos, i, len(xff)), listEntry(hosts, i, len(xff))
+			if !p.isTrusted(addr) {
+				break
+			}
+		}
+		return client, strings.ToLower(proto), host
+	}
+
+	if addr := parseIP(r.Header.Get(headerXRealIP)); addr.IsValid() {
+		client = addr
+	}
+	return client, "", ""
+}
+
+// listEntry returns the entry of the list for the hop i of n hops. Lists of
+// another length are not set by every proxy, so the last entry is used, which
+// was added by the nearest proxy. Entries before it may be set by the client.
+func listEntry(list []string, i, n int) string {
+	if len(list) == n {
+		return list[i]
+	}
+	if len(list) > 0 {
+		return list[len(list)-1]
+	}
+	return ""
+}
+
+func splitHeaderList(values []string) []string {
+	var list []string
+	for _, v := range values {
+		for _, e := range strings.Split(v, ",") {
+			if e = strings.TrimSpace(e); e != "" {
+				list = append(list, e)
+			}
+		}
+	}
+	return list
+}
+
+// parseForwarded parses the elements of a Forwarded header into their lower case parameters.
+func parseForwarded(header string) []map[string]string {
+	var elements []map[string]string
+	for _, element := range splitQuoted(header, ',') {
+		params := map[string]string{}
+		for _, pair := range splitQuoted(element, ';') {
+			k, v, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if !ok {
+				continue
+			}
+			v = strings.TrimSpace(v)
+			if len(v) >= 2 && v[0] == '"' && v[len(v)-1] == '"' {
+				v = strings.ReplaceAll(v[1:len(v)-1], `\"`, `"`)
+			}
+			params[strings.ToLower(strings.TrimSpace(k))] = v
+		}
+		elements = append(elements, params)
+	}
+	return elements
+}
+
+// splitQuoted splits s at sep outside of quoted strings.
+func splitQuoted(s string, sep byte) []string {
+	var (
+		parts  []string
+		start  int
+		quoted bool
+	)
+	for i := 0; i < len(s); i++ {
+		switch {
+		case quoted && s[i] == '\\':
+			i++
+		case s[i] == '"':
+			quoted = !quoted
+		case !quoted && s[i] == sep:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// parseIP parses an IP with optional port and brackets, e.g. 192.0.2.1:80 or [2001:db8::1]:80.
+func parseIP(s string) netip.Addr {
+	s = strings.TrimSpace(s)
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}
+	}
+	return addr.Unmap()
+}
+
+// ClientIP returns the IP of the client determined by the proxy headers middleware, empty outside of it.
+func ClientIP(ctx context.Context) string {
+	ip, _ := ctx.Value(clientIPKey{}).(string)
+	return ip
+}
+
+// clientIP returns the ClientIP of the request, or the IP of its peer outside of the proxy headers middleware.
+func clientIP(r *http.Request) string {
+	if ip := ClientIP(r.Context()); ip != "" {
+		return ip
+	}
+	if addr := parseIP(r.RemoteAddr); addr.IsValid() {
+		return addr.String()
+	}
+	return r.RemoteAddr
+}
@@ -0,0 +1,97 @@
+package rest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestProxyHeaders(t *testing.T) {
+	testcases := []struct {
+		test    string
+		remote  string
+		headers map[string]string
+		ip      string
+		scheme  string
+		host    string
+	}{
+		{"direct", "203.0.113.7:1234", nil, "203.0.113.7", "", "api.example.com"},
+		{"untrusted peer", "203.0.113.7:1234", map[string]string{"X-Forwarded-For": "198.51.100.1", "X-Forwarded-Host": "evil.com"}, "203.0.113.7", "", "api.example.com"},
+		{"x-forwarded-for", "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "198.51.100.1", "X-Forwarded-Proto": "HTTPS", "X-Forwarded-Host": "www.example.com"}, "198.51.100.1", "https", "www.example.com"},
+		{"x-forwarded-for chain", "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "1.2.3.4, 198.51.100.1, 10.0.0.2"}, "198.51.100.1", "", "api.example.com"},
+		{"x-forwarded-for all trusted", "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "10.0.0.3, 10.0.0.2"}, "10.0.0.3", "", "api.example.com"},
+		{"x-forwarded-for spoofed garbage", "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "garbage, 198.51.100.1"}, "198.51.100.1", "", "api.example.com"},
+		{"x-forwarded per hop", "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "198.51.100.1, 10.0.0.2", "X-Forwarded-Proto": "https, http"}, "198.51.100.1", "https", "api.example.com"},
+		{"x-forwarded spoofed prefix", "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "198.51.100.1", "X-Forwarded-Proto": "http, https", "X-Forwarded-Host": "evil.com, www.example.com"}, "198.51.100.1", "https", "www.example.com"},
+		{"forwarded", "10.0.0.1:1234", map[string]string{"Forwarded": `for=198.51.100.1;proto=https;host=www.example.com`}, "198.51.100.1", "https", "www.example.com"},
+		{"forwarded chain", "10.0.0.1:1234", map[string]string{"Forwarded": `for=1.2.3.4, for="[2001:db8::1]:4711";proto=https, for=10.0.0.2;proto=http`}, "2001:db8::1", "https", "api.example.com"},
+		{"forwarded obfuscated", "10.0.0.1:1234", map[string]string{"Forwarded": `for=_hidden, for=10.0.0.2`}, "10.0.0.2", "", "api.example.com"},
+		{"forwarded preferred", "10.0.0.1:1234", map[string]string{"Forwarded": `for=198.51.100.1`, "X-Forwarded-For": "198.51.100.2"}, "198.51.100.1", "", "api.example.com"},
+		{"x-real-ip", "10.0.0.1:1234", map[string]string{"X-Real-IP": "198.51.100.1"}, "198.51.100.1", "", "api.example.com"},
+		{"single trusted ip", "192.0.2.10:1234", map[string]string{"X-Forwarded-For": "198.51.100.1"}, "198.51.100.1", "", "api.example.com"},
+		{"mapped ipv4", "[::ffff:10.0.0.1]:1234", map[string]string{"X-Forwarded-For": "198.51.100.1"}, "198.51.100.1", "", "api.example.com"},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.test, func(t *testing.T) {
+			var ip, scheme, host, key string
+			h := NewProxyHeaders(&ProxyOptions{TrustedProxies: []string{"10.0.0.0/8", "192.0.2.10"}})(func(w http.ResponseWriter, r *http.Request) {
+				ip, scheme, host, key = ClientIP(r.Context()), r.URL.Scheme, r.Host, KeyByIP(r)
+			})
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Host = "api.example.com"
+			r.URL.Scheme = ""
+			r.RemoteAddr = tc.remote
+			for k, v := range tc.headers {
+				r.Header.Set(k, v)
+			}
+			h(httptest.NewRecorder(), r)
+
+			if ip != tc.ip || key != tc.ip {
+				t.Errorf("expected client IP %q, got %q (key %q)", tc.ip, ip, key)
+			}
+			if scheme != tc.scheme {
+				t.Errorf("expected scheme %q, got %q", tc.scheme, scheme)
+			}
+			if host != tc.host {
+				t.Errorf("expected host %q, got %q", tc.host, host)
+			}
+		})
+	}
+}
+
+func TestClientIPWithoutProxyHeaders(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "[2001:db8::1]:80"
+
+	if ip := ClientIP(r.Context()); ip != "" {
+		t.Errorf("expected no client IP, got %q", ip)
+	}
+	if key := KeyByIP(r); key != "2001:db8::1" {
+		t.Errorf("expected the peer IP, got %q", key)
+	}
+}
+
+func TestProxyHeadersInvalidProxy(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected panic")
+		}
+	}()
+	NewProxyHeaders(&ProxyOptions{TrustedProxies: []string{"10.0.0.0/33"}})
+}
+
+func TestProxyHeadersNilOptions(t *testing.T) {
+	var ip string
+	h := NewProxyHeaders(nil)(func(w http.ResponseWriter, r *http.Request) { ip = ClientIP(r.Context()) })
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	r.Header.Set("X-Forwarded-For", "198.51.100.1")
+	h(httptest.NewRecorder(), r)
+
+	if ip != "10.0.0.1" {
+		t.Errorf("expected the peer IP without trusted proxies, got %q", ip)
+	}
+}
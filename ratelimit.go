@@ -0,0 +1,296 @@
+package rest
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	headerRetryAfter         = "Retry-After"
+	headerRateLimitLimit     = "RateLimit-Limit"
+	headerRateLimitRemaining = "RateLimit-Remaining"
+	headerRateLimitReset     = "RateLimit-Reset"
+	headerRateLimitPolicy    = "RateLimit-Policy"
+)
+
+// ErrRateLimited is reported with status 429 if a client exceeded its quota.
+var ErrRateLimited = errors.New("rate limit exceeded")
+
+// RateLimit is a quota of Limit requests per Period.
+type RateLimit struct {
+	Limit  int
+	Period time.Duration
+}
+
+// RateLimitResult is the outcome of taking a request from a quota.
+type RateLimitResult struct {
+	Allowed   bool
+	Remaining int
+	// Reset is the time until the quota is restored completely.
+	Reset time.Duration
+	// RetryAfter is the time until the next request is allowed if it was denied.
+	RetryAfter time.Duration
+}
+
+// RateLimitStore keeps the state of the quotas and implements the limiting
+// algorithm. Implementations must be safe for concurrent use.
+type RateLimitStore interface {
+	Take(key string, limit RateLimit, now time.Time) (RateLimitResult, error)
+}
+
+// RateLimitKeyFunc returns the key a request is counted for, e.g. the client IP.
+type RateLimitKeyFunc func(r *http.Request) string
+
+// KeyByIP counts requests per client IP, see ClientIP.
+func KeyByIP(r *http.Request) string {
+	return clientIP(r)
+}
+
+// KeyByHeader counts requests per value of the given header, e.g. an API key or tenant.
+func KeyByHeader(name string) RateLimitKeyFunc {
+	return func(r *http.Request) string {
+		return r.Header.Get(name)
+	}
+}
+
+// KeyByRoute counts requests per route pattern.
+func KeyByRoute(r *http.Request) string {
+	return RoutePattern(r.Context())
+}
+
+// RateLimitOptions represents the available rate limit options
+type RateLimitOptions struct {
+	// RateLimit is the default quota. Routes can override it by Register.RateLimit.
+	RateLimit RateLimit
+	// KeyFunc returns the key of the client. If nil KeyByIP is used.
+	KeyFunc RateLimitKeyFunc
+	// Store keeps the quotas. If nil an in-memory token bucket store is used.
+	Store RateLimitStore
+}
+
+// NewRateLimit creates a middleware limiting the requests per client. Routes
+// with an own RateLimit are counted separately. Requests exceeding the quota
+// are answered with ErrRateLimited with status 429 through the ErrorHandler.
+// The RateLimit headers of the IETF draft and Retry-After are set.
+func NewRateLimit(opts *RateLimitOptions) Middleware {
+	if opts == nil {
+		opts = &RateLimitOptions{}
+	}
+
+	keyFunc := opts.KeyFunc
+	if keyFunc == nil {
+		keyFunc = KeyByIP
+	}
+	store := opts.Store
+	if store == nil {
+		store = NewTokenBucketStore()
+	}
+
+	return newRateLimit(opts.RateLimit, keyFunc, store, time.Now)
+}
+
+func newRateLimit(defaultLimit RateLimit, keyFunc RateLimitKeyFunc, store RateLimitStore, now func() time.Time) Middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			limit, key := defaultLimit, keyFunc(r)
+			if rt, ok := r.Context().Value(routeKey{}).(*route); ok && rt.rateLimit != nil {
+				limit, key = *rt.rateLimit, rt.pattern+"|"+key
+			}
+			if limit.Limit <= 0 || limit.Period <= 0 {
+				next(w, r)
+				return
+			}
+
+			res, err := store.Take(key, limit, now())
+			if err != nil {
+				HandleError(w, r, err)
+				return
+			}
+
+			h := w.Header()
+			h.Set(headerRateLimitLimit, strconv.Itoa(limit.Limit))
+			h.Set(headerRateLimitRemaining, strconv.Itoa(res.Remaining))
+			h.Set(headerRateLimitReset, strconv.Itoa(ceilSeconds(res.Reset)))
+			h.Set(headerRateLimitPolicy, strconv.Itoa(limit.Limit)+";w="+strconv.Itoa(ceilSeconds(limit.Period)))
+
+			if !res.Allowed {
+				h.Set(headerRetryAfter, strconv.Itoa(ceilSeconds(res.RetryAfter)))
+				HandleError(w, r, NewStatusError(http.StatusTooManyRequests, ErrRateLimited))
+				return
+			}
+
+			next(w, r)
+		}
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	if d <= 0 {
+		return 0
+	}
+	return int(math.Ceil(d.Seconds()))
+}
+
+// sweepInterval is the number of operations after which idle entries are removed from the in-memory stores.
+const sweepInterval = 1024
+
+// TokenBucketStore is an in-memory RateLimitStore using a token bucket per
+// key. A bucket holds up to Limit tokens and is refilled continuously with
+// Limit tokens per Period, so bursts up to Limit are allowed.
+type TokenBucketStore struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	ops     int
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+	period  time.Duration
+}
+
+// NewTokenBucketStore creates a new TokenBucketStore.
+func NewTokenBucketStore() *TokenBucketStore {
+	return &TokenBucketStore{buckets: map[string]*tokenBucket{}}
+}
+
+// Take takes a token from the bucket of key.
+func (s *TokenBucketStore) Take(key string, limit RateLimit, now time.Time) (RateLimitResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweep(now)
+
+	capacity := float64(limit.Limit)
+	rate := capacity / limit.Period.Seconds()
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: capacity, updated: now}
+		s.buckets[key] = b
+	}
+	b.period = limit.Period
+
+	if elapsed := now.Sub(b.updated).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(capacity, b.tokens+elapsed*rate)
+		b.updated = now
+	}
+
+	res := RateLimitResult{}
+	if b.tokens >= 1 {
+		b.tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = secondsToDuration((1 - b.tokens) / rate)
+	}
+	res.Remaining = int(b.tokens)
+	res.Reset = secondsToDuration((capacity - b.tokens) / rate)
+	return res, nil
+}
+
+// sweep removes full buckets every sweepInterval operations.
+func (s *TokenBucketStore) sweep(now time.Time) {
+	s.ops++
+	if s.ops < sweepInterval {
+		return
+	}
+	s.ops = 0
+	for key, b := range s.buckets {
+		if now.Sub(b.updated) > b.period {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+func secondsToDuration(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// SlidingWindowStore is an in-memory RateLimitStore using a sliding window
+// counter per key. The count of the previous window is weighted by its overlap
+// with the sliding window, so no bursts beyond Limit are allowed at window boundaries.
+type SlidingWindowStore struct {
+	mu      sync.Mutex
+	windows map[string]*slidingWindow
+	ops     int
+}
+
+type slidingWindow struct {
+	start     time.Time
+	current   int
+	previous  int
+	period    time.Duration
+	lastTaken time.Time
+}
+
+// NewSlidingWindowStore creates a new SlidingWindowStore.
+func NewSlidingWindowStore() *SlidingWindowStore {
+	return &SlidingWindowStore{windows: map[string]*slidingWindow{}}
+}
+
+// Take counts a request in the window of key.
+func (s *SlidingWindowStore) Take(key string, limit RateLimit, now time.Time) (RateLimitResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweep(now)
+
+	start := now.Truncate(limit.Period)
+	w, ok := s.windows[key]
+	if !ok {
+		w = &slidingWindow{start: start}
+		s.windows[key] = w
+	}
+	w.period = limit.Period
+	w.lastTaken = now
+
+	switch {
+	case start.Sub(w.start) >= 2*limit.Period:
+		w.previous, w.current = 0, 0
+	case start.After(w.start):
+		w.previous, w.current = w.current, 0
+	}
+	w.start = start
+
+	elapsed := now.Sub(start)
+	weight := 1 - float64(elapsed)/float64(limit.Period)
+	estimate := float64(w.previous)*weight + float64(w.current)
+	reset := limit.Period - elapsed
+
+	res := RateLimitResult{Reset: reset}
+	if estimate+1 <= float64(limit.Limit) {
+		w.current++
+		estimate++
+		res.Allowed = true
+	} else if w.current+1 > limit.Limit || w.previous == 0 {
+		res.RetryAfter = reset
+	} else {
+		// wait until the weight of the previous window leaves room for one request
+		maxWeight := float64(limit.Limit-w.current-1) / float64(w.previous)
+		res.RetryAfter = time.Duration((1-maxWeight)*float64(limit.Period)) - elapsed
+	}
+
+	res.Remaining = limit.Limit - int(math.Ceil(estimate))
+	if res.Remaining < 0 {
+		res.Remaining = 0
+	}
+	return res, nil
+}
+
+// sweep removes windows without requests in the last two periods every sweepInterval operations.
+func (s *SlidingWindowStore) sweep(now time.Time) {
+	s.ops++
+	if s.ops < sweepInterval {
+		return
+	}
+	s.ops = 0
+	for key, w := range s.windows {
+		if now.Sub(w.lastTaken) > 2*w.period {
+			delete(s.windows, key)
+		}
+	}
+}
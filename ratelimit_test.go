@@ -0,0 +1,138 @@
+package rest
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTokenBucketStore(t *testing.T) {
+	s := NewTokenBucketStore()
+	limit := RateLimit{Limit: 2, Period: 10 * time.Second}
+	now := time.Date(2017, 1, 15, 0, 0, 0, 0, time.UTC)
+
+	testcases := []struct {
+		test       string
+		after      time.Duration
+		allowed    bool
+		remaining  int
+		retryAfter time.Duration
+	}{
+		{"first", 0, true, 1, 0},
+		{"burst", 0, true, 0, 0},
+		{"exhausted", 0, false, 0, 5 * time.Second},
+		{"refilled one", 5 * time.Second, true, 0, 0},
+		{"refilled full", time.Minute, true, 1, 0},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.test, func(t *testing.T) {
+			now = now.Add(tc.after)
+			res, err := s.Take("a", limit, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if res.Allowed != tc.allowed || res.Remaining != tc.remaining || res.RetryAfter != tc.retryAfter {
+				t.Errorf("Got: %+v - want: allowed %t, remaining %d, retry after %v", res, tc.allowed, tc.remaining, tc.retryAfter)
+			}
+		})
+	}
+}
+
+func TestSlidingWindowStore(t *testing.T) {
+	s := NewSlidingWindowStore()
+	limit := RateLimit{Limit: 2, Period: 10 * time.Second}
+	now := time.Date(2017, 1, 15, 0, 0, 0, 0, time.UTC)
+
+	testcases := []struct {
+		test       string
+		at         time.Duration
+		allowed    bool
+		retryAfter time.Duration
+	}{
+		{"first", 0, true, 0},
+		{"second", time.Second, true, 0},
+		{"exhausted", 2 * time.Second, false, 8 * time.Second},
+		{"previous window weighs fully", 10 * time.Second, false, 5 * time.Second},
+		{"previous window weighs half", 15 * time.Second, true, 0},
+		{"exhausted again", 16 * time.Second, false, 4 * time.Second},
+		{"windows expired", 40 * time.Second, true, 0},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.test, func(t *testing.T) {
+			res, err := s.Take("a", limit, now.Add(tc.at))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if res.Allowed != tc.allowed || res.RetryAfter != tc.retryAfter {
+				t.Errorf("Got: %+v - want: allowed %t, retry after %v", res, tc.allowed, tc.retryAfter)
+			}
+		})
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	var reported error
+	now := time.Date(2017, 1, 15, 0, 0, 0, 0, time.UTC)
+
+	m := newRateLimit(RateLimit{Limit: 2, Period: time.Minute}, KeyByHeader("X-Api-Key"), NewTokenBucketStore(), func() time.Time { return now })
+	s := New(Configuration{
+		Chain: []Middleware{m},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			reported = err
+			w.WriteHeader(ErrorStatus(err))
+		},
+	}, nil)
+	s.Register([]Register{
+		{Method: http.MethodGet, Path: "/items", Handler: AHandler},
+		{Method: http.MethodPost, Path: "/items", Handler: AHandler, RateLimit: &RateLimit{Limit: 1, Period: time.Minute}},
+	}, "")
+
+	testcases := []struct {
+		test      string
+		method    string
+		key       string
+		status    int
+		remaining string
+	}{
+		{"first", http.MethodGet, "a", http.StatusOK, "1"},
+		{"second", http.MethodGet, "a", http.StatusOK, "0"},
+		{"exceeded", http.MethodGet, "a", http.StatusTooManyRequests, "0"},
+		{"other client", http.MethodGet, "b", http.StatusOK, "1"},
+		{"route limit", http.MethodPost, "a", http.StatusOK, "0"},
+		{"route limit exceeded", http.MethodPost, "a", http.StatusTooManyRequests, "0"},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.test, func(t *testing.T) {
+			reported = nil
+			recorder := httptest.NewRecorder()
+			r, _ := http.NewRequest(tc.method, "/items", nil)
+			r.Header.Set("X-Api-Key", tc.key)
+			s.ServeHTTP(recorder, r)
+
+			if recorder.Code != tc.status {
+				t.Errorf("Status code is expected to be %d, found %d", tc.status, recorder.Code)
+			}
+
+			headers := recorder.Result().Header
+			if v := headers.Get(headerRateLimitRemaining); v != tc.remaining {
+				t.Errorf("RateLimit-Remaining is expected to be %v, found %v", tc.remaining, v)
+			}
+
+			if tc.status == http.StatusTooManyRequests {
+				if !errors.Is(reported, ErrRateLimited) {
+					t.Errorf("Got: %v - want: %v", reported, ErrRateLimited)
+				}
+				if v := headers.Get(headerRetryAfter); v == "" || v == "0" {
+					t.Errorf("Retry-After is expected to be set, found %v", v)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package rest
+
+import (
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	headerAccept        = "Accept"
+	headerContentType   = "Content-Type"
+	headerContentLength = "Content-Length"
+	headerVary          = "Vary"
+)
+
+// mediaRange is a single entry of an Accept header.
+type mediaRange struct {
+	typ, subtype string
+	q            float64
+}
+
+// parseAccept parses an Accept header into its media ranges. Invalid entries are skipped.
+func parseAccept(accept string) []mediaRange {
+	var ranges []mediaRange
+	for _, part := range strings.Split(accept, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		mt, params, err := mime.ParseMediaType(part)
+		if err != nil {
+			continue
+		}
+
+		typ, subtype := mt, ""
+		if i := strings.IndexByte(mt, '/'); i >= 0 {
+			typ, subtype = mt[:i], mt[i+1:]
+		}
+		if typ == "" || subtype == "" || (typ == "*" && subtype != "*") {
+			continue
+		}
+
+		q := 1.0
+		if qs, ok := params["q"]; ok {
+			q, err = strconv.ParseFloat(qs, 64)
+			if err != nil || q < 0 || q > 1 {
+				continue
+			}
+		}
+		ranges = append(ranges, mediaRange{typ: typ, subtype: subtype, q: q})
+	}
+	return ranges
+}
+
+// quality returns the q-value the most specific matching media range assigns to mediaType.
+func quality(ranges []mediaRange, mediaType string) float64 {
+	typ, subtype := mediaType, ""
+	if i := strings.IndexByte(mediaType, '/'); i >= 0 {
+		typ, subtype = mediaType[:i], mediaType[i+1:]
+	}
+
+	q, specificity := 0.0, 0
+	for _, r := range ranges {
+		s := 0
+		switch {
+		case r.typ == typ && r.subtype == subtype:
+			s = 3
+		case r.typ == typ && r.subtype == "*":
+			s = 2
+		case r.typ == "*":
+			s = 1
+		}
+		if s > specificity {
+			q, specificity = r.q, s
+		}
+	}
+	return q
+}
+
+// addVary adds the given header names to the Vary header unless already present.
+func addVary(h http.Header, names ...string) {
+	for _, name := range names {
+		present := false
+		for _, v := range h[headerVary] {
+			for _, f := range strings.Split(v, ",") {
+				f = strings.TrimSpace(f)
+				if f == "*" || strings.EqualFold(f, name) {
+					present = true
+				}
+			}
+		}
+		if !present {
+			h.Add(headerVary, name)
+		}
+	}
+}
+
+// Render marshals v with the codec negotiated by the Accept header of r and
+// writes it with the given status. If v satisfies the Statuser interface its
+// status is used instead. ETag and Last-Modified are set by SetValidators.
+//
+// If no codec is acceptable ErrNotAcceptable is reported with status 406
+// through the ErrorHandler. The returned error is the one reported to the
+// ErrorHandler, the response has already been written when Render returns.
+func Render(w http.ResponseWriter, r *http.Request, status int, v interface{}) error {
+	addVary(w.Header(), headerAccept)
+
+	mediaType, codec, ok := DefaultCodecs.Negotiate(r.Header.Get(headerAccept), v)
+	if !ok {
+		err := NewStatusError(http.StatusNotAcceptable, ErrNotAcceptable)
+		HandleError(w, r, err)
+		return err
+	}
+
+	body, err := codec.Marshal(v)
+	if err != nil {
+		HandleError(w, r, err)
+		return err
+	}
+
+	if s, ok := v.(Statuser); ok {
+		status = s.Status()
+	}
+
+	SetValidators(w, v)
+	w.Header().Set(headerContentType, mediaType)
+	w.Header().Set(headerContentLength, strconv.Itoa(len(body)))
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		_, err = w.Write(body)
+	}
+	return err
+}
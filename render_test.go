@@ -0,0 +1,169 @@
+package rest
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type renderValue struct {
+	Name string `json:"name" xml:"name"`
+}
+
+type protoValue struct{}
+
+func (protoValue) Marshal() ([]byte, error) { return []byte{0x0a, 0x01, 0x61}, nil }
+
+type createdValue struct {
+	Name string `json:"name"`
+}
+
+func (createdValue) Status() int { return http.StatusCreated }
+
+func TestRender(t *testing.T) {
+	testcases := []struct {
+		test        string
+		accept      string
+		value       interface{}
+		status      int
+		contentType string
+		body        string
+	}{
+		{
+			test:        "no accept header",
+			value:       renderValue{Name: "a"},
+			status:      http.StatusOK,
+			contentType: "application/json",
+			body:        `{"name":"a"}`,
+		},
+		{
+			test:        "wildcard",
+			accept:      "*/*",
+			value:       renderValue{Name: "a"},
+			status:      http.StatusOK,
+			contentType: "application/json",
+			body:        `{"name":"a"}`,
+		},
+		{
+			test:        "xml preferred by q-value",
+			accept:      "application/json;q=0.5, application/xml",
+			value:       renderValue{Name: "a"},
+			status:      http.StatusOK,
+			contentType: "application/xml",
+			body:        `<renderValue><name>a</name></renderValue>`,
+		},
+		{
+			test:        "alias",
+			accept:      "text/*",
+			value:       renderValue{Name: "a"},
+			status:      http.StatusOK,
+			contentType: "text/xml",
+			body:        `<renderValue><name>a</name></renderValue>`,
+		},
+		{
+			test:        "json excluded",
+			accept:      "application/json;q=0, */*;q=0.1",
+			value:       renderValue{Name: "a"},
+			status:      http.StatusOK,
+			contentType: "application/xml",
+			body:        `<renderValue><name>a</name></renderValue>`,
+		},
+		{
+			test:        "protobuf",
+			accept:      "application/x-protobuf, application/json;q=0.9",
+			value:       protoValue{},
+			status:      http.StatusOK,
+			contentType: "application/x-protobuf",
+			body:        "\x0a\x01\x61",
+		},
+		{
+			test:        "protobuf unsupported by value",
+			accept:      "application/x-protobuf, application/json;q=0.9",
+			value:       renderValue{Name: "a"},
+			status:      http.StatusOK,
+			contentType: "application/json",
+			body:        `{"name":"a"}`,
+		},
+		{
+			test:        "statuser",
+			value:       createdValue{Name: "a"},
+			status:      http.StatusCreated,
+			contentType: "application/json",
+			body:        `{"name":"a"}`,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.test, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			r, _ := http.NewRequest(http.MethodGet, "/", nil)
+			if tc.accept != "" {
+				r.Header.Set(headerAccept, tc.accept)
+			}
+
+			if err := Render(recorder, r, http.StatusOK, tc.value); err != nil {
+				t.Fatalf("Got: %v - want: no error", err)
+			}
+
+			if recorder.Code != tc.status {
+				t.Errorf("Got: %d - want: %d", recorder.Code, tc.status)
+			}
+
+			if ct := recorder.Header().Get(headerContentType); ct != tc.contentType {
+				t.Errorf("Got: %s - want: %s", ct, tc.contentType)
+			}
+
+			if vary := recorder.Header().Get(headerVary); vary != headerAccept {
+				t.Errorf("Got: %s - want: %s", vary, headerAccept)
+			}
+
+			if body := recorder.Body.String(); body != tc.body {
+				t.Errorf("Got: %s - want: %s", body, tc.body)
+			}
+		})
+	}
+}
+
+func TestRenderNotAcceptable(t *testing.T) {
+	var reported error
+
+	s := New(Configuration{
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			reported = err
+			w.WriteHeader(ErrorStatus(err))
+		},
+	}, nil)
+	s.Get("/foo", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Render(w, r, http.StatusOK, renderValue{})
+	}))
+
+	recorder := httptest.NewRecorder()
+	r, _ := http.NewRequest(http.MethodGet, "/foo", nil)
+	r.Header.Set(headerAccept, "image/png")
+	s.ServeHTTP(recorder, r)
+
+	if recorder.Code != http.StatusNotAcceptable {
+		t.Errorf("Got: %d - want: %d", recorder.Code, http.StatusNotAcceptable)
+	}
+
+	if !errors.Is(reported, ErrNotAcceptable) {
+		t.Errorf("Got: %v - want: %v", reported, ErrNotAcceptable)
+	}
+}
+
+func TestDefaultErrorHandlerStatus(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	r, _ := http.NewRequest(http.MethodGet, "/", nil)
+
+	DefaultErrorHandler(recorder, r, NewStatusError(http.StatusTeapot, nil))
+	if recorder.Code != http.StatusTeapot {
+		t.Errorf("Got: %d - want: %d", recorder.Code, http.StatusTeapot)
+	}
+
+	recorder = httptest.NewRecorder()
+	DefaultErrorHandler(recorder, r, errors.New("boom"))
+	if recorder.Code != http.StatusInternalServerError {
+		t.Errorf("Got: %d - want: %d", recorder.Code, http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,338 @@
+package rest
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	headerHSTS                      = "Strict-Transport-Security"
+	headerContentTypeOptions        = "X-Content-Type-Options"
+	headerFrameOptions              = "X-Frame-Options"
+	headerReferrerPolicy            = "Referrer-Policy"
+	headerPermissionsPolicy         = "Permissions-Policy"
+	headerCrossOriginOpenerPolicy   = "Cross-Origin-Opener-Policy"
+	headerCrossOriginEmbedderPolicy = "Cross-Origin-Embedder-Policy"
+	headerCrossOriginResourcePolicy = "Cross-Origin-Resource-Policy"
+	headerCSP                       = "Content-Security-Policy"
+	headerCSPReportOnly             = "Content-Security-Policy-Report-Only"
+)
+
+// CSPNonceSource is replaced by the nonce of the request in the sources of a CSP directive.
+const CSPNonceSource = "'nonce'"
+
+// cspNonceKey defines the context key of the CSP nonce of a request
+type cspNonceKey struct{}
+
+// SecurityHeaders represents the security headers of responses. Empty fields are not sent.
+type SecurityHeaders struct {
+	// HSTSMaxAge enables Strict-Transport-Security if positive.
+	HSTSMaxAge            time.Duration
+	HSTSIncludeSubdomains bool
+	HSTSPreload           bool
+	// NoSniff sends X-Content-Type-Options: nosniff.
+	NoSniff bool
+	// FrameOptions is the X-Frame-Options, e.g. DENY or SAMEORIGIN.
+	FrameOptions string
+	// ReferrerPolicy is the Referrer-Policy, e.g. strict-origin-when-cross-origin.
+	ReferrerPolicy string
+	// PermissionsPolicy is the Permissions-Policy, e.g. camera=(), microphone=().
+	PermissionsPolicy string
+	// CrossOriginOpenerPolicy is the Cross-Origin-Opener-Policy, e.g. same-origin.
+	CrossOriginOpenerPolicy string
+	// CrossOriginEmbedderPolicy is the Cross-Origin-Embedder-Policy, e.g. require-corp.
+	CrossOriginEmbedderPolicy string
+	// CrossOriginResourcePolicy is the Cross-Origin-Resource-Policy, e.g. same-origin.
+	CrossOriginResourcePolicy string
+	// CSP is the Content-Security-Policy.
+	CSP *CSP
+	// CSPReportOnly sends the CSP as Content-Security-Policy-Report-Only, so violations are reported but not blocked.
+	CSPReportOnly bool
+}
+
+// DefaultSecurityHeaders returns headers suitable for APIs: HSTS for two years,
+// nosniff, DENY framing, no referrer, same-origin opener and resource policies
+// and a CSP denying all content.
+func DefaultSecurityHeaders() *SecurityHeaders {
+	return &SecurityHeaders{
+		HSTSMaxAge:                2 * 365 * 24 * time.Hour,
+		HSTSIncludeSubdomains:     true,
+		NoSniff:                   true,
+		FrameOptions:              "DENY",
+		ReferrerPolicy:            "no-referrer",
+		CrossOriginOpenerPolicy:   "same-origin",
+		CrossOriginResourcePolicy: "same-origin",
+		CSP:                       NewCSP().Directive("default-src", "'none'").Directive("frame-ancestors", "'none'"),
+	}
+}
+
+// NewSecurityHeaders creates a middleware setting the security headers on all
+// responses. Routes can override them by Register.SecurityHeaders. If opts is
+// nil the DefaultSecurityHeaders are used. If the CSP uses CSPNonceSource a
+// nonce is generated per request, see CSPNonce.
+func NewSecurityHeaders(opts *SecurityHeaders) Middleware {
+	if opts == nil {
+		opts = DefaultSecurityHeaders()
+	}
+
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			o := opts
+			if rt, ok := r.Context().Value(routeKey{}).(*route); ok && rt.securityHeaders != nil {
+				o = rt.securityHeaders
+			}
+
+			if o.CSP != nil && o.CSP.usesNonce() {
+				nonce := newCSPNonce()
+				r = r.WithContext(context.WithValue(r.Context(), cspNonceKey{}, nonce))
+				o.set(w.Header(), nonce)
+			} else {
+				o.set(w.Header(), "")
+			}
+
+			next(w, r)
+		}
+	}
+}
+
+func (o *SecurityHeaders) set(h http.Header, nonce string) {
+	if o.HSTSMaxAge > 0 {
+		hsts := "max-age=" + strconv.FormatInt(int64(o.HSTSMaxAge/time.Second), 10)
+		if o.HSTSIncludeSubdomains {
+			hsts += "; includeSubDomains"
+		}
+		if o.HSTSPreload {
+			hsts += "; preload"
+		}
+		h.Set(headerHSTS, hsts)
+	}
+	if o.NoSniff {
+		h.Set(headerContentTypeOptions, "nosniff")
+	}
+
+	for name, value := range map[string]string{
+		headerFrameOptions:              o.FrameOptions,
+		headerReferrerPolicy:            o.ReferrerPolicy,
+		headerPermissionsPolicy:         o.PermissionsPolicy,
+		headerCrossOriginOpenerPolicy:   o.CrossOriginOpenerPolicy,
+		headerCrossOriginEmbedderPolicy: o.CrossOriginEmbedderPolicy,
+		headerCrossOriginResourcePolicy: o.CrossOriginResourcePolicy,
+	} {
+		if value != "" {
+			h.Set(name, value)
+		}
+	}
+
+	if o.CSP != nil {
+		if o.CSPReportOnly {
+			h.Set(headerCSPReportOnly, o.CSP.String(nonce))
+		} else {
+			h.Set(headerCSP, o.CSP.String(nonce))
+		}
+	}
+}
+
+func newCSPNonce() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+// CSPNonce returns the CSP nonce of the request to be set as nonce attribute of inline scripts and styles.
+func CSPNonce(ctx context.Context) string {
+	nonce, _ := ctx.Value(cspNonceKey{}).(string)
+	return nonce
+}
+
+// CSP builds a Content-Security-Policy.
+type CSP struct {
+	directives []cspDirective
+}
+
+type cspDirective struct {
+	name    string
+	sources []string
+}
+
+// NewCSP creates an empty policy.
+func NewCSP() *CSP {
+	return &CSP{}
+}
+
+// Directive adds the sources to the directive, e.g. Directive("script-src", "'self'", CSPNonceSource).
+// Directives without sources like upgrade-insecure-requests are added without values.
+func (c *CSP) Directive(name string, sources ...string) *CSP {
+	for i := range c.directives {
+		if c.directives[i].name == name {
+			c.directives[i].sources = append(c.directives[i].sources, sources...)
+			return c
+		}
+	}
+	c.directives = append(c.directives, cspDirective{name: name, sources: sources})
+	return c
+}
+
+// ReportTo adds the report-uri and report-to directives sending violations to the CSPReportHandler at uri.
+// The group must be declared by the Reporting-Endpoints header, if it is empty only report-uri is used.
+func (c *CSP) ReportTo(uri, group string) *CSP {
+	c.Directive("report-uri", uri)
+	if group != "" {
+		c.Directive("report-to", group)
+	}
+	return c
+}
+
+func (c *CSP) usesNonce() bool {
+	for _, d := range c.directives {
+		if containsString(d.sources, CSPNonceSource) {
+			return true
+		}
+	}
+	return false
+}
+
+// String serializes the policy with the nonce replacing CSPNonceSource.
+func (c *CSP) String(nonce string) string {
+	directives := make([]string, 0, len(c.directives))
+	for _, d := range c.directives {
+		parts := []string{d.name}
+		for _, s := range d.sources {
+			if s == CSPNonceSource {
+				if nonce == "" {
+					continue
+				}
+				s = "'nonce-" + nonce + "'"
+			}
+			parts = append(parts, s)
+		}
+		directives = append(directives, strings.Join(parts, " "))
+	}
+	return strings.Join(directives, "; ")
+}
+
+// CSPReport is a violation of a Content-Security-Policy reported by a browser.
+type CSPReport struct {
+	DocumentURI        string
+	Referrer           string
+	BlockedURI         string
+	EffectiveDirective string
+	OriginalPolicy     string
+	Disposition        string
+	StatusCode         int
+	SourceFile         string
+	LineNumber         int
+	ColumnNumber       int
+	Sample             string
+}
+
+// legacyCSPReport is the body of the report-uri directive with content type application/csp-report.
+type legacyCSPReport struct {
+	Report struct {
+		DocumentURI        string `json:"document-uri"`
+		Referrer           string `json:"referrer"`
+		BlockedURI         string `json:"blocked-uri"`
+		ViolatedDirective  string `json:"violated-directive"`
+		EffectiveDirective string `json:"effective-directive"`
+		OriginalPolicy     string `json:"original-policy"`
+		Disposition        string `json:"disposition"`
+		StatusCode         int    `json:"status-code"`
+		SourceFile         string `json:"source-file"`
+		LineNumber         int    `json:"line-number"`
+		ColumnNumber       int    `json:"column-number"`
+		ScriptSample       string `json:"script-sample"`
+	} `json:"csp-report"`
+}
+
+// reportingAPIReport is an entry of the Reporting API body with content type application/reports+json.
+type reportingAPIReport struct {
+	Type string `json:"type"`
+	Body struct {
+		DocumentURL        string `json:"documentURL"`
+		Referrer           string `json:"referrer"`
+		BlockedURL         string `json:"blockedURL"`
+		EffectiveDirective string `json:"effectiveDirective"`
+		OriginalPolicy     string `json:"originalPolicy"`
+		Disposition        string `json:"disposition"`
+		StatusCode         int    `json:"statusCode"`
+		SourceFile         string `json:"sourceFile"`
+		LineNumber         int    `json:"lineNumber"`
+		ColumnNumber       int    `json:"columnNumber"`
+		Sample             string `json:"sample"`
+	} `json:"body"`
+}
+
+// CSPReportHandler creates the handler of a CSP violation report endpoint. It
+// accepts reports of the report-uri directive and of the Reporting API, calls
+// report for each violation and answers with status 204. Malformed reports are
+// answered with ErrInvalidBody and status 400 through the ErrorHandler.
+func CSPReportHandler(report func(r *http.Request, violation CSPReport)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := readBody(r)
+		if err != nil {
+			HandleError(w, r, err)
+			return
+		}
+
+		var violations []CSPReport
+		if strings.HasPrefix(strings.TrimSpace(string(body)), "[") {
+			var reports []reportingAPIReport
+			err = json.Unmarshal(body, &reports)
+			for _, rep := range reports {
+				if rep.Type != "csp-violation" {
+					continue
+				}
+				b := rep.Body
+				violations = append(violations, CSPReport{
+					DocumentURI:        b.DocumentURL,
+					Referrer:           b.Referrer,
+					BlockedURI:         b.BlockedURL,
+					EffectiveDirective: b.EffectiveDirective,
+					OriginalPolicy:     b.OriginalPolicy,
+					Disposition:        b.Disposition,
+					StatusCode:         b.StatusCode,
+					SourceFile:         b.SourceFile,
+					LineNumber:         b.LineNumber,
+					ColumnNumber:       b.ColumnNumber,
+					Sample:             b.Sample,
+				})
+			}
+		} else {
+			var rep legacyCSPReport
+			err = json.Unmarshal(body, &rep)
+			b := rep.Report
+			if b.EffectiveDirective == "" {
+				b.EffectiveDirective = b.ViolatedDirective
+			}
+			violations = append(violations, CSPReport{
+				DocumentURI:        b.DocumentURI,
+				Referrer:           b.Referrer,
+				BlockedURI:         b.BlockedURI,
+				EffectiveDirective: b.EffectiveDirective,
+				OriginalPolicy:     b.OriginalPolicy,
+				Disposition:        b.Disposition,
+				StatusCode:         b.StatusCode,
+				SourceFile:         b.SourceFile,
+				LineNumber:         b.LineNumber,
+				ColumnNumber:       b.ColumnNumber,
+				Sample:             b.ScriptSample,
+			})
+		}
+		if err != nil {
+			HandleError(w, r, NewStatusError(http.StatusBadRequest, ErrInvalidBody))
+			return
+		}
+
+		for _, v := range violations {
+			report(r, v)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,141 @@
+package rest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSecurityHeaders(t *testing.T) {
+	page := &SecurityHeaders{
+		HSTSMaxAge:        time.Hour,
+		HSTSPreload:       true,
+		NoSniff:           true,
+		FrameOptions:      "SAMEORIGIN",
+		PermissionsPolicy: "camera=()",
+		CSP: NewCSP().
+			Directive("default-src", "'self'").
+			Directive("script-src", "'self'", CSPNonceSource).
+			Directive("script-src", "https://cdn.example.com").
+			Directive("upgrade-insecure-requests").
+			ReportTo("/csp-report", "csp"),
+		CSPReportOnly: true,
+	}
+
+	var nonce string
+	s := New(Configuration{Chain: []Middleware{NewSecurityHeaders(nil)}}, nil)
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		nonce = CSPNonce(r.Context())
+	}
+	s.Register([]Register{
+		{Method: http.MethodGet, Path: "/api", Handler: handler},
+		{Method: http.MethodGet, Path: "/page", Handler: handler, SecurityHeaders: page},
+	}, "")
+
+	testcases := []struct {
+		path    string
+		headers map[string]string
+	}{
+		{"/api", map[string]string{
+			headerHSTS:                      "max-age=63072000; includeSubDomains",
+			headerContentTypeOptions:        "nosniff",
+			headerFrameOptions:              "DENY",
+			headerReferrerPolicy:            "no-referrer",
+			headerPermissionsPolicy:         "",
+			headerCrossOriginOpenerPolicy:   "same-origin",
+			headerCrossOriginEmbedderPolicy: "",
+			headerCrossOriginResourcePolicy: "same-origin",
+			headerCSP:                       "default-src 'none'; frame-ancestors 'none'",
+			headerCSPReportOnly:             "",
+		}},
+		{"/page", map[string]string{
+			headerHSTS:                      "max-age=3600; preload",
+			headerContentTypeOptions:        "nosniff",
+			headerFrameOptions:              "SAMEORIGIN",
+			headerReferrerPolicy:            "",
+			headerPermissionsPolicy:         "camera=()",
+			headerCrossOriginOpenerPolicy:   "",
+			headerCrossOriginResourcePolicy: "",
+			headerCSP:                       "",
+			headerCSPReportOnly:             "default-src 'self'; script-src 'self' 'nonce-{nonce}' https://cdn.example.com; upgrade-insecure-requests; report-uri /csp-report; report-to csp",
+		}},
+	}
+
+	for _, tc := range testcases {
+		nonce = ""
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest(http.MethodGet, tc.path, nil)
+		s.ServeHTTP(w, r)
+
+		for name, expected := range tc.headers {
+			expected = strings.Replace(expected, "{nonce}", nonce, 1)
+			if got := w.Header().Get(name); got != expected {
+				t.Errorf("%s: expected %s %q, got %q", tc.path, name, expected, got)
+			}
+		}
+		if tc.path == "/page" && len(nonce) != 24 {
+			t.Errorf("expected nonce in context, got %q", nonce)
+		}
+		if tc.path == "/api" && nonce != "" {
+			t.Errorf("expected no nonce without nonce source, got %q", nonce)
+		}
+	}
+
+	// the nonce differs per request
+	first := nonce
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest(http.MethodGet, "/page", nil)
+	s.ServeHTTP(w, r)
+	if nonce == first {
+		t.Errorf("expected a new nonce per request")
+	}
+}
+
+func TestCSPReportHandler(t *testing.T) {
+	testcases := []struct {
+		test       string
+		body       string
+		code       int
+		violations []CSPReport
+	}{
+		{
+			"report-uri",
+			`{"csp-report":{"document-uri":"https://example.com/page","blocked-uri":"inline","violated-directive":"script-src-elem","line-number":3}}`,
+			204,
+			[]CSPReport{{DocumentURI: "https://example.com/page", BlockedURI: "inline", EffectiveDirective: "script-src-elem", LineNumber: 3}},
+		},
+		{
+			"reporting api",
+			`[{"type":"csp-violation","body":{"documentURL":"https://example.com/page","blockedURL":"https://evil.com/x.js","effectiveDirective":"script-src","disposition":"report"}},{"type":"deprecation","body":{}}]`,
+			204,
+			[]CSPReport{{DocumentURI: "https://example.com/page", BlockedURI: "https://evil.com/x.js", EffectiveDirective: "script-src", Disposition: "report"}},
+		},
+		{"malformed", `{"csp-report":`, 400, nil},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.test, func(t *testing.T) {
+			var violations []CSPReport
+			h := CSPReportHandler(func(r *http.Request, v CSPReport) {
+				violations = append(violations, v)
+			})
+
+			w := httptest.NewRecorder()
+			h(w, httptest.NewRequest(http.MethodPost, "/csp-report", strings.NewReader(tc.body)))
+
+			if w.Code != tc.code {
+				t.Errorf("expected status %d, got %d", tc.code, w.Code)
+			}
+			if len(violations) != len(tc.violations) {
+				t.Fatalf("expected %d violations, got %d", len(tc.violations), len(violations))
+			}
+			for i := range violations {
+				if violations[i] != tc.violations[i] {
+					t.Errorf("expected %+v, got %+v", tc.violations[i], violations[i])
+				}
+			}
+		})
+	}
+}
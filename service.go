@@ -3,21 +3,35 @@
 package rest
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"path"
+	"sort"
 	"strings"
 )
 
+const (
+	headerMethodOverride = "X-HTTP-Method-Override"
+	formMethodOverride   = "_method"
+
+	// maxFormBodySize is the size up to which url encoded bodies are parsed, the limit of ParseForm.
+	maxFormBodySize = 10 << 20
+)
+
 // paramsKey defines context paramteters key
 type paramsKey struct{}
 
+// routeKey defines the context key of the matched route
+type routeKey struct{}
+
 // Service implements a HTTP Router + Some Helpers for chaining and error handling. It is used for the GRPC-REST Gateway
 type Service struct {
 	routes          map[string]*node
-	optionsChain    []http.Handler
+	cors            http.HandlerFunc // CORS handler of the service, nil if disabled
 	chain           []Middleware
 	notFoundHandler ErrorHandler // The Route could not be found
 	errorHandler    ErrorHandler // Handle errors in general. We expected the DError and the data in the context
@@ -25,6 +39,10 @@ type Service struct {
 	baseURI         string
 	corsEnabled     bool
 	corsOptions     *CORSOptions
+	decodeOptions   decodeOptions
+	authorizer      Authorizer
+	routeList       []*route // registered routes in order of registration
+	methodOverride  map[string]bool
 }
 
 // Configuration container the configuration Parameter needed to initialize the GRPCRESTService
@@ -39,6 +57,14 @@ type Configuration struct {
 	CORS bool
 	// CORSOptions allows to give the CORS Options to the service. If CORS is set to true and no CORSOptions are given all origins will be allowed
 	CORSOptions *CORSOptions
+	// MaxBodySize limits the size of request bodies read by Decode. If 0 the DefaultMaxBodySize is used, a negative value disables the limit
+	MaxBodySize int64
+	// DisallowUnknownFields lets Decode reject bodies containing fields unknown to the target value if the codec supports it
+	DisallowUnknownFields bool
+	// Authorizer checks the Permissions of the routes. If nil a DefaultAuthorizer without policies is used
+	Authorizer Authorizer
+	// MethodOverride are the methods a POST request may be overridden to by the X-HTTP-Method-Override header or the _method form field, e.g. for clients restricted to GET and POST. GET, HEAD and OPTIONS are never overridden to, as a POST must not skip the checks of safe methods, e.g. of CSRF. If empty methods are not overridden
+	MethodOverride []string
 }
 
 // New created a new GRPCRESTServices and applies the configuration and register the handlers given by the registrators
@@ -52,14 +78,44 @@ func New(cfg Configuration, registrators []HandlerRegistration) *Service {
 		notFoundHandler: cfg.ErrorHandler,
 		corsEnabled:     cfg.CORS,
 		corsOptions:     cfg.CORSOptions,
+		decodeOptions: decodeOptions{
+			maxBodySize:           cfg.MaxBodySize,
+			disallowUnknownFields: cfg.DisallowUnknownFields,
+		},
+		authorizer: cfg.Authorizer,
+	}
+	if s.decodeOptions.maxBodySize == 0 {
+		s.decodeOptions.maxBodySize = DefaultMaxBodySize
 	}
 	if s.errorHandler == nil {
 		s.errorHandler = DefaultErrorHandler
 		s.notFoundHandler = DefaultErrorHandler
 	}
+	if s.authorizer == nil {
+		s.authorizer = &DefaultAuthorizer{}
+	}
+	for _, method := range cfg.MethodOverride {
+		switch method = strings.ToUpper(method); method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			if s.methodOverride == nil {
+				s.methodOverride = map[string]bool{}
+			}
+			s.methodOverride[method] = true
+		}
+	}
 
 	for _, reg := range registrators {
-		err := s.Register(reg.GetHandlersToRegister(), reg.GetBaseURI())
+		var cors *CORSOptions
+		if c, ok := reg.(CORSRegistration); ok {
+			cors = c.GetCORSOptions()
+		}
+		var chain []Middleware
+		if c, ok := reg.(ChainRegistration); ok {
+			chain = c.GetChain()
+		}
+
+		err := s.register(reg.GetHandlersToRegister(), reg.GetBaseURI(), cors, chain)
 		if err != nil {
 			log.Fatalf("Error registering handlers: %s", err)
 		}
@@ -76,7 +132,7 @@ func New(cfg Configuration, registrators []HandlerRegistration) *Service {
 		if o == nil {
 			o = DefaultCORSOptions()
 		}
-		s.optionsChain = append(s.optionsChain, NewCORS(o))
+		s.cors = newCORS(o, s.routeMethods)
 	}
 
 	return s
@@ -84,15 +140,52 @@ func New(cfg Configuration, registrators []HandlerRegistration) *Service {
 
 // Register registers a list of handers/paths/methods wrapping them in the middleware chain
 func (s *Service) Register(r []Register, baseURI string) error {
+	return s.register(r, baseURI, nil, nil)
+}
+
+// RegisterGroup registers the handlers of the group with its CORS policy and middlewares.
+func (s *Service) RegisterGroup(g *Group) error {
+	return s.register(g.Registers, g.BaseURI, g.CORS, g.Chain)
+}
+
+// register registers the handlers, cors is the policy of the registrator used
+// for routes without an own one and chain are its middlewares.
+func (s *Service) register(r []Register, baseURI string, cors *CORSOptions, chain []Middleware) error {
 	for _, r := range r {
 		h := r.Handler
 
+		// the permissions are checked after the authentication in the chain
+		if !r.Permissions.empty() {
+			h = withAuthorization(h, s.authorizer, r.Permissions)
+		}
+
+		for i := len(chain) - 1; i >= 0; i-- {
+			h = chain[i](h)
+		}
 		for i := len(s.chain) - 1; i >= 0; i-- {
 			h = s.chain[i](h)
 		}
 
-		route := path.Join(baseURI, r.Path)
-		err := s.Route(r.Method, route, h)
+		if r.MaxBodySize != 0 {
+			h = withMaxBodySize(h, r.MaxBodySize)
+		}
+
+		rt := &route{
+			handler:         h,
+			name:            r.Name,
+			rateLimit:       r.RateLimit,
+			authenticator:   r.Authenticator,
+			permissions:     r.Permissions,
+			securityHeaders: r.SecurityHeaders,
+			idempotent:      r.Idempotent,
+		}
+		if r.CORS != nil {
+			rt.cors = newCORS(r.CORS, s.routeMethods)
+		} else if cors != nil {
+			rt.cors = newCORS(cors, s.routeMethods)
+		}
+
+		err := s.addRoute(r.Method, path.Join(baseURI, r.Path), rt)
 		if err != nil {
 			return err
 		}
@@ -103,28 +196,31 @@ func (s *Service) Register(r []Register, baseURI string) error {
 
 // ServeHTTP is the Entrypoint for an request.
 func (s *Service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
-	r.ParseForm()
+	ctx := withErrorHandler(r.Context(), s.errorHandler)
+	ctx = withDecodeOptions(ctx, s.decodeOptions)
+	r = r.WithContext(ctx)
+	parseForm(r)
 
 	if r.URL.Path != "/" && s.trimSlash {
 		r.URL.Path = strings.TrimRight(r.URL.Path, "/")
 	}
 
-	for _, p := range s.optionsChain {
-		p.ServeHTTP(w, r)
-	}
 	if r.Method == http.MethodOptions {
+		s.serveOptions(w, r)
 		return
 	}
-
-	var h http.Handler
-	var ps Params
-
-	n, ok := s.routes[r.Method]
-
-	if ok {
-		h, ps, _ = n.getValue(r.URL.Path)
-		ctx = context.WithValue(ctx, paramsKey{}, ps)
+	s.overrideMethod(r)
+
+	h, ps := s.lookup(r.Method, r.URL.Path)
+	ctx = context.WithValue(ctx, paramsKey{}, ps)
+
+	// Unmatched paths get the policy of the service, so cross-origin clients
+	// can read the 404 instead of seeing a network error. Route and group
+	// policies only apply to their routes.
+	if h != nil && h.cors != nil {
+		h.cors(w, r)
+	} else if s.cors != nil {
+		s.cors(w, r)
 	}
 
 	if h == nil {
@@ -134,26 +230,161 @@ func (s *Service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			http.NotFoundHandler().ServeHTTP(w, r)
 		}
 	} else {
-		ctx = context.WithValue(ctx, paramsKey{}, ps)
+		ctx = context.WithValue(ctx, routeKey{}, h)
 		h.ServeHTTP(w, r.WithContext(ctx))
 	}
 }
 
+// parseForm parses the query and url encoded body of the request. The body is
+// restored afterwards, so it can still be read, e.g. to verify its signature.
+func parseForm(r *http.Request) {
+	if r.Body == nil || r.Body == http.NoBody || normalizeMediaType(r.Header.Get("Content-Type")) != "application/x-www-form-urlencoded" {
+		r.ParseForm()
+		return
+	}
+
+	body := r.Body
+	buf, err := io.ReadAll(io.LimitReader(body, maxFormBodySize+1))
+	if err != nil || len(buf) > maxFormBodySize {
+		// only the query is parsed, the body is left to the handler
+		r.Body = http.NoBody
+		r.ParseForm()
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(buf), body), body}
+		return
+	}
+	body.Close()
+
+	r.Body = io.NopCloser(bytes.NewReader(buf))
+	r.ParseForm()
+	r.Body = io.NopCloser(bytes.NewReader(buf))
+}
+
+// overrideMethod replaces the method of POST requests by the one given in the
+// X-HTTP-Method-Override header or the _method form field of the body if it is
+// one of the configured methods, so the routes of that method are searched.
+func (s *Service) overrideMethod(r *http.Request) {
+	if s.methodOverride == nil || r.Method != http.MethodPost {
+		return
+	}
+
+	method := r.Header.Get(headerMethodOverride)
+	if method == "" {
+		method = r.PostForm.Get(formMethodOverride)
+	}
+	if method = strings.ToUpper(strings.TrimSpace(method)); s.methodOverride[method] {
+		r.Method = method
+	}
+}
+
+// serveOptions answers OPTIONS requests. Preflight requests are answered with
+// the CORS policy of the route matching the requested method and path, or the
+// policy of the service if there is none.
+func (s *Service) serveOptions(w http.ResponseWriter, r *http.Request) {
+	cors := s.cors
+
+	if method := r.Header.Get(headerRequestMethod); method != "" {
+		if h, _ := s.lookup(method, r.URL.Path); h != nil && h.cors != nil {
+			cors = h.cors
+		}
+	}
+
+	if cors != nil {
+		cors(w, r)
+	}
+}
+
+// lookup returns the route registered for the method and path.
+func (s *Service) lookup(method, path string) (*route, Params) {
+	n, ok := s.routes[method]
+	if !ok {
+		return nil, nil
+	}
+
+	h, ps, _ := n.getValue(path)
+	if h == nil {
+		return nil, ps
+	}
+	return h.(*route), ps
+}
+
+// routeMethods returns the sorted methods a handler is registered for the given path, except OPTIONS.
+func (s *Service) routeMethods(path string) []string {
+	var methods []string
+	for method, n := range s.routes {
+		if method == http.MethodOptions {
+			continue
+		}
+		if h, _, _ := n.getValue(path); h != nil {
+			methods = append(methods, method)
+		}
+	}
+	sort.Strings(methods)
+	return methods
+}
+
 func GetParams(ctx context.Context) Params {
 	return ctx.Value(paramsKey{}).(Params)
 }
 
+// RoutePattern returns the pattern of the route matched for the request, e.g. /v1/users/:id.
+func RoutePattern(ctx context.Context) string {
+	if rt, ok := ctx.Value(routeKey{}).(*route); ok {
+		return rt.pattern
+	}
+	return ""
+}
+
+// RouteName returns the name of the route matched for the request, or its pattern if it has no name.
+func RouteName(ctx context.Context) string {
+	if rt, ok := ctx.Value(routeKey{}).(*route); ok {
+		if rt.name != "" {
+			return rt.name
+		}
+		return rt.pattern
+	}
+	return ""
+}
+
 // Route registers a handler for certain http method/route
 func (s *Service) Route(method, uri string, handler http.Handler) error {
+	return s.addRoute(method, uri, &route{handler: handler})
+}
+
+func (s *Service) addRoute(method, uri string, rt *route) error {
 	if n := s.routes[method]; n == nil {
 		s.routes[method] = &node{}
 	}
 
-	s.routes[method].addRoute(path.Join(s.baseURI, strings.TrimRight(uri, "/")), handler)
+	rt.method = method
+	rt.pattern = path.Join(s.baseURI, strings.TrimRight(uri, "/"))
+	s.routes[method].addRoute(rt.pattern, rt)
+	s.routeList = append(s.routeList, rt)
 
 	return nil
 }
 
+// route is the handler stored in the routing tree together with its settings.
+type route struct {
+	handler http.Handler
+	method  string
+	pattern string
+	name    string
+	cors    http.HandlerFunc // CORS handler of the route, nil to use the one of the service
+
+	rateLimit       *RateLimit
+	authenticator   Authenticator
+	permissions     Permissions
+	securityHeaders *SecurityHeaders
+	idempotent      bool
+}
+
+func (rt *route) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rt.handler.ServeHTTP(w, r)
+}
+
 // Get registers a handler for GET and the given uri
 func (s *Service) Get(uri string, handler http.Handler) {
 	s.Route(http.MethodGet, uri, handler)
@@ -0,0 +1,95 @@
+package rest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMethodOverride(t *testing.T) {
+	var method string
+	s := New(Configuration{
+		CORS:           true,
+		MethodOverride: []string{"put", http.MethodDelete, http.MethodOptions, http.MethodGet, http.MethodHead},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			w.WriteHeader(http.StatusNotFound)
+		},
+	}, nil)
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		method = r.Method
+	}
+	s.Register([]Register{
+		{Method: http.MethodPost, Path: "/items", Handler: handler},
+		{Method: http.MethodPut, Path: "/items", Handler: handler},
+		{Method: http.MethodDelete, Path: "/items", Handler: handler},
+		{Method: http.MethodPatch, Path: "/items", Handler: handler},
+		{Method: http.MethodGet, Path: "/items", Handler: handler},
+	}, "")
+
+	testcases := []struct {
+		test     string
+		method   string
+		target   string
+		header   string
+		form     string
+		expected string
+	}{
+		{"header", http.MethodPost, "/items", "PUT", "", http.MethodPut},
+		{"header lower case", http.MethodPost, "/items", "delete", "", http.MethodDelete},
+		{"form field", http.MethodPost, "/items", "", "_method=PUT", http.MethodPut},
+		{"header preferred", http.MethodPost, "/items", "DELETE", "_method=PUT", http.MethodDelete},
+		{"not configured", http.MethodPost, "/items", "PATCH", "", http.MethodPost},
+		{"options never", http.MethodPost, "/items", "OPTIONS", "", http.MethodPost},
+		{"get never", http.MethodPost, "/items", "GET", "", http.MethodPost},
+		{"head never", http.MethodPost, "/items", "", "_method=HEAD", http.MethodPost},
+		{"post only", http.MethodGet, "/items", "DELETE", "", http.MethodGet},
+		{"query ignored", http.MethodPost, "/items?_method=PUT", "", "", http.MethodPost},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.test, func(t *testing.T) {
+			method = ""
+			r := httptest.NewRequest(tc.method, tc.target, strings.NewReader(tc.form))
+			if tc.form != "" {
+				r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			}
+			if tc.header != "" {
+				r.Header.Set(headerMethodOverride, tc.header)
+			}
+			s.ServeHTTP(httptest.NewRecorder(), r)
+
+			if method != tc.expected {
+				t.Errorf("expected method %s, got %s", tc.expected, method)
+			}
+		})
+	}
+
+	// preflight requests are answered for the requested method
+	method = ""
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodOptions, "/items", nil)
+	r.Header.Set(headerOrigin, "https://example.com")
+	r.Header.Set(headerRequestMethod, http.MethodPost)
+	r.Header.Set(headerMethodOverride, http.MethodDelete)
+	s.ServeHTTP(w, r)
+	if method != "" || w.Header().Get("Access-Control-Allow-Methods") == "" {
+		t.Errorf("expected preflight response without calling a handler, got method %q", method)
+	}
+}
+
+func TestMethodOverrideDisabled(t *testing.T) {
+	var method string
+	s := New(Configuration{}, nil)
+	s.Register([]Register{
+		{Method: http.MethodPost, Path: "/items", Handler: func(w http.ResponseWriter, r *http.Request) { method = r.Method }},
+		{Method: http.MethodDelete, Path: "/items", Handler: func(w http.ResponseWriter, r *http.Request) { method = r.Method }},
+	}, "")
+
+	r := httptest.NewRequest(http.MethodPost, "/items", nil)
+	r.Header.Set(headerMethodOverride, http.MethodDelete)
+	s.ServeHTTP(httptest.NewRecorder(), r)
+	if method != http.MethodPost {
+		t.Errorf("expected method POST, got %s", method)
+	}
+}
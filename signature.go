@@ -0,0 +1,628 @@
+package rest
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultSignatureRetention is the time signatures of messages without timestamp are remembered.
+const defaultSignatureRetention = time.Hour
+
+// Errors reported with status 401 by the signature verification.
+var (
+	ErrMissingSignature = errors.New("missing signature")
+	ErrInvalidSignature = errors.New("invalid signature")
+	ErrSignatureExpired = errors.New("signature outside of the time window")
+	ErrReplayedRequest  = errors.New("replayed request")
+)
+
+// SignedMessage is the signed content of a request extracted by a SignatureScheme.
+type SignedMessage struct {
+	// KeyID selects the secrets, empty if the scheme has no key IDs.
+	KeyID string
+	// Payload is the canonical message the HMAC-SHA256 is computed over.
+	Payload []byte
+	// Signatures are the candidate signatures, any of them must match.
+	Signatures [][]byte
+	// Timestamp is the time the request was signed, zero if the scheme has none.
+	Timestamp time.Time
+	// Expires is the time the signature expires, zero if it has no expiry.
+	Expires time.Time
+	// Nonce identifies the request for replay detection together with the
+	// matched signature. It must be covered by the signature.
+	Nonce string
+}
+
+// SignatureScheme extracts the signature and canonicalizes a request. It
+// returns ErrMissingSignature if the request is not signed by the scheme.
+type SignatureScheme interface {
+	Parse(r *http.Request, body []byte) (*SignedMessage, error)
+}
+
+// NonceStore remembers the nonces of verified requests. Implementations must be safe for concurrent use.
+type NonceStore interface {
+	// Use records the nonce until it expires and reports false if it is already recorded.
+	Use(nonce string, now, expires time.Time) (bool, error)
+}
+
+// SignatureOptions represents the available signature verification options
+type SignatureOptions struct {
+	// Scheme extracts the signature. If nil the GitHub scheme is used.
+	Scheme SignatureScheme
+	// Secrets returns the shared secrets of a key ID. Several secrets allow their rotation.
+	Secrets func(keyID string) ([][]byte, error)
+	// Window is the accepted age of signed timestamps and the time nonces are remembered. If 0 five minutes are used.
+	Window time.Duration
+	// Retention is the time signatures of messages without timestamp, e.g. of
+	// GitHub, are remembered to reject replays. If 0 one hour is used. Replays
+	// after it are accepted, so schemes with timestamps should be preferred.
+	// Redeliveries of GitHub reuse the signature of the delivery, so they are
+	// rejected as replays during the Retention.
+	Retention time.Duration
+	// Nonces remembers verified requests to reject replays. If nil an in-memory store is used.
+	Nonces NonceStore
+}
+
+// NewSignatureVerification creates a middleware verifying HMAC-SHA256 signed
+// requests. Requests without valid signature, signed outside of the time
+// window, expired or replayed while their signature is remembered are answered with
+// status 401 through the ErrorHandler. The body, limited by MaxBodySize, is
+// restored for the handler. It panics if Secrets is nil.
+func NewSignatureVerification(opts *SignatureOptions) Middleware {
+	return newSignatureVerification(opts, time.Now)
+}
+
+func newSignatureVerification(opts *SignatureOptions, now func() time.Time) Middleware {
+	var o SignatureOptions
+	if opts != nil {
+		o = *opts
+	}
+	if o.Secrets == nil {
+		panic("rest: SignatureOptions.Secrets is required")
+	}
+	if o.Scheme == nil {
+		o.Scheme = GitHubSignature()
+	}
+	if o.Window <= 0 {
+		o.Window = 5 * time.Minute
+	}
+	if o.Retention <= 0 {
+		o.Retention = defaultSignatureRetention
+	}
+	if o.Nonces == nil {
+		o.Nonces = NewMemoryNonceStore()
+	}
+
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			body, err := readBody(r)
+			if err != nil {
+				HandleError(w, r, err)
+				return
+			}
+
+			if err := o.verify(r, body, now()); err != nil {
+				if errors.Is(err, ErrMissingSignature) || errors.Is(err, ErrInvalidSignature) ||
+					errors.Is(err, ErrSignatureExpired) || errors.Is(err, ErrReplayedRequest) {
+					err = NewStatusError(http.StatusUnauthorized, err)
+				}
+				HandleError(w, r, err)
+				return
+			}
+
+			next(w, r)
+		}
+	}
+}
+
+// readBody reads the body limited by the MaxBodySize and restores it for the handler.
+func readBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, nil
+	}
+
+	limit := getDecodeOptions(r.Context()).maxBodySize
+	if limit > 0 && r.ContentLength > limit {
+		return nil, NewStatusError(http.StatusRequestEntityTooLarge, ErrBodyTooLarge)
+	}
+	body, err := io.ReadAll(limitReader(r.Body, limit))
+	r.Body.Close()
+	if errors.Is(err, ErrBodyTooLarge) {
+		return nil, NewStatusError(http.StatusRequestEntityTooLarge, ErrBodyTooLarge)
+	}
+	if err != nil {
+		return nil, NewStatusError(http.StatusBadRequest, err)
+	}
+
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+func (o *SignatureOptions) verify(r *http.Request, body []byte, now time.Time) error {
+	msg, err := o.Scheme.Parse(r, body)
+	if err != nil {
+		return err
+	}
+
+	if !msg.Timestamp.IsZero() && (now.Sub(msg.Timestamp) > o.Window || msg.Timestamp.Sub(now) > o.Window) {
+		return ErrSignatureExpired
+	}
+	if !msg.Expires.IsZero() && !now.Before(msg.Expires) {
+		return ErrSignatureExpired
+	}
+
+	secrets, err := o.Secrets(msg.KeyID)
+	if err != nil {
+		return err
+	}
+
+	var matched []byte
+	for _, secret := range secrets {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(msg.Payload)
+		expected := mac.Sum(nil)
+		for _, sig := range msg.Signatures {
+			if hmac.Equal(sig, expected) {
+				matched = sig
+			}
+		}
+	}
+	if matched == nil {
+		return ErrInvalidSignature
+	}
+
+	// unsigned values can't identify a request, they could be changed by a replay
+	expires := now.Add(o.Retention)
+	if !msg.Timestamp.IsZero() {
+		expires = msg.Timestamp.Add(o.Window)
+	}
+	fresh, err := o.Nonces.Use(msg.KeyID+"|"+msg.Nonce+"|"+hex.EncodeToString(matched), now, expires)
+	if err != nil {
+		return err
+	}
+	if !fresh {
+		return ErrReplayedRequest
+	}
+	return nil
+}
+
+// MemoryNonceStore is an in-memory NonceStore.
+type MemoryNonceStore struct {
+	mu     sync.Mutex
+	nonces map[string]time.Time
+	ops    int
+}
+
+// NewMemoryNonceStore creates a new MemoryNonceStore.
+func NewMemoryNonceStore() *MemoryNonceStore {
+	return &MemoryNonceStore{nonces: map[string]time.Time{}}
+}
+
+// Use records the nonce until it expires and reports false if it is already recorded.
+func (s *MemoryNonceStore) Use(nonce string, now, expires time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ops++; s.ops >= sweepInterval {
+		s.ops = 0
+		for n, e := range s.nonces {
+			if now.After(e) {
+				delete(s.nonces, n)
+			}
+		}
+	}
+
+	if e, ok := s.nonces[nonce]; ok && now.Before(e) {
+		return false, nil
+	}
+	s.nonces[nonce] = expires
+	return true, nil
+}
+
+// GitHubSignature verifies the X-Hub-Signature-256 header of GitHub webhooks,
+// a hex encoded signature of the body. As the webhooks have no timestamp and
+// the X-GitHub-Delivery header is not signed, replays are detected by the
+// signature for the Retention of the SignatureOptions. Redeliveries have the
+// signature of the original delivery and are rejected during the Retention too.
+func GitHubSignature() SignatureScheme {
+	return githubSignature{}
+}
+
+type githubSignature struct{}
+
+func (githubSignature) Parse(r *http.Request, body []byte) (*SignedMessage, error) {
+	header := r.Header.Get("X-Hub-Signature-256")
+	if header == "" {
+		return nil, ErrMissingSignature
+	}
+	sig, err := hex.DecodeString(strings.TrimPrefix(header, "sha256="))
+	if err != nil || !strings.HasPrefix(header, "sha256=") {
+		return nil, ErrInvalidSignature
+	}
+	return &SignedMessage{
+		Payload:    body,
+		Signatures: [][]byte{sig},
+	}, nil
+}
+
+// StripeSignature verifies the Stripe-Signature header of Stripe webhooks
+// with its timestamp t and one or more hex encoded v1 signatures of "t.body".
+func StripeSignature() SignatureScheme {
+	return stripeSignature{}
+}
+
+type stripeSignature struct{}
+
+func (stripeSignature) Parse(r *http.Request, body []byte) (*SignedMessage, error) {
+	header := r.Header.Get("Stripe-Signature")
+	if header == "" {
+		return nil, ErrMissingSignature
+	}
+
+	msg := &SignedMessage{}
+	var t string
+	for _, part := range strings.Split(header, ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch k {
+		case "t":
+			t = v
+		case "v1":
+			if sig, err := hex.DecodeString(v); err == nil {
+				msg.Signatures = append(msg.Signatures, sig)
+			}
+		}
+	}
+	ts, err := strconv.ParseInt(t, 10, 64)
+	if err != nil || len(msg.Signatures) == 0 {
+		return nil, ErrInvalidSignature
+	}
+
+	msg.Timestamp = time.Unix(ts, 0)
+	msg.Payload = append([]byte(t+"."), body...)
+	return msg, nil
+}
+
+// CanonicalSignatureOptions represents the options of a custom signature scheme
+type CanonicalSignatureOptions struct {
+	// SignatureHeader carries the hex or base64 encoded signature. If empty X-Signature is used.
+	SignatureHeader string
+	// KeyIDHeader carries the key ID, if set.
+	KeyIDHeader string
+	// TimestampHeader carries the time of signing in Unix seconds. If empty X-Timestamp is used.
+	TimestampHeader string
+	// NonceHeader carries the nonce, if set.
+	NonceHeader string
+	// Headers are further signed headers.
+	Headers []string
+}
+
+// CanonicalSignature verifies signatures of a canonical message consisting of
+// the lines method, request target (path and query), the signed headers as
+// "name:value", timestamp, nonce and the hex encoded SHA-256 of the body.
+func CanonicalSignature(opts *CanonicalSignatureOptions) SignatureScheme {
+	c := canonicalSignature{}
+	if opts != nil {
+		c.opts = *opts
+	}
+	if c.opts.SignatureHeader == "" {
+		c.opts.SignatureHeader = "X-Signature"
+	}
+	if c.opts.TimestampHeader == "" {
+		c.opts.TimestampHeader = "X-Timestamp"
+	}
+	return c
+}
+
+type canonicalSignature struct {
+	opts CanonicalSignatureOptions
+}
+
+func (c canonicalSignature) Parse(r *http.Request, body []byte) (*SignedMessage, error) {
+	header := r.Header.Get(c.opts.SignatureHeader)
+	if header == "" {
+		return nil, ErrMissingSignature
+	}
+	sig, err := hex.DecodeString(header)
+	if err != nil {
+		if sig, err = base64.StdEncoding.DecodeString(header); err != nil {
+			return nil, ErrInvalidSignature
+		}
+	}
+
+	t := r.Header.Get(c.opts.TimestampHeader)
+	ts, err := strconv.ParseInt(t, 10, 64)
+	if err != nil {
+		return nil, ErrInvalidSignature
+	}
+
+	msg := &SignedMessage{Signatures: [][]byte{sig}, Timestamp: time.Unix(ts, 0)}
+	if c.opts.KeyIDHeader != "" {
+		msg.KeyID = r.Header.Get(c.opts.KeyIDHeader)
+	}
+	if c.opts.NonceHeader != "" {
+		msg.Nonce = r.Header.Get(c.opts.NonceHeader)
+	}
+
+	sum := sha256.Sum256(body)
+	var b strings.Builder
+	b.WriteString(r.Method + "\n")
+	b.WriteString(requestTarget(r).RequestURI() + "\n")
+	for _, name := range c.opts.Headers {
+		b.WriteString(strings.ToLower(name) + ":" + strings.TrimSpace(r.Header.Get(name)) + "\n")
+	}
+	b.WriteString(t + "\n")
+	b.WriteString(msg.Nonce + "\n")
+	b.WriteString(hex.EncodeToString(sum[:]))
+	msg.Payload = []byte(b.String())
+	return msg, nil
+}
+
+// requestTarget returns the URL as sent by the client, before the service trimmed trailing slashes.
+func requestTarget(r *http.Request) *url.URL {
+	if r.RequestURI != "" {
+		if u, err := url.ParseRequestURI(r.RequestURI); err == nil {
+			return u
+		}
+	}
+	return r.URL
+}
+
+// MessageSignatureOptions represents the options of HTTP Message Signatures
+type MessageSignatureOptions struct {
+	// Label selects the verified signature. If empty the first one is used.
+	Label string
+	// RequiredComponents must be covered by the signature. @path is also
+	// covered by @target-uri and @request-target, content-digest is only
+	// required for requests with a body. If nil @method, @path and
+	// content-digest are required.
+	RequiredComponents []string
+}
+
+// HTTPMessageSignature verifies signatures of HTTP Message Signatures (RFC 9421)
+// with the algorithm hmac-sha256. The created, expires and nonce parameters are
+// the timestamp, expiry and nonce. Signatures without created are remembered
+// for the Retention of the SignatureOptions to reject replays. Signatures not
+// covering the RequiredComponents are invalid. If the content-digest field is
+// covered it is checked against the body.
+func HTTPMessageSignature(opts *MessageSignatureOptions) SignatureScheme {
+	m := messageSignature{}
+	if opts != nil {
+		m.opts = *opts
+	}
+	if m.opts.RequiredComponents == nil {
+		m.opts.RequiredComponents = []string{"@method", "@path", "content-digest"}
+	}
+	return m
+}
+
+type messageSignature struct {
+	opts MessageSignatureOptions
+}
+
+func (m messageSignature) Parse(r *http.Request, body []byte) (*SignedMessage, error) {
+	inputs := parseSFDictionary(r.Header.Get("Signature-Input"))
+	signatures := parseSFDictionary(r.Header.Get("Signature"))
+	if len(inputs) == 0 || len(signatures) == 0 {
+		return nil, ErrMissingSignature
+	}
+
+	label := m.opts.Label
+	if label == "" {
+		label = inputs[0].key
+	}
+	input, ok := sfLookup(inputs, label)
+	if !ok {
+		return nil, ErrMissingSignature
+	}
+	encoded, ok := sfLookup(signatures, label)
+	if !ok || len(encoded) < 2 || encoded[0] != ':' || encoded[len(encoded)-1] != ':' {
+		return nil, ErrInvalidSignature
+	}
+	sig, err := base64.StdEncoding.DecodeString(encoded[1 : len(encoded)-1])
+	if err != nil {
+		return nil, ErrInvalidSignature
+	}
+
+	end := strings.IndexByte(input, ')')
+	if !strings.HasPrefix(input, "(") || end < 0 {
+		return nil, ErrInvalidSignature
+	}
+	components := strings.Fields(input[1:end])
+	for _, required := range m.opts.RequiredComponents {
+		if required == "content-digest" && len(body) == 0 {
+			continue
+		}
+		if !coversComponent(components, required) {
+			return nil, fmt.Errorf("%w: component %q not covered", ErrInvalidSignature, required)
+		}
+	}
+
+	msg := &SignedMessage{Signatures: [][]byte{sig}}
+	for _, param := range strings.Split(input[end+1:], ";")[1:] {
+		k, v, _ := strings.Cut(param, "=")
+		v = strings.Trim(v, `"`)
+		switch k {
+		case "keyid":
+			msg.KeyID = v
+		case "nonce":
+			msg.Nonce = v
+		case "alg":
+			if v != "hmac-sha256" {
+				return nil, fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidSignature, v)
+			}
+		case "created":
+			ts, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return nil, ErrInvalidSignature
+			}
+			msg.Timestamp = time.Unix(ts, 0)
+		case "expires":
+			ts, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return nil, ErrInvalidSignature
+			}
+			msg.Expires = time.Unix(ts, 0)
+		}
+	}
+
+	var b strings.Builder
+	for _, c := range components {
+		if len(c) < 2 || c[0] != '"' || c[len(c)-1] != '"' {
+			return nil, fmt.Errorf("%w: unsupported component %s", ErrInvalidSignature, c)
+		}
+		name := c[1 : len(c)-1]
+		value, err := componentValue(r, name, body)
+		if err != nil {
+			return nil, err
+		}
+		b.WriteString(c + ": " + value + "\n")
+	}
+	b.WriteString(`"@signature-params": ` + input)
+	msg.Payload = []byte(b.String())
+	return msg, nil
+}
+
+// coversComponent reports whether the quoted components contain the required
+// one. @path is also covered by the components containing the path.
+func coversComponent(components []string, required string) bool {
+	for _, c := range components {
+		switch name := strings.Trim(c, `"`); {
+		case name == required:
+			return true
+		case required == "@path" && (name == "@target-uri" || name == "@request-target"):
+			return true
+		}
+	}
+	return false
+}
+
+// componentValue returns the value of a component of the signature base.
+func componentValue(r *http.Request, name string, body []byte) (string, error) {
+	u := requestTarget(r)
+	switch name {
+	case "@method":
+		return r.Method, nil
+	case "@authority":
+		return strings.ToLower(r.Host), nil
+	case "@scheme":
+		if r.TLS != nil {
+			return "https", nil
+		}
+		return "http", nil
+	case "@target-uri":
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+		return scheme + "://" + strings.ToLower(r.Host) + u.RequestURI(), nil
+	case "@request-target":
+		return u.RequestURI(), nil
+	case "@path":
+		if p := u.EscapedPath(); p != "" {
+			return p, nil
+		}
+		return "/", nil
+	case "@query":
+		return "?" + u.RawQuery, nil
+	}
+	if strings.HasPrefix(name, "@") || name != strings.ToLower(name) {
+		return "", fmt.Errorf("%w: unsupported component %q", ErrInvalidSignature, name)
+	}
+
+	if len(r.Header.Values(name)) == 0 {
+		return "", fmt.Errorf("%w: missing signed field %q", ErrInvalidSignature, name)
+	}
+	var values []string
+	for _, v := range r.Header.Values(name) {
+		values = append(values, strings.TrimSpace(v))
+	}
+	value := strings.Join(values, ", ")
+	if name == "content-digest" && !contentDigestMatches(value, body) {
+		return "", fmt.Errorf("%w: content digest mismatch", ErrInvalidSignature)
+	}
+	return value, nil
+}
+
+// contentDigestMatches checks the sha-256 and sha-512 digests of a Content-Digest field (RFC 9530).
+func contentDigestMatches(field string, body []byte) bool {
+	checked := false
+	for _, d := range parseSFDictionary(field) {
+		var sum []byte
+		switch d.key {
+		case "sha-256":
+			s := sha256.Sum256(body)
+			sum = s[:]
+		case "sha-512":
+			s := sha512.Sum512(body)
+			sum = s[:]
+		default:
+			continue
+		}
+		if d.value != ":"+base64.StdEncoding.EncodeToString(sum)+":" {
+			return false
+		}
+		checked = true
+	}
+	return checked
+}
+
+type sfMember struct {
+	key   string
+	value string
+}
+
+// parseSFDictionary splits a structured field dictionary (RFC 8941) into its members keeping their serialized values.
+func parseSFDictionary(field string) []sfMember {
+	var (
+		members []sfMember
+		start   int
+		quoted  bool
+		depth   int
+	)
+	add := func(s string) {
+		s = strings.TrimSpace(s)
+		if k, v, ok := strings.Cut(s, "="); ok {
+			members = append(members, sfMember{key: strings.TrimSpace(k), value: strings.TrimSpace(v)})
+		}
+	}
+	for i := 0; i < len(field); i++ {
+		switch c := field[i]; {
+		case quoted && c == '\\':
+			i++
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ',' && depth == 0:
+			add(field[start:i])
+			start = i + 1
+		}
+	}
+	add(field[start:])
+	return members
+}
+
+func sfLookup(members []sfMember, key string) (string, bool) {
+	for _, m := range members {
+		if m.key == key {
+			return m.value, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,242 @@
+package rest
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func hmacSHA256(secret, payload string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+func TestSignatureVerification(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	ts := strconv.FormatInt(now.Unix(), 10)
+	old := strconv.FormatInt(now.Add(-10*time.Minute).Unix(), 10)
+	body := `{"event":"push"}`
+	bodyDigest := sha256.Sum256([]byte(body))
+
+	github := func(secret, delivery string) func(r *http.Request) {
+		return func(r *http.Request) {
+			r.Header.Set("X-Hub-Signature-256", "sha256="+hex.EncodeToString(hmacSHA256(secret, body)))
+			r.Header.Set("X-GitHub-Delivery", delivery)
+		}
+	}
+	stripe := func(t string) func(r *http.Request) {
+		return func(r *http.Request) {
+			r.Header.Set("Stripe-Signature", "t="+t+",v1="+hex.EncodeToString(hmacSHA256("other", t+"."+body))+",v1="+hex.EncodeToString(hmacSHA256("secret", t+"."+body)))
+		}
+	}
+	canonical := func(path, nonce string) func(r *http.Request) {
+		return func(r *http.Request) {
+			payload := "POST\n" + path + "\nx-tenant:acme\n" + ts + "\n" + nonce + "\n" + hex.EncodeToString(bodyDigest[:])
+			r.Header.Set("X-Key-Id", "k1")
+			r.Header.Set("X-Tenant", "acme")
+			r.Header.Set("X-Timestamp", ts)
+			r.Header.Set("X-Nonce", nonce)
+			r.Header.Set("X-Signature", base64.StdEncoding.EncodeToString(hmacSHA256("k1-secret", payload)))
+		}
+	}
+	digest := "sha-256=:" + base64.StdEncoding.EncodeToString(bodyDigest[:]) + ":"
+	values := map[string]string{
+		"@method":        "POST",
+		"@path":          "/hooks/",
+		"@query":         "?a=1",
+		"@authority":     "example.com",
+		"@target-uri":    "http://example.com/hooks/?a=1",
+		"content-type":   "application/json",
+		"content-digest": digest,
+	}
+	signed := func(components []string, params, contentDigest string) func(r *http.Request) {
+		return func(r *http.Request) {
+			var lines, quoted []string
+			for _, c := range components {
+				lines = append(lines, `"`+c+`": `+values[c])
+				quoted = append(quoted, `"`+c+`"`)
+			}
+			params = "(" + strings.Join(quoted, " ") + ")" + params
+			base := strings.Join(append(lines, `"@signature-params": `+params), "\n")
+			r.Header.Set("Content-Type", "application/json")
+			r.Header.Set("Content-Digest", contentDigest)
+			r.Header.Set("Signature-Input", "sig0=(\"@method\");created="+ts+", sig1="+params)
+			r.Header.Set("Signature", "sig0=:AAAA:, sig1=:"+base64.StdEncoding.EncodeToString(hmacSHA256("k1-secret", base))+":")
+		}
+	}
+	message := func(nonce, contentDigest string) func(r *http.Request) {
+		components := []string{"@method", "@path", "@query", "@authority", "content-type", "content-digest"}
+		return signed(components, `;created=`+ts+`;keyid="k1";alg="hmac-sha256";nonce="`+nonce+`"`, contentDigest)
+	}
+	sig1 := &MessageSignatureOptions{Label: "sig1"}
+
+	testcases := []struct {
+		test   string
+		scheme SignatureScheme
+		path   string
+		sign   []func(r *http.Request) // requests sent in order, the last one is checked
+		code   int
+		err    error
+	}{
+		{"github", GitHubSignature(), "/hooks", []func(*http.Request){github("secret", "d1")}, 200, nil},
+		{"github rotated secret", GitHubSignature(), "/hooks", []func(*http.Request){github("old-secret", "d1")}, 200, nil},
+		{"github wrong secret", GitHubSignature(), "/hooks", []func(*http.Request){github("wrong", "d1")}, 401, ErrInvalidSignature},
+		{"github missing", GitHubSignature(), "/hooks", []func(*http.Request){func(r *http.Request) {}}, 401, ErrMissingSignature},
+		{"github replay", GitHubSignature(), "/hooks", []func(*http.Request){github("secret", "d1"), github("secret", "d1")}, 401, ErrReplayedRequest},
+		{"github replay with other delivery", GitHubSignature(), "/hooks", []func(*http.Request){github("secret", "d1"), github("secret", "d2")}, 401, ErrReplayedRequest},
+		{"stripe", StripeSignature(), "/hooks", []func(*http.Request){stripe(ts)}, 200, nil},
+		{"stripe expired", StripeSignature(), "/hooks", []func(*http.Request){stripe(old)}, 401, ErrSignatureExpired},
+		{"stripe replay", StripeSignature(), "/hooks", []func(*http.Request){stripe(ts), stripe(ts)}, 401, ErrReplayedRequest},
+		{"canonical", CanonicalSignature(&CanonicalSignatureOptions{KeyIDHeader: "X-Key-Id", NonceHeader: "X-Nonce", Headers: []string{"X-Tenant"}}), "/hooks/?a=1", []func(*http.Request){canonical("/hooks/?a=1", "n1")}, 200, nil},
+		{"canonical other path", CanonicalSignature(&CanonicalSignatureOptions{KeyIDHeader: "X-Key-Id", NonceHeader: "X-Nonce", Headers: []string{"X-Tenant"}}), "/other", []func(*http.Request){canonical("/hooks/?a=1", "n1")}, 401, ErrInvalidSignature},
+		{"message signature", HTTPMessageSignature(sig1), "/hooks/?a=1", []func(*http.Request){message("n1", digest)}, 200, nil},
+		{"message signature replay", HTTPMessageSignature(sig1), "/hooks/?a=1", []func(*http.Request){message("n1", digest), message("n1", digest)}, 401, ErrReplayedRequest},
+		{"message signature wrong label", HTTPMessageSignature(nil), "/hooks/?a=1", []func(*http.Request){message("n1", digest)}, 401, ErrInvalidSignature},
+		{"message signature expired", HTTPMessageSignature(sig1), "/hooks/?a=1", []func(*http.Request){signed([]string{"@method", "@path", "content-digest"}, `;created=`+ts+`;expires=`+ts+`;keyid="k1"`, digest)}, 401, ErrSignatureExpired},
+		{"message signature target uri", HTTPMessageSignature(sig1), "/hooks/?a=1", []func(*http.Request){signed([]string{"@method", "@target-uri", "content-digest"}, `;created=`+ts+`;keyid="k1"`, digest)}, 200, nil},
+		{"message signature authority only", HTTPMessageSignature(sig1), "/hooks/?a=1", []func(*http.Request){signed([]string{"@authority"}, `;created=`+ts+`;keyid="k1"`, digest)}, 401, ErrInvalidSignature},
+		{"message signature without digest", HTTPMessageSignature(sig1), "/hooks/?a=1", []func(*http.Request){signed([]string{"@method", "@path"}, `;created=`+ts+`;keyid="k1"`, digest)}, 401, ErrInvalidSignature},
+		{"message signature custom components", HTTPMessageSignature(&MessageSignatureOptions{Label: "sig1", RequiredComponents: []string{"@authority"}}), "/hooks/?a=1", []func(*http.Request){signed([]string{"@authority"}, `;created=`+ts+`;keyid="k1"`, digest)}, 200, nil},
+		{"message signature digest mismatch", HTTPMessageSignature(sig1), "/hooks/?a=1", []func(*http.Request){message("n1", "sha-256=:AAAA:")}, 401, ErrInvalidSignature},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.test, func(t *testing.T) {
+			var (
+				reported error
+				received string
+			)
+			s := New(Configuration{
+				Chain: []Middleware{newSignatureVerification(&SignatureOptions{
+					Scheme: tc.scheme,
+					Secrets: func(keyID string) ([][]byte, error) {
+						if keyID == "k1" {
+							return [][]byte{[]byte("k1-secret")}, nil
+						}
+						return [][]byte{[]byte("secret"), []byte("old-secret")}, nil
+					},
+				}, func() time.Time { return now })},
+				ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+					reported = err
+					w.WriteHeader(ErrorStatus(err))
+				},
+			}, nil)
+			handler := func(w http.ResponseWriter, r *http.Request) {
+				b, _ := io.ReadAll(r.Body)
+				received = string(b)
+			}
+			s.Register([]Register{
+				{Method: http.MethodPost, Path: "/hooks", Handler: handler},
+				{Method: http.MethodPost, Path: "/other", Handler: handler},
+			}, "")
+
+			var w *httptest.ResponseRecorder
+			for _, sign := range tc.sign {
+				reported = nil
+				w = httptest.NewRecorder()
+				r := httptest.NewRequest(http.MethodPost, "http://example.com"+tc.path, strings.NewReader(body))
+				sign(r)
+				s.ServeHTTP(w, r)
+			}
+
+			if w.Code != tc.code {
+				t.Errorf("expected status %d, got %d (%v)", tc.code, w.Code, reported)
+			}
+			if tc.err != nil && !errors.Is(reported, tc.err) {
+				t.Errorf("expected error %v, got %v", tc.err, reported)
+			}
+			if tc.code == 200 && received != body {
+				t.Errorf("expected body %q for the handler, got %q", body, received)
+			}
+		})
+	}
+}
+
+func TestSignatureVerificationForm(t *testing.T) {
+	body := "action=opened&number=1"
+	var (
+		reported       error
+		received, form string
+	)
+	s := New(Configuration{
+		Chain: []Middleware{NewSignatureVerification(&SignatureOptions{
+			Secrets: func(string) ([][]byte, error) { return [][]byte{[]byte("secret")}, nil },
+		})},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			reported = err
+			w.WriteHeader(ErrorStatus(err))
+		},
+	}, nil)
+	s.Register([]Register{{Method: http.MethodPost, Path: "/hooks", Handler: func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		received, form = string(b), r.PostForm.Get("action")
+	}}}, "")
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/hooks?q=1", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("X-Hub-Signature-256", "sha256="+hex.EncodeToString(hmacSHA256("secret", body)))
+	s.ServeHTTP(w, r)
+
+	if w.Code != 200 {
+		t.Fatalf("expected status 200, got %d (%v)", w.Code, reported)
+	}
+	if received != body || form != "opened" {
+		t.Errorf("expected body %q and parsed form for the handler, got %q and %q", body, received, form)
+	}
+}
+
+func TestSignatureRetention(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	body := `{"event":"push"}`
+	h := newSignatureVerification(&SignatureOptions{
+		Secrets: func(string) ([][]byte, error) { return [][]byte{[]byte("secret")}, nil },
+		Window:  time.Minute,
+	}, func() time.Time { return now })(func(w http.ResponseWriter, r *http.Request) {})
+
+	testcases := []struct {
+		after time.Duration
+		code  int
+	}{
+		{0, 200},
+		{2 * time.Minute, 401},
+		{59 * time.Minute, 401},
+		{time.Hour, 200},
+	}
+	start := now
+	for _, tc := range testcases {
+		now = start.Add(tc.after)
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/hooks", strings.NewReader(body))
+		r.Header.Set("X-Hub-Signature-256", "sha256="+hex.EncodeToString(hmacSHA256("secret", body)))
+		h(w, r)
+		if w.Code != tc.code {
+			t.Errorf("after %s: expected status %d, got %d", tc.after, tc.code, w.Code)
+		}
+	}
+}
+
+func TestMemoryNonceStore(t *testing.T) {
+	s := NewMemoryNonceStore()
+	now := time.Unix(0, 0)
+
+	if ok, _ := s.Use("n", now, now.Add(time.Minute)); !ok {
+		t.Errorf("expected unused nonce")
+	}
+	if ok, _ := s.Use("n", now.Add(30*time.Second), now.Add(90*time.Second)); ok {
+		t.Errorf("expected used nonce")
+	}
+	if ok, _ := s.Use("n", now.Add(time.Minute), now.Add(2*time.Minute)); !ok {
+		t.Errorf("expected expired nonce to be usable")
+	}
+}